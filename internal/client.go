@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv" // Added import
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +25,23 @@ const (
 	LogLevelDebug = "debug"
 )
 
+// DefaultMaxResponseSize caps API responses so a misbehaving API cannot
+// exhaust memory during a poll.
+const DefaultMaxResponseSize = 32 << 20
+
+// PluginVersion is the released version of the plugin.
+const PluginVersion = "v0.7.0"
+
+// DefaultUserAgent identifies the plugin in the Proxmox access logs.
+const DefaultUserAgent = "traefik-proxmox-provider/" + PluginVersion
+
+// errorBodyLimit is how much of a response body is quoted in errors.
+const errorBodyLimit = 512
+
+// ticketLifetime is how long a login ticket is reused. Proxmox tickets are
+// valid for two hours, so they are renewed well before they expire.
+const ticketLifetime = 90 * time.Minute
+
 // ProxmoxClient represents a client to the Proxmox API
 type ProxmoxClient struct {
 	BaseURL     string
@@ -27,6 +50,22 @@ type ProxmoxClient struct {
 	HTTPClient  *http.Client
 	LogLevel    string
 	ValidateSSL bool
+	// MaxResponseSize is the largest response body accepted, in bytes.
+	MaxResponseSize int64
+	// User and Password enable ticket authentication instead of an API token.
+	// User must include the realm, e.g. traefik@pve.
+	User     string
+	Password string
+	// UserAgent is sent with every request, DefaultUserAgent unless changed.
+	UserAgent string
+	// Headers are added to every request, e.g. to identify the Traefik
+	// instance in the Proxmox access logs.
+	Headers http.Header
+
+	ticketMu      sync.Mutex
+	ticket        string
+	csrfToken     string
+	ticketExpires time.Time
 }
 
 // NewProxmoxClient creates a new Proxmox API client
@@ -40,19 +79,151 @@ func NewProxmoxClient(apiEndpoint, tokenID, token string, validateSSL bool, logL
 		Timeout: 30 * time.Second,
 	}
 
-	baseURL := fmt.Sprintf("%s/api2/json", apiEndpoint)
+	// The endpoint may carry a path prefix when Proxmox sits behind a reverse
+	// proxy, so the API path is appended rather than set on the host root.
+	baseURL := fmt.Sprintf("%s/api2/json", strings.TrimSuffix(strings.TrimRight(apiEndpoint, "/"), "/api2/json"))
 	if logLevel == LogLevelDebug {
 		log.Printf("Creating new Proxmox client with base URL: %s", baseURL)
 	}
 
 	return &ProxmoxClient{
-		BaseURL:     baseURL,
-		TokenID:     tokenID,
-		Token:       token,
-		HTTPClient:  httpClient,
-		LogLevel:    logLevel,
-		ValidateSSL: validateSSL,
+		BaseURL:         baseURL,
+		TokenID:         tokenID,
+		Token:           token,
+		HTTPClient:      httpClient,
+		LogLevel:        logLevel,
+		ValidateSSL:     validateSSL,
+		MaxResponseSize: DefaultMaxResponseSize,
+		UserAgent:       DefaultUserAgent,
+		Headers:         make(http.Header),
+	}
+}
+
+// setIdentifyingHeaders sets the User-Agent and the configured extra headers.
+func (c *ProxmoxClient) setIdentifyingHeaders(req *http.Request) {
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	for name, values := range c.Headers {
+		req.Header[name] = values
+	}
+}
+
+// SetClientCertificate loads a client certificate and key from PEM files and
+// presents them on every connection, for API gateways requiring mutual TLS.
+func (c *ProxmoxClient) SetClientCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unsupported HTTP transport %T", c.HTTPClient.Transport)
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+// LoadCertPool reads the PEM certificates of a CA bundle.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// SetRootCAs verifies the API certificate against the CAs in a PEM bundle
+// instead of the system roots. Verification is enabled even when the client
+// was created with validateSSL false.
+func (c *ProxmoxClient) SetRootCAs(caFile string) error {
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		return err
+	}
+
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unsupported HTTP transport %T", c.HTTPClient.Transport)
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	transport.TLSClientConfig.InsecureSkipVerify = false
+	c.ValidateSSL = true
+	return nil
+}
+
+// SetPasswordAuth switches the client to ticket authentication with a
+// user@realm and password.
+func (c *ProxmoxClient) SetPasswordAuth(user, password string) {
+	c.User = user
+	c.Password = password
+}
+
+// getTicket returns a valid login ticket and CSRF token, logging in again when
+// the cached ticket is missing or about to expire.
+func (c *ProxmoxClient) getTicket(ctx context.Context) (string, string, error) {
+	c.ticketMu.Lock()
+	defer c.ticketMu.Unlock()
+
+	if c.ticket != "" && time.Now().Before(c.ticketExpires) {
+		return c.ticket, c.csrfToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("username", c.User)
+	form.Set("password", c.Password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/access/ticket", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	c.setIdentifyingHeaders(req)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to execute login request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", "", fmt.Errorf("login as %s failed: %w", c.User, &APIError{StatusCode: resp.StatusCode, Endpoint: "/access/ticket"})
+	}
+
+	var response struct {
+		Data struct {
+			Ticket    string `json:"ticket"`
+			CSRFToken string `json:"CSRFPreventionToken"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", "", fmt.Errorf("failed to decode login response: %w", err)
+	}
+	if response.Data.Ticket == "" {
+		return "", "", fmt.Errorf("login as %s returned no ticket", c.User)
+	}
+
+	c.ticket = response.Data.Ticket
+	c.csrfToken = response.Data.CSRFToken
+	c.ticketExpires = time.Now().Add(ticketLifetime)
+	if c.LogLevel == LogLevelDebug {
+		log.Printf("Obtained Proxmox ticket for %s", c.User)
 	}
+	return c.ticket, c.csrfToken, nil
+}
+
+// resetTicket drops the cached ticket so the next request logs in again.
+func (c *ProxmoxClient) resetTicket() {
+	c.ticketMu.Lock()
+	defer c.ticketMu.Unlock()
+	c.ticket = ""
+	c.csrfToken = ""
 }
 
 // Do performs an HTTP request to the Proxmox API
@@ -78,8 +249,20 @@ func (c *ProxmoxClient) Do(ctx context.Context, method, path string, body interf
 	}
 
 	// Set required headers
-	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.TokenID, c.Token))
+	if c.User != "" {
+		ticket, csrfToken, err := c.getTicket(ctx)
+		if err != nil {
+			return err
+		}
+		req.AddCookie(&http.Cookie{Name: "PVEAuthCookie", Value: ticket})
+		if method != http.MethodGet {
+			req.Header.Set("CSRFPreventionToken", csrfToken)
+		}
+	} else {
+		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.TokenID, c.Token))
+	}
 	req.Header.Set("Accept", "application/json")
+	c.setIdentifyingHeaders(req)
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
@@ -90,30 +273,97 @@ func (c *ProxmoxClient) Do(ctx context.Context, method, path string, body interf
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized && c.User != "" {
+		c.resetTicket()
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		return &APIError{StatusCode: resp.StatusCode, Endpoint: path, Body: string(respBody)}
 	}
 
 	if result != nil {
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read response body: %w", err)
+		limited := &sizeLimitedReader{r: resp.Body, remaining: c.MaxResponseSize}
+		body := io.Reader(resp.Body)
+		if c.MaxResponseSize > 0 {
+			body = limited
 		}
 
 		if c.LogLevel == LogLevelDebug {
+			respBody, err := io.ReadAll(body)
+			if err != nil {
+				return fmt.Errorf("failed to read response from %s: %w", path, err)
+			}
 			log.Printf("API Response: %s", string(respBody))
+			body = bytes.NewReader(respBody)
 		}
 
-		err = json.Unmarshal(respBody, result)
+		// Decode while reading instead of buffering the whole body, keeping
+		// the start of the body for error messages
+		head := &headBuffer{limit: errorBodyLimit}
+		err := json.NewDecoder(io.TeeReader(body, head)).Decode(result)
+		if limited.exceeded {
+			return fmt.Errorf("failed to read response from %s: %w (%d bytes)", path, errResponseTooLarge, c.MaxResponseSize)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal response: %w", err)
+			return fmt.Errorf("failed to decode response from %s: %w (body: %s)", path, err, head.String())
 		}
 	}
 
 	return nil
 }
 
+// errResponseTooLarge is returned when a response exceeds MaxResponseSize.
+var errResponseTooLarge = errors.New("response exceeds maximum size")
+
+// sizeLimitedReader fails with errResponseTooLarge once more than remaining
+// bytes are read, unlike io.LimitReader which silently truncates.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return int(l.remaining), errResponseTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// headBuffer keeps the first limit bytes written to it.
+type headBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if room := h.limit - h.buf.Len(); room > 0 {
+		if len(p) > room {
+			h.buf.Write(p[:room])
+		} else {
+			h.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (h *headBuffer) String() string {
+	if h.buf.Len() >= h.limit {
+		return h.buf.String() + "..."
+	}
+	return h.buf.String()
+}
+
 // Get performs a GET request to the Proxmox API
 func (c *ProxmoxClient) Get(ctx context.Context, path string, result interface{}) error {
 	return c.Do(ctx, http.MethodGet, path, nil, result)
@@ -169,22 +419,44 @@ func (c *ProxmoxClient) GetContainers(ctx context.Context, nodeName string) ([]C
 
 // GetVMConfig retrieves the configuration of a VM
 func (c *ProxmoxClient) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
+	return c.getGuestConfig(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", nodeName, vmID))
+}
+
+// GetContainerConfig retrieves the configuration of a container
+func (c *ProxmoxClient) GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
+	return c.getGuestConfig(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmID))
+}
+
+// getGuestConfig decodes a VM or container configuration into the same
+// ParsedConfig, so both guest types share one label parser.
+func (c *ProxmoxClient) getGuestConfig(ctx context.Context, path string) (*ParsedConfig, error) {
 	var response struct {
-		Data ParsedConfig `json:"data"`
+		Data map[string]interface{} `json:"data"`
 	}
-	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", nodeName, vmID), &response)
+	err := c.Get(ctx, path, &response)
 	if err != nil {
 		return nil, err
 	}
-	return &response.Data, nil
+
+	config := &ParsedConfig{Fields: make(map[string]string, len(response.Data))}
+	for key, value := range response.Data {
+		switch v := value.(type) {
+		case string:
+			config.Fields[key] = v
+		case float64:
+			config.Fields[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	config.Description = config.Fields["description"]
+	return config, nil
 }
 
-// GetContainerConfig retrieves the configuration of a container
-func (c *ProxmoxClient) GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
+// GetPool retrieves a resource pool and its members
+func (c *ProxmoxClient) GetPool(ctx context.Context, poolID string) (*Pool, error) {
 	var response struct {
-		Data ParsedConfig `json:"data"`
+		Data Pool `json:"data"`
 	}
-	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmID), &response)
+	err := c.Get(ctx, fmt.Sprintf("/pools/%s", url.PathEscape(poolID)), &response)
 	if err != nil {
 		return nil, err
 	}
@@ -225,9 +497,7 @@ func (c *ProxmoxClient) GetContainerNetworkInterfaces(ctx context.Context, nodeN
 	}
 
 	result := &ParsedAgentInterfaces{
-		Result: make([]struct {
-			IPAddresses []IP `json:"ip-addresses"`
-		}, 0),
+		Result: make([]AgentInterface, 0),
 	}
 
 	for _, iface := range response.Data {
@@ -248,12 +518,50 @@ func (c *ProxmoxClient) GetContainerNetworkInterfaces(ctx context.Context, nodeN
 			})
 		}
 
-		result.Result = append(result.Result, struct {
-			IPAddresses []IP `json:"ip-addresses"`
-		}{
+		result.Result = append(result.Result, AgentInterface{
+			Name:        iface.Name,
 			IPAddresses: ips,
 		})
 	}
 
 	return result, nil
 }
+
+// agentExecPollInterval is how often a guest agent command is checked for completion.
+const agentExecPollInterval = 200 * time.Millisecond
+
+// GetVMListeningPorts lists the TCP ports listening inside a VM by running
+// `ss -Hltn` through the guest agent.
+func (c *ProxmoxClient) GetVMListeningPorts(ctx context.Context, nodeName string, vmID uint64) ([]int, error) {
+	var started struct {
+		Data struct {
+			Pid int `json:"pid"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/agent", nodeName, vmID)
+	body := map[string]interface{}{"command": []string{"ss", "-Hltn"}}
+	if err := c.Do(ctx, http.MethodPost, path+"/exec", body, &started); err != nil {
+		return nil, err
+	}
+
+	for {
+		var status struct {
+			Data AgentExecStatus `json:"data"`
+		}
+		if err := c.Get(ctx, fmt.Sprintf("%s/exec-status?pid=%d", path, started.Data.Pid), &status); err != nil {
+			return nil, err
+		}
+		if status.Data.Exited != 0 {
+			if status.Data.ExitCode != 0 {
+				return nil, fmt.Errorf("ss exited with code %d", status.Data.ExitCode)
+			}
+			return ParseListeningPorts(status.Data.OutData), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(agentExecPollInterval):
+		}
+	}
+}
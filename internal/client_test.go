@@ -0,0 +1,410 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxmoxClient_PathPrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   string
+		expected string
+	}{
+		{name: "Host root", suffix: "", expected: "/api2/json/version"},
+		{name: "Path prefix", suffix: "/proxmox", expected: "/proxmox/api2/json/version"},
+		{name: "Path prefix with trailing slash", suffix: "/proxmox/", expected: "/proxmox/api2/json/version"},
+		{name: "Full API path", suffix: "/proxmox/api2/json", expected: "/proxmox/api2/json/version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = r.URL.Path
+				fmt.Fprint(w, `{"data":{"release":"8.2"}}`)
+			}))
+			defer server.Close()
+
+			client := NewProxmoxClient(server.URL+tt.suffix, "test@pam!test", "token", true, LogLevelInfo)
+			version, err := client.GetVersion(context.Background())
+			if err != nil {
+				t.Fatalf("GetVersion() error = %v", err)
+			}
+			if version.Release != "8.2" {
+				t.Errorf("Expected release 8.2, got %s", version.Release)
+			}
+			if requested != tt.expected {
+				t.Errorf("Expected request to %s, got %s", tt.expected, requested)
+			}
+		})
+	}
+}
+
+func TestProxmoxClient_ClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"release":"8.2"}}`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", false, LogLevelInfo)
+	if _, err := client.GetVersion(context.Background()); err == nil {
+		t.Fatal("Expected request without client certificate to fail")
+	}
+
+	client = NewProxmoxClient(server.URL, "test@pam!test", "token", false, LogLevelInfo)
+	if err := client.SetClientCertificate(certFile, keyFile); err != nil {
+		t.Fatalf("SetClientCertificate() error = %v", err)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Errorf("GetVersion() with client certificate error = %v", err)
+	}
+
+	if err := client.SetClientCertificate(filepath.Join(t.TempDir(), "missing.pem"), keyFile); err == nil {
+		t.Error("Expected error for missing certificate file")
+	}
+}
+
+func TestProxmoxClient_RootCAs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"release":"8.2"}}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	if _, err := client.GetVersion(context.Background()); err == nil {
+		t.Fatal("Expected the self-signed certificate to be rejected without the CA file")
+	}
+
+	client = NewProxmoxClient(server.URL, "test@pam!test", "token", false, LogLevelInfo)
+	if err := client.SetRootCAs(caFile); err != nil {
+		t.Fatalf("SetRootCAs() error = %v", err)
+	}
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	if transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("Expected the CA file to enable certificate verification")
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Errorf("GetVersion() with the CA file error = %v", err)
+	}
+
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetRootCAs(notPEM); err == nil {
+		t.Error("Expected error for a file without certificates")
+	}
+	if err := client.SetRootCAs(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Expected error for a missing CA file")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM files.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "traefik"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestProxmoxClient_PasswordAuth(t *testing.T) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/access/ticket":
+			if r.Method != http.MethodPost || r.FormValue("username") != "traefik@pve" || r.FormValue("password") != "secret" {
+				http.Error(w, "authentication failure", http.StatusUnauthorized)
+				return
+			}
+			logins++
+			fmt.Fprint(w, `{"data":{"ticket":"PVE:traefik@pve:TICKET","CSRFPreventionToken":"CSRF"}}`)
+		case "/api2/json/version":
+			cookie, err := r.Cookie("PVEAuthCookie")
+			if err != nil || cookie.Value != "PVE:traefik@pve:TICKET" {
+				http.Error(w, "no ticket", http.StatusUnauthorized)
+				return
+			}
+			if r.Header.Get("Authorization") != "" {
+				http.Error(w, "unexpected token header", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"release":"8.2"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "", "", true, LogLevelInfo)
+	client.SetPasswordAuth("traefik@pve", "secret")
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetVersion(context.Background()); err != nil {
+			t.Fatalf("GetVersion() error = %v", err)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("Expected the ticket to be reused, got %d logins", logins)
+	}
+
+	client = NewProxmoxClient(server.URL, "", "", true, LogLevelInfo)
+	client.SetPasswordAuth("traefik@pve", "wrong")
+	if _, err := client.GetVersion(context.Background()); err == nil {
+		t.Error("Expected login with a wrong password to fail")
+	}
+}
+
+func TestProxmoxClient_ResponseHandling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes":
+			fmt.Fprintf(w, `{"data":[{"node":"%s"}]}`, strings.Repeat("a", 2048))
+		case "/api2/json/version":
+			fmt.Fprint(w, `<html>Bad Gateway</html>`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	nodes, err := client.GetNodes(context.Background())
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("GetNodes() = %v, %v", nodes, err)
+	}
+
+	client.MaxResponseSize = 1024
+	if _, err := client.GetNodes(context.Background()); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("Expected errResponseTooLarge, got %v", err)
+	}
+
+	_, err = client.GetVersion(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "/version") || !strings.Contains(err.Error(), "<html>Bad Gateway</html>") {
+		t.Errorf("Expected decode error with endpoint and body, got %v", err)
+	}
+}
+
+func TestProxmoxClient_ConfigLabelParity(t *testing.T) {
+	const description = "Web server\ntraefik.enable=true\ntraefik.http.routers.web.rule=Host(`web.example.com`)\ntraefik.http.services.web.loadbalancer.server.port=8080\n"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes/pve/lxc/200/config":
+			fmt.Fprintf(w, `{"data":{"arch":"amd64","cores":2,"description":%q,"digest":"4f1c","hostname":"web","memory":512,"net0":"name=eth0,bridge=vmbr0,ip=dhcp,type=veth","ostype":"debian","rootfs":"local-lvm:vm-200-disk-0,size=8G","swap":512}}`, description)
+		case "/api2/json/nodes/pve/qemu/100/config":
+			fmt.Fprintf(w, `{"data":{"agent":"1","boot":"order=scsi0;net0","cores":2,"description":%q,"digest":"9a2b","memory":"2048","name":"web","net0":"virtio=BC:24:11:00:00:01,bridge=vmbr0","ostype":"l26","scsi0":"local-lvm:vm-100-disk-0,size=32G"}}`, description)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+
+	ctConfig, err := client.GetContainerConfig(context.Background(), "pve", 200)
+	if err != nil {
+		t.Fatalf("GetContainerConfig() error = %v", err)
+	}
+	vmConfig, err := client.GetVMConfig(context.Background(), "pve", 100)
+	if err != nil {
+		t.Fatalf("GetVMConfig() error = %v", err)
+	}
+
+	ctLabels := ctConfig.GetTraefikMap()
+	vmLabels := vmConfig.GetTraefikMap()
+	if len(ctLabels) != 3 {
+		t.Errorf("Expected 3 labels, got %v", ctLabels)
+	}
+	if len(ctLabels) != len(vmLabels) {
+		t.Fatalf("Expected the same labels for containers and VMs, got %v and %v", ctLabels, vmLabels)
+	}
+	for key, value := range ctLabels {
+		if vmLabels[key] != value {
+			t.Errorf("Label %s differs: container %q, VM %q", key, value, vmLabels[key])
+		}
+	}
+}
+
+func TestProxmoxClient_GetVMListeningPorts(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes/pve/qemu/100/agent/exec":
+			if r.Method != http.MethodPost {
+				t.Errorf("Expected POST for exec, got %s", r.Method)
+			}
+			fmt.Fprint(w, `{"data":{"pid":42}}`)
+		case "/api2/json/nodes/pve/qemu/100/agent/exec-status":
+			if r.URL.Query().Get("pid") != "42" {
+				t.Errorf("Expected pid 42, got %s", r.URL.Query().Get("pid"))
+			}
+			polls++
+			if polls == 1 {
+				fmt.Fprint(w, `{"data":{"exited":0}}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"exited":1,"exitcode":0,"out-data":"LISTEN 0 4096 127.0.0.53%lo:53 0.0.0.0:*\nLISTEN 0 128 0.0.0.0:22 0.0.0.0:*\nLISTEN 0 511 *:8080 *:*\nLISTEN 0 128 [::]:22 [::]:*\nLISTEN 0 4096 [::1]:9000 [::]:*\n"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	ports, err := client.GetVMListeningPorts(context.Background(), "pve", 100)
+	if err != nil {
+		t.Fatalf("GetVMListeningPorts() error = %v", err)
+	}
+	if fmt.Sprint(ports) != "[22 8080]" {
+		t.Errorf("Expected ports [22 8080], got %v", ports)
+	}
+}
+
+func TestProxmoxClient_APIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/version":
+			http.Error(w, `{"data":null}`, http.StatusUnauthorized)
+		case "/api2/json/nodes/pve/qemu/100/agent/network-get-interfaces":
+			http.Error(w, `{"data":null,"message":"QEMU guest agent is not running\n"}`, http.StatusInternalServerError)
+		case "/api2/json/nodes/pve/qemu":
+			http.Error(w, `{"data":null}`, http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	ctx := context.Background()
+
+	_, err := client.GetVersion(ctx)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Endpoint != "/version" {
+		t.Errorf("Expected an APIError with status 401 for /version, got %v", err)
+	}
+	if !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected only ErrUnauthorized to match %v", err)
+	}
+
+	if _, err := client.GetVMNetworkInterfaces(ctx, "pve", 100); !errors.Is(err, ErrAgentUnavailable) {
+		t.Errorf("Expected ErrAgentUnavailable, got %v", err)
+	}
+	if _, err := client.GetVirtualMachines(ctx, "pve"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	if _, err := client.GetContainers(ctx, "gone"); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrAgentUnavailable) {
+		t.Errorf("Expected only ErrNotFound to match %v", err)
+	}
+}
+
+func TestProxmoxClient_IdentifyingHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != DefaultUserAgent {
+			http.Error(w, "unexpected user agent "+got, http.StatusBadRequest)
+			return
+		}
+		if got := r.Header.Get("X-Traefik-Instance"); got != "edge-1" {
+			http.Error(w, "unexpected instance header "+got, http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/api2/json/access/ticket":
+			fmt.Fprint(w, `{"data":{"ticket":"PVE:traefik@pve:TICKET","CSRFPreventionToken":"CSRF"}}`)
+		case "/api2/json/version":
+			fmt.Fprint(w, `{"data":{"release":"8.2"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	client.Headers.Set("X-Traefik-Instance", "edge-1")
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() with a token error = %v", err)
+	}
+
+	client = NewProxmoxClient(server.URL, "", "", true, LogLevelInfo)
+	client.SetPasswordAuth("traefik@pve", "secret")
+	client.Headers.Set("X-Traefik-Instance", "edge-1")
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() with a ticket error = %v", err)
+	}
+}
+
+func TestProxmoxClient_GuestConfigFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"description":"traefik.enable=true","smbios1":"uuid=1234,serial=dHJhZWZpay5lbmFibGU9dHJ1ZQ==,base64=1","cores":2}}`)
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "token", true, LogLevelInfo)
+	config, err := client.GetVMConfig(context.Background(), "pve", 100)
+	if err != nil {
+		t.Fatalf("GetVMConfig() error = %v", err)
+	}
+	if config.Description != "traefik.enable=true" {
+		t.Errorf("Expected the description, got %q", config.Description)
+	}
+	if config.Fields["smbios1"] != "uuid=1234,serial=dHJhZWZpay5lbmFibGU9dHJ1ZQ==,base64=1" || config.Fields["cores"] != "2" {
+		t.Errorf("Expected every configuration key in Fields, got %v", config.Fields)
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors matched by APIError, so callers can use errors.Is instead of
+// inspecting status codes or messages.
+var (
+	// ErrUnauthorized is returned when the API rejects the credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the credentials lack a permission.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned when the requested node or guest does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAgentUnavailable is returned when the QEMU guest agent of a VM is
+	// configured but not running.
+	ErrAgentUnavailable = errors.New("guest agent unavailable")
+)
+
+// APIError is returned by ProxmoxClient for responses with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	// Endpoint is the API path of the request, e.g. /nodes.
+	Endpoint string
+	// Body holds the start of the response body, which carries the message.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("API request to %s failed with status %d", e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("API request to %s failed with status %d: %s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+// Is maps the status code and message onto ErrUnauthorized, ErrForbidden,
+// ErrNotFound and ErrAgentUnavailable.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAgentUnavailable:
+		return e.StatusCode == http.StatusInternalServerError &&
+			strings.Contains(strings.ToLower(e.Body), "guest agent is not running")
+	}
+	return false
+}
@@ -1,21 +1,39 @@
 package internal
 
 import (
+	"encoding/base64"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 type ParsedConfig struct {
 	Description string `json:"description,omitempty"`
+	// Fields holds every key of the guest configuration, e.g. smbios1, for
+	// reading labels from somewhere other than the notes.
+	Fields map[string]string `json:"-"`
 }
 
 type ParsedAgentInterfaces struct {
-	Result []struct {
-		IPAddresses []IP `json:"ip-addresses"`
-	} `json:"result"`
+	Result []AgentInterface `json:"result"`
+}
+
+type AgentInterface struct {
+	Name        string `json:"name"`
+	IPAddresses []IP   `json:"ip-addresses"`
+}
+
+// AgentExecStatus is the state of a command started through the guest agent.
+type AgentExecStatus struct {
+	Exited   int    `json:"exited"`
+	ExitCode int    `json:"exitcode"`
+	OutData  string `json:"out-data"`
 }
 
 type NodeStatus struct {
 	Node string `json:"node"`
+	// Status is online, offline or unknown.
+	Status string `json:"status"`
 }
 
 type VirtualMachine struct {
@@ -30,56 +48,243 @@ type Container struct {
 	Status string `json:"status"`
 }
 
+type Pool struct {
+	PoolID  string       `json:"poolid"`
+	Members []PoolMember `json:"members"`
+}
+
+type PoolMember struct {
+	VMID uint64 `json:"vmid"`
+	Node string `json:"node"`
+	Type string `json:"type"`
+}
+
 type Version struct {
 	Release string `json:"release"`
 }
 
+// Guest types
+const (
+	GuestTypeVM        = "vm"
+	GuestTypeContainer = "lxc"
+)
+
 type Service struct {
 	ID     uint64
 	Name   string
 	IPs    []IP
 	Config map[string]string
+	// Type is GuestTypeVM or GuestTypeContainer.
+	Type string
+	// AgentStatus summarizes the network interface lookup, for diagnostics.
+	AgentStatus string
+	// AgentFailed is set when the network interface lookup failed and no
+	// static address replaced it.
+	AgentFailed bool
+	// DetectedPort is the port found listening inside the guest, used for
+	// services without a port label.
+	DetectedPort string
+	// TagPort is the port of a port-<n> tag of the guest, used for services
+	// without a port label when no port was detected.
+	TagPort string
+	// ScanError describes why the guest could not be scanned completely. It
+	// is only set when diagnostics are published.
+	ScanError string
 }
 
 type IP struct {
 	Address     string `json:"ip-address,omitempty"`
 	AddressType string `json:"ip-address-type,omitempty"`
 	Prefix      uint64 `json:"prefix,omitempty"`
+	// Interface is the name of the interface the address was reported on.
+	Interface string `json:"-"`
 }
 
 func NewService(id uint64, name string, config map[string]string) Service {
 	return Service{ID: id, Name: name, Config: config, IPs: make([]IP, 0)}
 }
 
+// Markers delimiting the label block inside a description
+const (
+	LabelBlockStart = "# traefik-start"
+	LabelBlockEnd   = "# traefik-end"
+)
+
+// DefaultLabelPrefix is the prefix of the labels read from guest notes.
+const DefaultLabelPrefix = "traefik."
+
 func (pc *ParsedConfig) GetTraefikMap() map[string]string {
+	return pc.GetLabelMap(DefaultLabelPrefix)
+}
+
+// GetTraefikBlockMap parses only the labels between LabelBlockStart and
+// LabelBlockEnd, leaving the rest of the description to human notes.
+func (pc *ParsedConfig) GetTraefikBlockMap() map[string]string {
+	return pc.GetLabelBlockMap(DefaultLabelPrefix)
+}
+
+// GetLabelMap parses the labels starting with prefix, e.g. "pxtraefik.".
+// The prefix is replaced with "traefik." so the keys map to the dynamic
+// configuration the same way as with the default prefix.
+func (pc *ParsedConfig) GetLabelMap(prefix string) map[string]string {
+	return parseLabels(pc.Description, prefix)
+}
+
+// GetLabelBlockMap is GetLabelMap restricted to the label block.
+func (pc *ParsedConfig) GetLabelBlockMap(prefix string) map[string]string {
+	var block []string
+	inBlock := false
+	for _, line := range strings.Split(pc.Description, "\n") {
+		marker := strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case marker == LabelBlockStart:
+			inBlock = true
+		case marker == LabelBlockEnd:
+			inBlock = false
+		case inBlock:
+			block = append(block, line)
+		}
+	}
+	return parseLabels(strings.Join(block, "\n"), prefix)
+}
+
+// GetFieldLabelMap is GetLabelMap reading the labels from another key of the
+// guest configuration. The values of smbios1 are decoded first, see
+// smbiosLabelText.
+func (pc *ParsedConfig) GetFieldLabelMap(field, prefix string) map[string]string {
+	text := pc.Fields[field]
+	if field == "smbios1" {
+		text = smbiosLabelText(text)
+	}
+	return parseLabels(text, prefix)
+}
+
+// smbiosLabelText returns the SMBIOS properties of a smbios1 value such as
+// "uuid=...,serial=dHJhZWZpay5lbmFibGU9dHJ1ZQ==,base64=1" as one property
+// per line. Labels contain '=' and ',', so Proxmox stores them base64 encoded
+// when cloud-init or the UI sets base64=1. The uuid is left out.
+func smbiosLabelText(value string) string {
+	properties := make(map[string]string)
+	var keys []string
+	for _, property := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(property, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		properties[key] = val
+		keys = append(keys, key)
+	}
+
+	var lines []string
+	for _, key := range keys {
+		if key == "uuid" || key == "base64" {
+			continue
+		}
+		val := properties[key]
+		if properties["base64"] == "1" {
+			decoded, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				continue
+			}
+			val = string(decoded)
+		}
+		lines = append(lines, val)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// labelStartPattern matches a label key following whitespace on the same line.
+var labelStartPattern = newLabelStartPattern(DefaultLabelPrefix)
+
+func newLabelStartPattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)[ \t]+("?` + regexp.QuoteMeta(prefix) + `[^\s="]+"?=)`)
+}
+
+func parseLabels(text string, prefix string) map[string]string {
 	const separator = "="
 
+	prefix = strings.ToLower(prefix)
+	startPattern := labelStartPattern
+	if prefix != DefaultLabelPrefix {
+		startPattern = newLabelStartPattern(prefix)
+	}
+
 	// Normalize space-separated traefik labels (e.g. from OCI containers)
-	// into newline-separated labels so they are parsed individually.
-	normalized := strings.ReplaceAll(pc.Description, " traefik.", "\ntraefik.")
+	// into newline-separated labels so they are parsed individually. Only
+	// whitespace followed by a complete "<prefix><key>=" starts a new label,
+	// so values such as multi-host rules keep their spaces.
+	normalized := startPattern.ReplaceAllString(text, "\n$1")
 
 	m := make(map[string]string)
 	lines := strings.Split(normalized, "\n")
 	for _, line := range lines {
+		// Only the first separator splits key and value; rules and regular
+		// expressions may contain more.
 		key, value, found := strings.Cut(line, separator)
 		if !found {
 			continue
 		}
 
-		key = strings.Trim(key, "\" ")
-		value = strings.Trim(value, "\" ")
+		key = trimQuotes(strings.TrimSpace(key))
+		value = trimQuotes(strings.TrimSpace(value))
 
-		if strings.HasPrefix(strings.ToLower(key), "traefik.") {
-			m[strings.ToLower(key)] = value
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, prefix) {
+			m[DefaultLabelPrefix+strings.TrimPrefix(key, prefix)] = value
 		}
 	}
 	return m
 }
 
+// trimQuotes removes one pair of matching surrounding quotes, keeping any
+// quotes inside the value.
+func trimQuotes(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 func (pai *ParsedAgentInterfaces) GetIPs() []IP {
 	ips := make([]IP, 0)
 	for _, r := range pai.Result {
-		ips = append(ips, r.IPAddresses...)
+		for _, ip := range r.IPAddresses {
+			ip.Interface = r.Name
+			ips = append(ips, ip)
+		}
 	}
 	return ips
 }
+
+// ParseListeningPorts extracts the ports of listening TCP sockets from the
+// output of `ss -Hltn`, skipping sockets bound to loopback addresses.
+func ParseListeningPorts(output string) []int {
+	seen := make(map[int]bool)
+	ports := make([]int, 0)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		sep := strings.LastIndex(local, ":")
+		if sep < 0 {
+			continue
+		}
+		host := strings.Trim(local[:sep], "[]")
+		if zone := strings.Index(host, "%"); zone >= 0 {
+			host = host[:zone]
+		}
+		if host == "::1" || strings.HasPrefix(host, "127.") || host == "localhost" {
+			continue
+		}
+		port, err := strconv.Atoi(local[sep+1:])
+		if err != nil || seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports
+}
@@ -176,12 +176,35 @@ func TestParsedConfig_GetTraefikMap_CaseInsensitive(t *testing.T) {
 	}
 }
 
+func TestParsedConfig_GetTraefikMap_MixedCaseKeys(t *testing.T) {
+	// Notes as entered by users, with inconsistent casing and a quoted key
+	pc := ParsedConfig{
+		Description: "Traefik.Enable=true\nTRAEFIK.HTTP.Routers.MyApp.Rule=Host(`MyApp.example.com`)\n\"traefik.Http.services.myApp.loadBalancer.server.Port\"=8080 Traefik.http.routers.myapp.EntryPoints=websecure",
+	}
+
+	m := pc.GetTraefikMap()
+
+	expected := map[string]string{
+		"traefik.enable":                                       "true",
+		"traefik.http.routers.myapp.rule":                      "Host(`MyApp.example.com`)",
+		"traefik.http.services.myapp.loadbalancer.server.port": "8080",
+		"traefik.http.routers.myapp.entrypoints":               "websecure",
+	}
+	if len(m) != len(expected) {
+		t.Errorf("Expected %d config items, got %d: %v", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("Expected %s=%s with the value casing kept, got %v", key, value, m)
+		}
+	}
+}
+
 func TestParsedAgentInterfaces_GetIPs(t *testing.T) {
 	pai := ParsedAgentInterfaces{
-		Result: []struct {
-			IPAddresses []IP `json:"ip-addresses"`
-		}{
+		Result: []AgentInterface{
 			{
+				Name: "eth0",
 				IPAddresses: []IP{
 					{Address: "192.168.1.1", AddressType: "ipv4", Prefix: 24},
 					{Address: "10.0.0.1", AddressType: "ipv4", Prefix: 16},
@@ -203,4 +226,150 @@ func TestParsedAgentInterfaces_GetIPs(t *testing.T) {
 	if ips[1].Address != "10.0.0.1" {
 		t.Errorf("Expected second IP to be 10.0.0.1, got %s", ips[1].Address)
 	}
-} 
\ No newline at end of file
+
+	if ips[0].Interface != "eth0" {
+		t.Errorf("Expected interface name eth0, got %s", ips[0].Interface)
+	}
+} 
+func TestParsedConfig_GetTraefikBlockMap(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "Web server, owned by the platform team.\ntraefik.note=this line is a human note\n\n# traefik-start\ntraefik.enable=true\ntraefik.http.routers.web.rule=Host(`web.example.com`)\n# traefik-end\n\nMore notes.",
+	}
+
+	m := pc.GetTraefikBlockMap()
+
+	if len(m) != 2 {
+		t.Errorf("Expected 2 config items from the block, got %d: %v", len(m), m)
+	}
+
+	if m["traefik.enable"] != "true" {
+		t.Errorf("Expected traefik.enable=true, got %s", m["traefik.enable"])
+	}
+
+	if _, exists := m["traefik.note"]; exists {
+		t.Error("Did not expect labels outside the block to be parsed")
+	}
+
+	empty := ParsedConfig{Description: "traefik.enable=true"}
+	if len(empty.GetTraefikBlockMap()) != 0 {
+		t.Error("Expected no labels when the description has no block")
+	}
+}
+
+func TestParsedConfig_GetTraefikMap_ComplexValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		key         string
+		expected    string
+	}{
+		{
+			name:        "Multi-host rule",
+			description: "traefik.enable=true\ntraefik.http.routers.app.rule=Host(`a.com`) || Host(`b.com`)",
+			key:         "traefik.http.routers.app.rule",
+			expected:    "Host(`a.com`) || Host(`b.com`)",
+		},
+		{
+			name:        "Multi-host rule on a space-separated line",
+			description: "traefik.enable=true traefik.http.routers.app.rule=Host(`a.com`) || Host(`b.com`) traefik.http.routers.app.entrypoints=websecure",
+			key:         "traefik.http.routers.app.rule",
+			expected:    "Host(`a.com`) || Host(`b.com`)",
+		},
+		{
+			name:        "PathPrefix with regex containing separators",
+			description: "traefik.http.routers.api.rule=Host(`api.example.com`) && PathRegexp(`^/v[0-9]+/items/(?P<id>[a-z=]+)$`)",
+			key:         "traefik.http.routers.api.rule",
+			expected:    "Host(`api.example.com`) && PathRegexp(`^/v[0-9]+/items/(?P<id>[a-z=]+)$`)",
+		},
+		{
+			name:        "Quoted value",
+			description: `traefik.http.routers.app.rule="Host(` + "`app.example.com`" + `)"`,
+			key:         "traefik.http.routers.app.rule",
+			expected:    "Host(`app.example.com`)",
+		},
+		{
+			name:        "Embedded quotes are kept",
+			description: `traefik.http.middlewares.h.headers.contentsecuritypolicy=default-src 'self'; script-src "nonce-abc"`,
+			key:         "traefik.http.middlewares.h.headers.contentsecuritypolicy",
+			expected:    `default-src 'self'; script-src "nonce-abc"`,
+		},
+		{
+			name:        "Rule mentioning a traefik hostname",
+			description: "traefik.http.routers.dash.rule=Host(`traefik.example.com`) || Host(`traefik.lan`)",
+			key:         "traefik.http.routers.dash.rule",
+			expected:    "Host(`traefik.example.com`) || Host(`traefik.lan`)",
+		},
+		{
+			name:        "Windows line endings",
+			description: "traefik.enable=true\r\ntraefik.http.services.app.loadbalancer.server.port=8080\r\n",
+			key:         "traefik.http.services.app.loadbalancer.server.port",
+			expected:    "8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := ParsedConfig{Description: tt.description}
+			m := pc.GetTraefikMap()
+			if m[tt.key] != tt.expected {
+				t.Errorf("Expected %s=%q, got %q (all labels: %v)", tt.key, tt.expected, m[tt.key], m)
+			}
+		})
+	}
+}
+
+func TestParsedConfig_GetLabelMap_CustomPrefix(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.http.routers.file.rule=Host(`file.example.com`)\nPXTraefik.enable=true pxtraefik.http.routers.web.rule=Host(`web.example.com`)",
+	}
+
+	m := pc.GetLabelMap("pxtraefik.")
+
+	if len(m) != 2 {
+		t.Errorf("Expected 2 config items with the custom prefix, got %d: %v", len(m), m)
+	}
+
+	if m["traefik.enable"] != "true" {
+		t.Errorf("Expected the prefix to be replaced with traefik., got %v", m)
+	}
+
+	if m["traefik.http.routers.web.rule"] != "Host(`web.example.com`)" {
+		t.Errorf("Expected space-separated labels with the custom prefix to be split, got %v", m)
+	}
+
+	if _, exists := m["traefik.http.routers.file.rule"]; exists {
+		t.Error("Did not expect labels with the default prefix to be parsed")
+	}
+}
+
+func TestParsedConfig_GetFieldLabelMap(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.enable=false",
+		Fields: map[string]string{
+			"smbios1": "uuid=6f9c1c54-6bc8-4b7e-9d0e-3c1c1e5a7a10,serial=dHJhZWZpay5lbmFibGU9dHJ1ZSB0cmFlZmlrLmh0dHAucm91dGVycy53ZWIucnVsZT1Ib3N0KGB3ZWIuZXhhbXBsZS5jb21gKQ==,family=dHJhZWZpay5odHRwLnNlcnZpY2VzLndlYi5sb2FkYmFsYW5jZXIuc2VydmVyLnBvcnQ9ODA4MA==,base64=1",
+			"tags":    "traefik.enable=true",
+		},
+	}
+
+	m := pc.GetFieldLabelMap("smbios1", DefaultLabelPrefix)
+
+	if len(m) != 3 {
+		t.Errorf("Expected 3 config items from the decoded smbios1 properties, got %d: %v", len(m), m)
+	}
+
+	if m["traefik.enable"] != "true" || m["traefik.http.routers.web.rule"] != "Host(`web.example.com`)" {
+		t.Errorf("Expected the labels of the serial, got %v", m)
+	}
+
+	if m["traefik.http.services.web.loadbalancer.server.port"] != "8080" {
+		t.Errorf("Expected the labels of the family, got %v", m)
+	}
+
+	if m := pc.GetFieldLabelMap("tags", DefaultLabelPrefix); m["traefik.enable"] != "true" {
+		t.Errorf("Expected other fields to be parsed as they are, got %v", m)
+	}
+
+	if m := pc.GetFieldLabelMap("missing", DefaultLabelPrefix); len(m) != 0 {
+		t.Errorf("Expected no labels from a missing field, got %v", m)
+	}
+}
@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// cluster is one Proxmox cluster scanned by the provider.
+type cluster struct {
+	// Name qualifies the generated object names of this cluster. It is empty
+	// when a single cluster is configured, which keeps the names unchanged.
+	Name        string
+	client      ProxmoxAPI
+	scanOptions scanOptions
+	// versionPending is set while the version check that failed at startup
+	// has not succeeded yet, see checkVersions.
+	versionPending bool
+}
+
+// clusterEndpoint holds the endpoint and token of one configured cluster.
+type clusterEndpoint struct {
+	Name        string
+	ApiEndpoint string
+	TokenId     string
+	Token       string
+	ValidateSSL bool
+}
+
+// parseClusterEndpoints splits the comma-separated apiEndpoint, apiTokenId,
+// apiToken, apiValidateSSL and clusterNames settings into one entry per
+// cluster. A single token ID, token or apiValidateSSL value is shared by all
+// endpoints.
+func parseClusterEndpoints(config *Config) ([]clusterEndpoint, error) {
+	endpoints := splitList(config.ApiEndpoint)
+	if len(endpoints) == 0 {
+		return nil, errors.New("API endpoint must be set")
+	}
+
+	tokenIDs := splitList(config.ApiTokenId)
+	tokens := splitList(config.ApiToken)
+	validateSSL := splitList(config.ApiValidateSSL)
+	names := splitList(config.ClusterNames)
+	for setting, values := range map[string][]string{"apiTokenId": tokenIDs, "apiToken": tokens, "apiValidateSSL": validateSSL} {
+		if len(values) > 1 && len(values) != len(endpoints) {
+			return nil, fmt.Errorf("%s has %d entries for %d API endpoints, use one entry or one per endpoint", setting, len(values), len(endpoints))
+		}
+	}
+	if len(validateSSL) > 1 {
+		for _, value := range validateSSL {
+			if value != "true" && value != "false" {
+				return nil, fmt.Errorf("apiValidateSSL entries must be true or false, got %q", value)
+			}
+		}
+	}
+	if len(names) > 0 && len(names) != len(endpoints) {
+		return nil, fmt.Errorf("clusterNames has %d entries for %d API endpoints", len(names), len(endpoints))
+	}
+
+	clusters := make([]clusterEndpoint, len(endpoints))
+	seen := make(map[string]bool)
+	for i, endpoint := range endpoints {
+		clusters[i].ApiEndpoint = endpoint
+		clusters[i].TokenId = pickListEntry(tokenIDs, i)
+		clusters[i].Token = pickListEntry(tokens, i)
+		clusters[i].ValidateSSL = pickListEntry(validateSSL, i) == "true"
+
+		if len(endpoints) == 1 {
+			clusters[i].Name = pickListEntry(names, i)
+		} else if len(names) > 0 {
+			clusters[i].Name = names[i]
+		} else {
+			clusters[i].Name = defaultClusterName(endpoint)
+		}
+
+		if len(endpoints) > 1 && seen[clusters[i].Name] {
+			return nil, fmt.Errorf("cluster name %q is used for several API endpoints, set clusterNames", clusters[i].Name)
+		}
+		seen[clusters[i].Name] = true
+	}
+	return clusters, nil
+}
+
+func pickListEntry(values []string, i int) string {
+	switch {
+	case len(values) == 0:
+		return ""
+	case len(values) == 1:
+		return values[0]
+	default:
+		return values[i]
+	}
+}
+
+// defaultClusterName derives a cluster name from the endpoint host, e.g.
+// pve-a.example.com becomes pve-a-example-com.
+func defaultClusterName(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(host)
+}
+
+// scanClusterServices returns the guests of each cluster by node, in the
+// order of clusters. A failing cluster fails the whole poll, so Traefik keeps
+// the last complete configuration instead of dropping the routes of the
+// unreachable cluster.
+func scanClusterServices(ctx context.Context, clusters []cluster) ([]map[string][]internal.Service, error) {
+	scanned := make([]map[string][]internal.Service, 0, len(clusters))
+	for _, c := range clusters {
+		clusterServices, err := getServiceMap(c.client, ctx, c.scanOptions)
+		if err != nil {
+			if c.Name != "" {
+				return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+			}
+			return nil, err
+		}
+		scanned = append(scanned, clusterServices)
+	}
+	return scanned, nil
+}
+
+// buildClusters builds and merges the configurations of the scanned
+// clusters. The objects of a named cluster are prefixed with its name so they
+// stay unique. The returned services are keyed by node, qualified with the
+// cluster name for named clusters.
+func buildClusters(clusters []cluster, scanned []map[string][]internal.Service, opts Options) (map[string][]internal.Service, *dynamic.Configuration) {
+	servicesMap := make(map[string][]internal.Service)
+	var configuration *dynamic.Configuration
+
+	for i, c := range clusters {
+		clusterOpts := opts
+		if c.Name != "" {
+			clusterOpts.ProviderPrefix = opts.ProviderPrefix + c.Name + "-"
+		}
+		clusterConfiguration := BuildConfiguration(scanned[i], clusterOpts)
+		if configuration == nil {
+			configuration = clusterConfiguration
+		} else {
+			mergeConfiguration(configuration, clusterConfiguration)
+		}
+
+		for nodeName, services := range scanned[i] {
+			if c.Name != "" {
+				nodeName = c.Name + "/" + nodeName
+			}
+			servicesMap[nodeName] = services
+		}
+	}
+	return servicesMap, configuration
+}
+
+// mergeConfiguration adds the objects of src to dst. Names are unique per
+// cluster, except for TLS options, of which the first definition is kept.
+func mergeConfiguration(dst, src *dynamic.Configuration) {
+	for name, router := range src.HTTP.Routers {
+		dst.HTTP.Routers[name] = router
+	}
+	for name, service := range src.HTTP.Services {
+		dst.HTTP.Services[name] = service
+	}
+	for name, middleware := range src.HTTP.Middlewares {
+		dst.HTTP.Middlewares[name] = middleware
+	}
+	for name, transport := range src.HTTP.ServersTransports {
+		dst.HTTP.ServersTransports[name] = transport
+	}
+	for name, router := range src.TCP.Routers {
+		dst.TCP.Routers[name] = router
+	}
+	for name, service := range src.TCP.Services {
+		dst.TCP.Services[name] = service
+	}
+	for name, router := range src.UDP.Routers {
+		dst.UDP.Routers[name] = router
+	}
+	for name, service := range src.UDP.Services {
+		dst.UDP.Services[name] = service
+	}
+	for name, option := range src.TLS.Options {
+		if _, exists := dst.TLS.Options[name]; exists {
+			log.Printf("WARN: TLS options %s are defined in several clusters, keeping the first definition", name)
+			continue
+		}
+		dst.TLS.Options[name] = option
+	}
+	for name, store := range src.TLS.Stores {
+		if _, exists := dst.TLS.Stores[name]; exists {
+			log.Printf("WARN: TLS store %s is defined in several clusters, keeping the first definition", name)
+			continue
+		}
+		dst.TLS.Stores[name] = store
+	}
+}
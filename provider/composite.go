@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// buildCompositeService creates the weighted or mirroring service declared
+// with labels such as
+//
+//	traefik.http.services.<name>.weighted.services=app-v1:90,app-v2:10
+//	traefik.http.services.<name>.mirroring.service=app-v1
+//	traefik.http.services.<name>.mirroring.mirrors=app-v2:10
+//
+// It returns nil when the service is a plain load balancer.
+func buildCompositeService(service internal.Service, serviceName string) (*dynamic.Service, error) {
+	prefix := labelKey("http.services", serviceName, "") + "."
+	weighted, hasWeighted := service.Config[prefix+"weighted.services"]
+	mirrored, hasMirroring := service.Config[prefix+"mirroring.service"]
+	mirrors, hasMirrors := service.Config[prefix+"mirroring.mirrors"]
+	maxBodySize, hasMaxBodySize := service.Config[prefix+"mirroring.maxbodysize"]
+
+	switch {
+	case hasWeighted && (hasMirroring || hasMirrors):
+		return nil, errors.New("cannot be both weighted and mirroring")
+
+	case hasWeighted:
+		entries, err := parseServiceList(weighted, "weight", 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weighted.services: %w", err)
+		}
+		wrr := &dynamic.WeightedRoundRobin{}
+		for _, entry := range entries {
+			weight := entry.value
+			wrr.Services = append(wrr.Services, dynamic.WRRService{Name: labelReference(entry.name), Weight: &weight})
+		}
+		return &dynamic.Service{Weighted: wrr}, nil
+
+	case hasMirroring || hasMirrors:
+		mirrored = strings.TrimSpace(mirrored)
+		if mirrored == "" {
+			return nil, errors.New("mirroring.service must name the main service")
+		}
+		entries, err := parseServiceList(mirrors, "percent", 100)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirroring.mirrors: %w", err)
+		}
+		mirroring := &dynamic.Mirroring{Service: labelReference(mirrored)}
+		for _, entry := range entries {
+			mirroring.Mirrors = append(mirroring.Mirrors, dynamic.MirrorService{Name: labelReference(entry.name), Percent: entry.value})
+		}
+		if hasMaxBodySize {
+			size, err := strconv.ParseInt(maxBodySize, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mirroring.maxbodysize %q", maxBodySize)
+			}
+			mirroring.MaxBodySize = &size
+		}
+		return &dynamic.Service{Mirroring: mirroring}, nil
+	}
+	return nil, nil
+}
+
+type serviceListEntry struct {
+	name  string
+	value int
+}
+
+// parseServiceList parses a comma-separated list of service:value pairs.
+// The value defaults to 1 and must not exceed max unless max is 0.
+func parseServiceList(s, valueName string, max int) ([]serviceListEntry, error) {
+	var entries []serviceListEntry
+	for _, item := range splitList(s) {
+		entry := serviceListEntry{value: 1}
+		name, value, found := strings.Cut(item, ":")
+		entry.name = strings.TrimSpace(name)
+		if entry.name == "" {
+			return nil, fmt.Errorf("entry %q does not name a service", item)
+		}
+		if found {
+			v, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || v < 0 || (max > 0 && v > max) {
+				return nil, fmt.Errorf("invalid %s %q for service %s", valueName, value, entry.name)
+			}
+			entry.value = v
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no services listed")
+	}
+	return entries, nil
+}
+
+// compositeServiceNames returns the services a weighted or mirroring
+// service sends traffic to.
+func compositeServiceNames(service *dynamic.Service) []string {
+	var names []string
+	if service.Weighted != nil {
+		for _, s := range service.Weighted.Services {
+			names = append(names, s.Name)
+		}
+	}
+	if service.Mirroring != nil {
+		names = append(names, service.Mirroring.Service)
+		for _, m := range service.Mirroring.Mirrors {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
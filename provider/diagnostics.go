@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// diagnosticsHeader carries the scan error of a guest on its diagnostics
+// middleware, where the Traefik dashboard shows it.
+const diagnosticsHeader = "X-Proxmox-Scan-Error"
+
+// maxDiagnosticsLength keeps diagnostics readable in the dashboard, in
+// characters so truncation never splits a multi-byte character.
+const maxDiagnosticsLength = 256
+
+// addDiagnostics publishes the scan error of a guest as a router, service and
+// middleware named diag-<vmid>. The router only matches the reserved .invalid
+// domain and the service has no servers, so no traffic is routed; the objects
+// exist to show in the Traefik dashboard which guests had problems. The
+// objects are registered in the owner maps like those declared by labels, and
+// skipped when a guest already declared one of the same name.
+func addDiagnostics(config *dynamic.Configuration, service internal.Service, nodeName string, opts Options, routerOwners, serviceOwners, middlewareOwners map[string]string, owner string) {
+	name := fmt.Sprintf("diag-%d", service.ID)
+	for _, owners := range []map[string]string{routerOwners, serviceOwners, middlewareOwners} {
+		if previous, exists := owners[name]; exists {
+			log.Printf("WARN: Diagnostics of %s were skipped because %s is already defined by %s", owner, name, previous)
+			return
+		}
+	}
+	message := strings.Join(strings.Fields(fmt.Sprintf("%s on node %s: %s", service.Name, nodeName, service.ScanError)), " ")
+	if runes := []rune(message); len(runes) > maxDiagnosticsLength {
+		message = string(runes[:maxDiagnosticsLength-3]) + "..."
+	}
+
+	config.HTTP.Middlewares[name] = &dynamic.Middleware{
+		Headers: &dynamic.Headers{
+			CustomResponseHeaders: map[string]string{diagnosticsHeader: message},
+		},
+	}
+	config.HTTP.Services[name] = &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{}},
+	}
+	config.HTTP.Routers[name] = &dynamic.Router{
+		Rule:        fmt.Sprintf("Host(`%s.invalid`)", name),
+		Service:     name,
+		Middlewares: []string{name},
+		EntryPoints: defaultEntrypoints(nodeName, opts),
+	}
+	routerOwners[name] = owner
+	serviceOwners[name] = owner
+	middlewareOwners[name] = owner
+}
@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// Labels configuring the group of a guest, see addGroupConfiguration.
+var handledGroupLabels = map[string]bool{
+	"traefik.group":             true,
+	"traefik.group.rule":        true,
+	"traefik.group.path":        true,
+	"traefik.group.port":        true,
+	"traefik.group.stripprefix": true,
+	"traefik.group.entrypoints": true,
+}
+
+// groupMember is a guest joined to a group with traefik.group=<app>.
+type groupMember struct {
+	service  internal.Service
+	nodeName string
+	owner    string
+	path     string
+}
+
+// appGroup collects the members of one group in discovery order.
+type appGroup struct {
+	rule        string
+	ruleOwner   string
+	entrypoints []string
+	members     []groupMember
+}
+
+// hasGroupLabel reports whether a guest joins a group.
+func hasGroupLabel(service internal.Service) bool {
+	return strings.TrimSpace(service.Config["traefik.group"]) != ""
+}
+
+// addGroupMember adds a guest to its group. The group rule and entrypoints
+// may be set on any member; the first definition is kept.
+func addGroupMember(groups map[string]*appGroup, service internal.Service, nodeName string, owner string, opts Options) {
+	name := strings.TrimSpace(service.Config["traefik.group"])
+	group, exists := groups[name]
+	if !exists {
+		group = &appGroup{}
+		groups[name] = group
+	}
+
+	if rule, exists := service.Config["traefik.group.rule"]; exists {
+		switch {
+		case group.rule == "":
+			group.rule, group.ruleOwner = rule, owner
+		case group.rule != rule:
+			log.Printf("WARN: Group %s has rule %q from %s and %q from %s, keeping the first", name, group.rule, group.ruleOwner, rule, owner)
+		}
+	}
+	if entrypoints, exists := service.Config["traefik.group.entrypoints"]; exists && len(group.entrypoints) == 0 {
+		group.entrypoints = splitList(entrypoints)
+	}
+
+	path := strings.TrimSpace(service.Config["traefik.group.path"])
+	if path == "" {
+		path = "/" + guestName(service, opts)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+
+	group.members = append(group.members, groupMember{service: service, nodeName: nodeName, owner: owner, path: path})
+}
+
+// addGroupConfiguration generates the routers and services of each group.
+// Members are grouped by path: the members on path / are served by the
+// router <app> with the group rule, and the members on any other path by the
+// router <app>-<path> with the group rule and a PathPrefix. Each router has a
+// service of the same name load balancing the members on its path. Routers
+// keep Traefik's default priority, so longer rules, and thus longer paths,
+// are matched first.
+func addGroupConfiguration(config *dynamic.Configuration, groups map[string]*appGroup, opts Options, routerOwners, serviceOwners map[string]string) {
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	for _, groupName := range groupNames {
+		group := groups[groupName]
+		if group.rule == "" {
+			log.Printf("WARN: Group %s has no traefik.group.rule on any member, its routers and services were skipped", groupName)
+			continue
+		}
+
+		owner := fmt.Sprintf("group %s", groupName)
+		for _, member := range group.members {
+			name := groupObjectName(groupName, member.path)
+
+			if previous, exists := serviceOwners[name]; exists && previous != owner {
+				log.Printf("WARN: Service %s of group %s is already defined by %s and was skipped", name, groupName, previous)
+				continue
+			}
+			service := config.HTTP.Services[name]
+			if service == nil {
+				service = &dynamic.Service{LoadBalancer: &dynamic.ServersLoadBalancer{
+					PassHostHeader: boolPtr(true),
+					Servers:        []dynamic.Server{},
+				}}
+				config.HTTP.Services[name] = service
+				serviceOwners[name] = owner
+			}
+			service.LoadBalancer.Servers = mergeServers(service.LoadBalancer.Servers, groupMemberServers(member, name, opts))
+
+			// Members sharing a path only add servers to its router
+			if previous, exists := routerOwners[name]; exists {
+				if previous != owner {
+					log.Printf("WARN: Router %s of group %s is already defined by %s and was skipped", name, groupName, previous)
+				}
+				continue
+			}
+
+			router := &dynamic.Router{
+				Service: name,
+				Rule:    group.rule,
+			}
+			if member.path != "/" {
+				router.Rule = fmt.Sprintf("(%s) && PathPrefix(`%s`)", group.rule, member.path)
+				if enabled, _ := stringToBool(member.service.Config["traefik.group.stripprefix"]); enabled {
+					middlewareName := name + "-stripprefix"
+					config.HTTP.Middlewares[middlewareName] = &dynamic.Middleware{
+						StripPrefix: &dynamic.StripPrefix{Prefixes: []string{member.path}},
+					}
+					router.Middlewares = []string{middlewareName}
+				}
+			}
+			router.EntryPoints = append([]string{}, group.entrypoints...)
+			if len(router.EntryPoints) == 0 {
+				router.EntryPoints = defaultEntrypoints(member.nodeName, opts)
+			}
+			router.Middlewares = withDefaultMiddlewares(router.Middlewares, opts)
+
+			config.HTTP.Routers[name] = router
+			routerOwners[name] = owner
+		}
+	}
+}
+
+// groupObjectName names the router and service of a group path, e.g. shop
+// for / and shop-api-v1 for /api/v1.
+func groupObjectName(groupName, path string) string {
+	slug := strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
+	if slug == "" {
+		return groupName
+	}
+	return groupName + "-" + slug
+}
+
+// groupMemberServers returns the servers of a group member, using the port
+// of traefik.group.port like a loadbalancer.server.port label.
+func groupMemberServers(member groupMember, serviceName string, opts Options) []dynamic.Server {
+	if isDraining(member.service, serviceName) {
+		log.Printf("Group member %s is draining, its servers are left out of rotation", member.owner)
+		return nil
+	}
+
+	service := member.service
+	service.Config = make(map[string]string, len(member.service.Config)+1)
+	for key, value := range member.service.Config {
+		service.Config[key] = value
+	}
+	if port, exists := member.service.Config["traefik.group.port"]; exists {
+		service.Config[labelKey("http.services", serviceName, "loadbalancer.server.port")] = port
+	}
+
+	var servers []dynamic.Server
+	for _, serverURL := range getServerURLs(service, serviceName, member.nodeName, opts) {
+		servers = append(servers, dynamic.Server{URL: serverURL})
+	}
+	return servers
+}
@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// guestPollLabel sets a poll interval for one guest, shorter than the poll
+// interval of the provider.
+const guestPollLabel = "traefik.pollinterval"
+
+// guestPoll schedules the refresh of a guest polled more often than the
+// others, see refreshGuests. A VMID may be reused by a guest of the other
+// type, so guests are identified by VMID and type.
+type guestPoll struct {
+	cluster   int
+	node      string
+	vmID      uint64
+	guestType string
+	interval  time.Duration
+	next      time.Time
+}
+
+// scheduleGuestPolls returns the refresh schedule of the guests of a full
+// poll with a traefik.pollinterval shorter than pollInterval. Intervals below
+// minPollInterval are raised to it; longer ones have no effect since every
+// guest is refreshed by the full poll.
+func scheduleGuestPolls(scanned []map[string][]internal.Service, pollInterval time.Duration, now time.Time) []guestPoll {
+	var polls []guestPoll
+	for i, clusterServices := range scanned {
+		nodeNames := make([]string, 0, len(clusterServices))
+		for nodeName := range clusterServices {
+			nodeNames = append(nodeNames, nodeName)
+		}
+		sort.Strings(nodeNames)
+
+		for _, nodeName := range nodeNames {
+			for _, service := range clusterServices[nodeName] {
+				value, exists := service.Config[guestPollLabel]
+				if !exists || !isBoolLabelEnabled(service.Config, "traefik.enable") {
+					continue
+				}
+				interval, err := time.ParseDuration(value)
+				if err != nil || interval <= 0 {
+					log.Printf("WARN: Invalid %s %q on %s (ID: %d), using the poll interval", guestPollLabel, value, service.Name, service.ID)
+					continue
+				}
+				if interval >= pollInterval {
+					continue
+				}
+				if interval < minPollInterval {
+					log.Printf("WARN: %s %v on %s (ID: %d) is below %v, using %v", guestPollLabel, interval, service.Name, service.ID, minPollInterval, minPollInterval)
+					interval = minPollInterval
+				}
+				polls = append(polls, guestPoll{cluster: i, node: nodeName, vmID: service.ID, guestType: service.Type, interval: interval, next: now.Add(interval)})
+			}
+		}
+	}
+	return polls
+}
+
+// nextGuestPoll returns the time until the next guest refresh is due, and
+// false when no guest has its own poll interval.
+func nextGuestPoll(polls []guestPoll, now time.Time) (time.Duration, bool) {
+	if len(polls) == 0 {
+		return 0, false
+	}
+	next := polls[0].next
+	for _, poll := range polls[1:] {
+		if poll.next.Before(next) {
+			next = poll.next
+		}
+	}
+	if wait := next.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// refreshGuests rescans the guests whose refresh is due and publishes the
+// configuration of the last full poll with their labels and addresses
+// updated. Guests that are no longer active or included are dropped, and
+// guests whose config or addresses cannot be read keep their previous state,
+// until the next full poll, which also picks up started and new guests.
+func (p *Provider) refreshGuests(ctx context.Context, cfgChan chan<- json.Marshaler, now time.Time) error {
+	start := time.Now()
+
+	refreshed := 0
+	for i := range p.guestPolls {
+		poll := &p.guestPolls[i]
+		if poll.next.After(now) {
+			continue
+		}
+		poll.next = now.Add(poll.interval)
+
+		c := p.clusters[poll.cluster]
+		services := p.scanned[poll.cluster][poll.node]
+		for j, service := range services {
+			if service.ID != poll.vmID || service.Type != poll.guestType {
+				continue
+			}
+			guest := guestRef{VMID: service.ID, Name: service.Name, IsContainer: service.Type == internal.GuestTypeContainer}
+			status, listed, err := guestStatus(c.client, ctx, poll.node, guest)
+			if err != nil {
+				log.Printf("WARN: Could not refresh %s (ID: %d), keeping its previous state: %v", service.Name, service.ID, err)
+				break
+			}
+			if !listed || !c.scanOptions.includeGuest(guest.VMID) || !c.scanOptions.includeName(guest.Name) || !c.scanOptions.isActive(status) {
+				log.Printf("Guest %s (ID: %d) is no longer active, dropping it until the next full poll", service.Name, service.ID)
+				p.scanned[poll.cluster][poll.node] = append(services[:j:j], services[j+1:]...)
+				refreshed++
+				break
+			}
+			updated := scanGuest(c.client, ctx, poll.node, guest, c.scanOptions)
+			if updated == nil || updated.ScanError != "" || updated.AgentFailed {
+				log.Printf("WARN: Could not refresh %s (ID: %d), keeping its previous state", service.Name, service.ID)
+				break
+			}
+			services[j] = *updated
+			refreshed++
+			break
+		}
+	}
+	if refreshed == 0 || ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	_, configuration := buildClusters(p.clusters, p.scanned, p.options)
+	if p.probeTimeout > 0 {
+		probeServers(ctx, configuration, p.probeTimeout)
+	}
+
+	log.Printf("Guest refresh complete: %d guests, %d routers, %d services in %v",
+		refreshed, len(configuration.HTTP.Routers), len(configuration.HTTP.Services), time.Since(start).Round(time.Millisecond))
+
+	if p.holdConfiguration(configuration) {
+		return nil
+	}
+	return p.publishConfiguration(ctx, cfgChan, configuration)
+}
+
+// guestStatus looks a guest up in the guest list of its node and returns its
+// status, and false when it is no longer listed.
+func guestStatus(client ProxmoxAPI, ctx context.Context, nodeName string, guest guestRef) (string, bool, error) {
+	if guest.IsContainer {
+		cts, err := client.GetContainers(ctx, nodeName)
+		if err != nil {
+			return "", false, err
+		}
+		for _, ct := range cts {
+			if ct.VMID == guest.VMID {
+				return ct.Status, true, nil
+			}
+		}
+		return "", false, nil
+	}
+
+	vms, err := client.GetVirtualMachines(ctx, nodeName)
+	if err != nil {
+		return "", false, err
+	}
+	for _, vm := range vms {
+		if vm.VMID == guest.VMID {
+			return vm.Status, true, nil
+		}
+	}
+	return "", false, nil
+}
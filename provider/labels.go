@@ -0,0 +1,498 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+	"github.com/traefik/genconf/dynamic/tls"
+)
+
+// Router label suffixes that applyRouterOptions maps explicitly.
+var handledRouterLabels = map[string]bool{
+	"rule":             true,
+	"service":          true,
+	"entrypoints":      true,
+	"entrypoint":       true,
+	"middlewares":      true,
+	"priority":         true,
+	"tls":              true,
+	"tls.certresolver": true,
+	"tls.domains":      true,
+	"tls.options":      true,
+	"rulesyntax":       true,
+
+	"observability.accesslogs": true,
+	"observability.tracing":    true,
+}
+
+// Service label suffixes that applyServiceOptions and getServiceURL map explicitly.
+var handledServiceLabels = map[string]bool{
+	"loadbalancer.passhostheader":                   true,
+	"loadbalancer.healthcheck.path":                 true,
+	"loadbalancer.healthcheck.interval":             true,
+	"loadbalancer.healthcheck.timeout":              true,
+	"loadbalancer.sticky.cookie.name":               true,
+	"loadbalancer.sticky.cookie.secure":             true,
+	"loadbalancer.sticky.cookie.httponly":           true,
+	"loadbalancer.sticky.cookie.maxage":             true,
+	"loadbalancer.responseforwarding.flushinterval": true,
+	"loadbalancer.serverstransport":                 true,
+	"loadbalancer.server.url":                       true,
+	"loadbalancer.server.scheme":                    true,
+	"loadbalancer.server.port":                      true,
+	"loadbalancer.server.ip":                        true,
+	"loadbalancer.server.weight":                    true,
+	"loadbalancer.server.proxyprotocol.version":     true,
+	"loadbalancer.strategy":                         true,
+}
+
+var routerTLSDomainPattern = regexp.MustCompile(`^tls\.domains\[(\d+)\]\.(main|sans)$`)
+
+// Top-level labels that are consumed outside of the router/service/middleware sections.
+var handledGlobalLabels = map[string]bool{
+	"traefik.enable": true,
+	"traefik.drain":  true,
+	guestPollLabel:   true,
+	guestPortLabel:   true,
+}
+
+// labelKey builds the key of a label of a named object, such as
+// traefik.http.routers.myapp.rule for labelKey("http.routers", "MyApp", "rule").
+// Keys are lowercased when labels are parsed, so the name is as well. Without
+// an option it returns the prefix shared by the labels of the object.
+func labelKey(section, name, option string) string {
+	key := "traefik." + section + "." + strings.ToLower(name)
+	if option == "" {
+		return key
+	}
+	return key + "." + option
+}
+
+// labelReference normalizes a router, service or middleware named in a label
+// value like the names taken from label keys, so service=MyApp points at the
+// service declared with traefik.http.services.MyApp.* labels. Names of other
+// providers (name@provider) are left alone.
+func labelReference(name string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+func labelReferences(names []string) []string {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = labelReference(name)
+	}
+	return normalized
+}
+
+// applyLabelPassthrough reflects every label below prefix that has no explicit
+// handling onto target, matching path segments against JSON field names.
+// Labels that cannot be mapped are logged and ignored.
+func applyLabelPassthrough(target interface{}, labels map[string]string, prefix string, isHandled func(string) bool) {
+	for key, value := range labels {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if rest == "" || (isHandled != nil && isHandled(rest)) {
+			continue
+		}
+		if err := setLabelValue(reflect.ValueOf(target), strings.Split(rest, "."), value); err != nil {
+			log.Printf("WARN: Label %s is not supported and was ignored: %v", key, err)
+		}
+	}
+}
+
+func isHandledRouterLabel(rest string) bool {
+	return handledRouterLabels[rest] || routerTLSDomainPattern.MatchString(rest)
+}
+
+func isHandledServiceLabel(rest string) bool {
+	return handledServiceLabels[rest] || strings.HasPrefix(rest, "loadbalancer.server.port.")
+}
+
+// labelSectionNames returns the object names declared with
+// <prefix><name>.<option> labels, e.g. middleware names.
+func labelSectionNames(service internal.Service, prefix string) []string {
+	names := make(map[string]bool)
+	for key := range service.Config {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name, option, found := strings.Cut(strings.TrimPrefix(key, prefix), ".")
+		if !found || name == "" || option == "" {
+			log.Printf("WARN: Label %s does not name an option and was ignored", key)
+			continue
+		}
+		names[name] = true
+	}
+	return mapKeysToSlice(names)
+}
+
+// buildMiddlewares creates the middlewares declared inline with
+// traefik.http.middlewares.<name>.<type>.<option> labels.
+func buildMiddlewares(service internal.Service) map[string]*dynamic.Middleware {
+	middlewares := make(map[string]*dynamic.Middleware)
+	for _, name := range labelSectionNames(service, "traefik.http.middlewares.") {
+		middlewares[name] = &dynamic.Middleware{}
+	}
+
+	for name, middleware := range middlewares {
+		prefix := labelKey("http.middlewares", name, "") + "."
+		applyLabelPassthrough(middleware, service.Config, prefix, nil)
+		if reflect.DeepEqual(*middleware, dynamic.Middleware{}) {
+			delete(middlewares, name)
+			continue
+		}
+		if err := validateMiddleware(middleware); err != nil {
+			log.Printf("WARN: Middleware %s of %s (ID: %d) is invalid and was skipped: %v", name, service.Name, service.ID, err)
+			delete(middlewares, name)
+			continue
+		}
+		if auth := middleware.BasicAuth; auth != nil && auth.UsersFile != "" && len(auth.Users) > 0 {
+			// Traefik merges both sources; the file is preferred so that
+			// hashes can be kept out of the notes altogether.
+			log.Printf("WARN: Middleware %s of %s (ID: %d) sets both basicauth.users and basicauth.usersfile, using usersfile", name, service.Name, service.ID)
+			auth.Users = nil
+		}
+		if middleware.Headers != nil {
+			middleware.Headers.CustomRequestHeaders = canonicalHeaderNames(middleware.Headers.CustomRequestHeaders)
+			middleware.Headers.CustomResponseHeaders = canonicalHeaderNames(middleware.Headers.CustomResponseHeaders)
+		}
+	}
+	return middlewares
+}
+
+// canonicalHeaderNames restores the usual spelling of header names, which
+// arrive lowercased from the label keys. An empty value removes the header.
+func canonicalHeaderNames(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical
+}
+
+// validateMiddleware checks that a middleware declares a single type, as
+// Traefik requires, and that retry settings are usable.
+func validateMiddleware(middleware *dynamic.Middleware) error {
+	var types []string
+	v := reflect.ValueOf(middleware).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			types = append(types, v.Type().Field(i).Name)
+		}
+	}
+	if len(types) > 1 {
+		return fmt.Errorf("declares several types (%s), use one middleware per type", strings.Join(types, ", "))
+	}
+
+	if retry := middleware.Retry; retry != nil {
+		if retry.Attempts <= 0 {
+			return fmt.Errorf("retry.attempts must be a positive number, got %d", retry.Attempts)
+		}
+		if retry.InitialInterval != "" && !isValidDuration(retry.InitialInterval) {
+			return fmt.Errorf("invalid retry.initialinterval %q", retry.InitialInterval)
+		}
+	}
+
+	if buffering := middleware.Buffering; buffering != nil {
+		if err := validateBuffering(buffering); err != nil {
+			return err
+		}
+	}
+
+	if rateLimit := middleware.RateLimit; rateLimit != nil {
+		if err := validateRateLimit(rateLimit); err != nil {
+			return err
+		}
+	}
+
+	if inFlightReq := middleware.InFlightReq; inFlightReq != nil && inFlightReq.Amount <= 0 {
+		return fmt.Errorf("inflightreq.amount must be a positive number, got %d", inFlightReq.Amount)
+	}
+	return nil
+}
+
+// validateBuffering checks the body size limits of a buffering middleware.
+// A maximum of 0 means no limit; a memory threshold above the maximum would
+// never spill to disk.
+func validateBuffering(buffering *dynamic.Buffering) error {
+	sizes := []struct {
+		name  string
+		value int64
+	}{
+		{"maxrequestbodybytes", buffering.MaxRequestBodyBytes},
+		{"memrequestbodybytes", buffering.MemRequestBodyBytes},
+		{"maxresponsebodybytes", buffering.MaxResponseBodyBytes},
+		{"memresponsebodybytes", buffering.MemResponseBodyBytes},
+	}
+	for _, size := range sizes {
+		if size.value < 0 {
+			return fmt.Errorf("buffering.%s must not be negative, got %d", size.name, size.value)
+		}
+	}
+	if buffering.MaxRequestBodyBytes > 0 && buffering.MemRequestBodyBytes > buffering.MaxRequestBodyBytes {
+		return fmt.Errorf("buffering.memrequestbodybytes (%d) exceeds buffering.maxrequestbodybytes (%d)", buffering.MemRequestBodyBytes, buffering.MaxRequestBodyBytes)
+	}
+	if buffering.MaxResponseBodyBytes > 0 && buffering.MemResponseBodyBytes > buffering.MaxResponseBodyBytes {
+		return fmt.Errorf("buffering.memresponsebodybytes (%d) exceeds buffering.maxresponsebodybytes (%d)", buffering.MemResponseBodyBytes, buffering.MaxResponseBodyBytes)
+	}
+	return nil
+}
+
+// validateRateLimit checks the rate of a ratelimit middleware. Traefik allows
+// average requests per period with bursts of up to burst requests; an
+// average of 0 disables the limit, which is most likely a mistake in a label.
+func validateRateLimit(rateLimit *dynamic.RateLimit) error {
+	if rateLimit.Average <= 0 {
+		return fmt.Errorf("ratelimit.average must be a positive number, got %d", rateLimit.Average)
+	}
+	if rateLimit.Burst < 0 {
+		return fmt.Errorf("ratelimit.burst must not be negative, got %d", rateLimit.Burst)
+	}
+	if rateLimit.Period != "" && (!isValidDuration(rateLimit.Period) || strings.HasPrefix(rateLimit.Period, "-")) {
+		return fmt.Errorf("invalid ratelimit.period %q", rateLimit.Period)
+	}
+	return nil
+}
+
+// buildServersTransports creates the servers transports declared with
+// traefik.http.serverstransports.<name>.<option> labels, e.g. rootcas.
+func buildServersTransports(service internal.Service) map[string]*dynamic.ServersTransport {
+	transports := make(map[string]*dynamic.ServersTransport)
+	for _, name := range labelSectionNames(service, "traefik.http.serverstransports.") {
+		transport := &dynamic.ServersTransport{}
+		prefix := labelKey("http.serverstransports", name, "") + "."
+		applyLabelPassthrough(transport, service.Config, prefix, nil)
+		if reflect.DeepEqual(*transport, dynamic.ServersTransport{}) {
+			continue
+		}
+		transports[name] = transport
+	}
+	return transports
+}
+
+// tlsVersions are the TLS versions Traefik accepts for minVersion and maxVersion.
+var tlsVersions = map[string]bool{
+	"VersionTLS10": true,
+	"VersionTLS11": true,
+	"VersionTLS12": true,
+	"VersionTLS13": true,
+}
+
+// buildTLSOptions creates the TLS options declared with
+// traefik.tls.options.<name>.<option> labels, e.g. minversion and snistrict.
+func buildTLSOptions(service internal.Service) map[string]tls.Options {
+	options := make(map[string]tls.Options)
+	for _, name := range labelSectionNames(service, "traefik.tls.options.") {
+		option := tls.Options{}
+		prefix := labelKey("tls.options", name, "") + "."
+		applyLabelPassthrough(&option, service.Config, prefix, nil)
+
+		if err := validateTLSOptions(option); err != nil {
+			log.Printf("WARN: TLS options %s of %s (ID: %d) are invalid and were skipped: %v", name, service.Name, service.ID, err)
+			continue
+		}
+		if reflect.DeepEqual(option, tls.Options{}) {
+			continue
+		}
+		options[name] = option
+	}
+	return options
+}
+
+// defaultTLSStore is the only TLS store Traefik supports.
+const defaultTLSStore = "default"
+
+// buildTLSStores creates the TLS store declared with
+// traefik.tls.stores.default.<option> labels, e.g. the default certificate.
+func buildTLSStores(service internal.Service) map[string]tls.Store {
+	stores := make(map[string]tls.Store)
+	for _, name := range labelSectionNames(service, "traefik.tls.stores.") {
+		if name != defaultTLSStore {
+			log.Printf("WARN: TLS store %s of %s (ID: %d) was skipped, Traefik only supports the %s store", name, service.Name, service.ID, defaultTLSStore)
+			continue
+		}
+		store := tls.Store{}
+		prefix := labelKey("tls.stores", name, "") + "."
+		applyLabelPassthrough(&store, service.Config, prefix, nil)
+
+		if err := validateTLSStore(store); err != nil {
+			log.Printf("WARN: TLS store %s of %s (ID: %d) is invalid and was skipped: %v", name, service.Name, service.ID, err)
+			continue
+		}
+		if reflect.DeepEqual(store, tls.Store{}) {
+			continue
+		}
+		stores[name] = store
+	}
+	return stores
+}
+
+// validateTLSStore checks that a default certificate names both its files.
+// The files are read by Traefik, which may run on another host.
+func validateTLSStore(store tls.Store) error {
+	if cert := store.DefaultCertificate; cert != nil && (cert.CertFile == "" || cert.KeyFile == "") {
+		return fmt.Errorf("defaultcertificate needs both certfile and keyfile")
+	}
+	return nil
+}
+
+func validateTLSOptions(option tls.Options) error {
+	for _, version := range []string{option.MinVersion, option.MaxVersion} {
+		if version != "" && !tlsVersions[version] {
+			return fmt.Errorf("unknown TLS version %q, expected one of VersionTLS10 to VersionTLS13", version)
+		}
+	}
+	return nil
+}
+
+// logUnhandledLabels warns about traefik.* labels outside of the sections this
+// provider knows how to map, so users notice they are not being applied.
+func logUnhandledLabels(service internal.Service) {
+	for key := range service.Config {
+		if handledGlobalLabels[key] || handledGroupLabels[key] || isLabelSection(key) {
+			continue
+		}
+		if suggestion := suggestLabel(key); suggestion != "" {
+			log.Printf("WARN: Label %s on %s (ID: %d) is not supported and was ignored, did you mean %s?", key, service.Name, service.ID, suggestion)
+			continue
+		}
+		log.Printf("WARN: Label %s on %s (ID: %d) is not supported and was ignored", key, service.Name, service.ID)
+	}
+}
+
+// setLabelValue walks path through v, allocating pointers, maps and structs as
+// needed, and stores the label value in the field it ends on.
+func setLabelValue(v reflect.Value, path []string, value string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return setLabelValue(v.Elem(), path, value)
+		}
+		if !v.CanSet() {
+			return fmt.Errorf("cannot set %s", strings.Join(path, "."))
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := setLabelValue(elem.Elem(), path, value); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+
+	case reflect.Struct:
+		if len(path) == 0 {
+			// Option-less middlewares such as compress=true only need to exist.
+			enabled, err := stringToBool(value)
+			if err != nil || !enabled {
+				return fmt.Errorf("expected true to enable %s", v.Type().Name())
+			}
+			return nil
+		}
+		field, ok := fieldByJSONName(v, path[0])
+		if !ok {
+			if suggestion := suggestOption(v.Type(), path[0]); suggestion != "" {
+				return fmt.Errorf("unknown option %q, did you mean %q?", path[0], suggestion)
+			}
+			return fmt.Errorf("unknown option %q", path[0])
+		}
+		return setLabelValue(field, path[1:], value)
+
+	case reflect.Map:
+		if len(path) == 0 {
+			return fmt.Errorf("missing key for %s", v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(path[0]).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setLabelValue(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+
+	case reflect.Interface:
+		if len(path) > 0 {
+			nested := make(map[string]interface{})
+			if existing, ok := v.Interface().(map[string]interface{}); ok {
+				nested = existing
+			}
+			nestedValue := reflect.ValueOf(nested)
+			if err := setLabelValue(nestedValue, path, value); err != nil {
+				return err
+			}
+			v.Set(nestedValue)
+			return nil
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	if len(path) > 0 {
+		return fmt.Errorf("unknown option %q", path[0])
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := stringToBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(i)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(v.Type(), 0, len(items))
+		for _, item := range items {
+			slice = reflect.Append(slice, reflect.ValueOf(strings.TrimSpace(item)).Convert(v.Type().Elem()))
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported value type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldByJSONName finds a struct field by its JSON name, ignoring case since
+// label keys are normalized to lowercase.
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		jsonName, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if jsonName == "" {
+			jsonName = t.Field(i).Name
+		}
+		if strings.EqualFold(jsonName, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
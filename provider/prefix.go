@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"strings"
+
+	"github.com/traefik/genconf/dynamic"
+)
+
+// prefixName qualifies a generated name with the provider prefix. Names
+// pointing at another provider (name@provider) are left alone.
+func prefixName(prefix, name string) string {
+	if prefix == "" || name == "" || strings.Contains(name, "@") {
+		return name
+	}
+	return prefix + name
+}
+
+func prefixNames(prefix string, names []string) []string {
+	if len(names) == 0 {
+		return names
+	}
+	prefixed := make([]string, len(names))
+	for i, name := range names {
+		prefixed[i] = prefixName(prefix, name)
+	}
+	return prefixed
+}
+
+// applyProviderPrefix renames every generated router, service, middleware and
+// servers transport, and the references between them, so the objects of this
+// provider stand out in the Traefik dashboard.
+func applyProviderPrefix(config *dynamic.Configuration, prefix string) {
+	if prefix == "" {
+		return
+	}
+
+	routers := make(map[string]*dynamic.Router, len(config.HTTP.Routers))
+	for name, router := range config.HTTP.Routers {
+		router.Service = prefixName(prefix, router.Service)
+		router.Middlewares = prefixNames(prefix, router.Middlewares)
+		routers[prefixName(prefix, name)] = router
+	}
+	config.HTTP.Routers = routers
+
+	services := make(map[string]*dynamic.Service, len(config.HTTP.Services))
+	for name, service := range config.HTTP.Services {
+		if service.LoadBalancer != nil {
+			service.LoadBalancer.ServersTransport = prefixName(prefix, service.LoadBalancer.ServersTransport)
+		}
+		if service.Weighted != nil {
+			for i := range service.Weighted.Services {
+				service.Weighted.Services[i].Name = prefixName(prefix, service.Weighted.Services[i].Name)
+			}
+		}
+		if service.Mirroring != nil {
+			service.Mirroring.Service = prefixName(prefix, service.Mirroring.Service)
+			for i := range service.Mirroring.Mirrors {
+				service.Mirroring.Mirrors[i].Name = prefixName(prefix, service.Mirroring.Mirrors[i].Name)
+			}
+		}
+		if service.Failover != nil {
+			service.Failover.Service = prefixName(prefix, service.Failover.Service)
+			service.Failover.Fallback = prefixName(prefix, service.Failover.Fallback)
+		}
+		services[prefixName(prefix, name)] = service
+	}
+	config.HTTP.Services = services
+
+	middlewares := make(map[string]*dynamic.Middleware, len(config.HTTP.Middlewares))
+	for name, middleware := range config.HTTP.Middlewares {
+		if middleware.Chain != nil {
+			middleware.Chain.Middlewares = prefixNames(prefix, middleware.Chain.Middlewares)
+		}
+		middlewares[prefixName(prefix, name)] = middleware
+	}
+	config.HTTP.Middlewares = middlewares
+
+	transports := make(map[string]*dynamic.ServersTransport, len(config.HTTP.ServersTransports))
+	for name, transport := range config.HTTP.ServersTransports {
+		transports[prefixName(prefix, name)] = transport
+	}
+	config.HTTP.ServersTransports = transports
+
+	tcpRouters := make(map[string]*dynamic.TCPRouter, len(config.TCP.Routers))
+	for name, router := range config.TCP.Routers {
+		router.Service = prefixName(prefix, router.Service)
+		tcpRouters[prefixName(prefix, name)] = router
+	}
+	config.TCP.Routers = tcpRouters
+
+	tcpServices := make(map[string]*dynamic.TCPService, len(config.TCP.Services))
+	for name, service := range config.TCP.Services {
+		if service.Weighted != nil {
+			for i := range service.Weighted.Services {
+				service.Weighted.Services[i].Name = prefixName(prefix, service.Weighted.Services[i].Name)
+			}
+		}
+		tcpServices[prefixName(prefix, name)] = service
+	}
+	config.TCP.Services = tcpServices
+
+	udpRouters := make(map[string]*dynamic.UDPRouter, len(config.UDP.Routers))
+	for name, router := range config.UDP.Routers {
+		router.Service = prefixName(prefix, router.Service)
+		udpRouters[prefixName(prefix, name)] = router
+	}
+	config.UDP.Routers = udpRouters
+
+	udpServices := make(map[string]*dynamic.UDPService, len(config.UDP.Services))
+	for name, service := range config.UDP.Services {
+		if service.Weighted != nil {
+			for i := range service.Weighted.Services {
+				service.Weighted.Services[i].Name = prefixName(prefix, service.Weighted.Services[i].Name)
+			}
+		}
+		udpServices[prefixName(prefix, name)] = service
+	}
+	config.UDP.Services = udpServices
+}
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/traefik/genconf/dynamic"
+)
+
+// defaultProbeTimeout bounds each backend probe when probeTimeout is unset.
+const defaultProbeTimeout = time.Second
+
+// probeServers removes the HTTP and TCP servers whose address does not accept
+// a TCP connection within timeout, so routes are not published to backends
+// that are not listening yet. All addresses are dialed at once, which bounds
+// the added latency of a poll to a single timeout.
+func probeServers(ctx context.Context, config *dynamic.Configuration, timeout time.Duration) {
+	addresses := make(map[string]bool)
+	for _, service := range config.HTTP.Services {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		for _, server := range service.LoadBalancer.Servers {
+			if address := serverAddress(server.URL); address != "" {
+				addresses[address] = true
+			}
+		}
+	}
+	for _, service := range config.TCP.Services {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		for _, server := range service.LoadBalancer.Servers {
+			addresses[server.Address] = true
+		}
+	}
+
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	dialer := &net.Dialer{Timeout: timeout}
+	for address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				mu.Lock()
+				failures[address] = err
+				mu.Unlock()
+				return
+			}
+			conn.Close()
+		}(address)
+	}
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return
+	}
+	for serviceName, service := range config.HTTP.Services {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		servers := make([]dynamic.Server, 0, len(service.LoadBalancer.Servers))
+		for _, server := range service.LoadBalancer.Servers {
+			if err, failed := failures[serverAddress(server.URL)]; failed {
+				log.Printf("WARN: Server %s of service %s failed the probe and was left out: %v", server.URL, serviceName, err)
+				continue
+			}
+			servers = append(servers, server)
+		}
+		service.LoadBalancer.Servers = servers
+	}
+	for serviceName, service := range config.TCP.Services {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		servers := make([]dynamic.TCPServer, 0, len(service.LoadBalancer.Servers))
+		for _, server := range service.LoadBalancer.Servers {
+			if err, failed := failures[server.Address]; failed {
+				log.Printf("WARN: Server %s of TCP service %s failed the probe and was left out: %v", server.Address, serviceName, err)
+				continue
+			}
+			servers = append(servers, server)
+		}
+		service.LoadBalancer.Servers = servers
+	}
+}
+
+// serverAddress returns the host:port a server URL connects to, using the
+// default port of the scheme when the URL has none.
+func serverAddress(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
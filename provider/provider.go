@@ -2,15 +2,22 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/NX211/traefik-proxmox-provider/internal"
@@ -21,20 +28,96 @@ import (
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval             string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	ApiEndpoint              string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	ApiTokenId               string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken                 string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiLogging               string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	ApiValidateSSL           string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PoolFilter               string `json:"poolFilter" yaml:"poolFilter" toml:"poolFilter"`
+	DefaultEntrypoints       string `json:"defaultEntrypoints" yaml:"defaultEntrypoints" toml:"defaultEntrypoints"`
+	SkipAgentNotReady        string `json:"skipAgentNotReady" yaml:"skipAgentNotReady" toml:"skipAgentNotReady"`
+	LabelSource              string `json:"labelSource" yaml:"labelSource" toml:"labelSource"`
+	LabelField               string `json:"labelField" yaml:"labelField" toml:"labelField"`
+	DisableHostnameFallback  string `json:"disableHostnameFallback" yaml:"disableHostnameFallback" toml:"disableHostnameFallback"`
+	IncludeVMIDs             string `json:"includeVMIDs" yaml:"includeVMIDs" toml:"includeVMIDs"`
+	ExcludeVMIDs             string `json:"excludeVMIDs" yaml:"excludeVMIDs" toml:"excludeVMIDs"`
+	BackendInterface         string `json:"backendInterface" yaml:"backendInterface" toml:"backendInterface"`
+	ApiClientCert            string `json:"apiClientCert" yaml:"apiClientCert" toml:"apiClientCert"`
+	ApiClientKey             string `json:"apiClientKey" yaml:"apiClientKey" toml:"apiClientKey"`
+	ApiUser                  string `json:"apiUser" yaml:"apiUser" toml:"apiUser"`
+	ApiPassword              string `json:"apiPassword" yaml:"apiPassword" toml:"apiPassword"`
+	ApiRealm                 string `json:"apiRealm" yaml:"apiRealm" toml:"apiRealm"`
+	InferScheme              string `json:"inferScheme" yaml:"inferScheme" toml:"inferScheme"`
+	DefaultScheme            string `json:"defaultScheme" yaml:"defaultScheme" toml:"defaultScheme"`
+	ApiMaxResponseSize       string `json:"apiMaxResponseSize" yaml:"apiMaxResponseSize" toml:"apiMaxResponseSize"`
+	ProviderPrefix           string `json:"providerPrefix" yaml:"providerPrefix" toml:"providerPrefix"`
+	AllowFastPolling         string `json:"allowFastPolling" yaml:"allowFastPolling" toml:"allowFastPolling"`
+	PollJitter               string `json:"pollJitter" yaml:"pollJitter" toml:"pollJitter"`
+	NodeRefreshInterval      string `json:"nodeRefreshInterval" yaml:"nodeRefreshInterval" toml:"nodeRefreshInterval"`
+	RefreshListenAddress     string `json:"refreshListenAddress" yaml:"refreshListenAddress" toml:"refreshListenAddress"`
+	ClusterNames             string `json:"clusterNames" yaml:"clusterNames" toml:"clusterNames"`
+	DefaultMiddlewares       string `json:"defaultMiddlewares" yaml:"defaultMiddlewares" toml:"defaultMiddlewares"`
+	DefaultMiddlewaresOrder  string `json:"defaultMiddlewaresOrder" yaml:"defaultMiddlewaresOrder" toml:"defaultMiddlewaresOrder"`
+	VMIDToIP                 string `json:"vmidToIP" yaml:"vmidToIP" toml:"vmidToIP"`
+	AutoDetectPort           string `json:"autoDetectPort" yaml:"autoDetectPort" toml:"autoDetectPort"`
+	NameTemplate             string `json:"nameTemplate" yaml:"nameTemplate" toml:"nameTemplate"`
+	GuestConcurrency         string `json:"guestConcurrency" yaml:"guestConcurrency" toml:"guestConcurrency"`
+	NodeEntrypoints          string `json:"nodeEntrypoints" yaml:"nodeEntrypoints" toml:"nodeEntrypoints"`
+	LabelPrefix              string `json:"labelPrefix" yaml:"labelPrefix" toml:"labelPrefix"`
+	ServerURLTemplate        string `json:"serverURLTemplate" yaml:"serverURLTemplate" toml:"serverURLTemplate"`
+	NameFilter               string `json:"nameFilter" yaml:"nameFilter" toml:"nameFilter"`
+	ProbeBackends            string `json:"probeBackends" yaml:"probeBackends" toml:"probeBackends"`
+	ProbeTimeout             string `json:"probeTimeout" yaml:"probeTimeout" toml:"probeTimeout"`
+	DefaultPort              string `json:"defaultPort" yaml:"defaultPort" toml:"defaultPort"`
+	ApiHeader                string `json:"apiHeader" yaml:"apiHeader" toml:"apiHeader"`
+	DefaultHTTPEntrypoints   string `json:"defaultHTTPEntrypoints" yaml:"defaultHTTPEntrypoints" toml:"defaultHTTPEntrypoints"`
+	DefaultTCPEntrypoints    string `json:"defaultTCPEntrypoints" yaml:"defaultTCPEntrypoints" toml:"defaultTCPEntrypoints"`
+	DefaultUDPEntrypoints    string `json:"defaultUDPEntrypoints" yaml:"defaultUDPEntrypoints" toml:"defaultUDPEntrypoints"`
+	MaxServersPerService     string `json:"maxServersPerService" yaml:"maxServersPerService" toml:"maxServersPerService"`
+	PortFromTags             string `json:"portFromTags" yaml:"portFromTags" toml:"portFromTags"`
+	DebounceWindow           string `json:"debounceWindow" yaml:"debounceWindow" toml:"debounceWindow"`
+	ApiCAFile                string `json:"apiCAFile" yaml:"apiCAFile" toml:"apiCAFile"`
+	ResolveFallbackHostnames string `json:"resolveFallbackHostnames" yaml:"resolveFallbackHostnames" toml:"resolveFallbackHostnames"`
+	UseResolvedAddresses     string `json:"useResolvedAddresses" yaml:"useResolvedAddresses" toml:"useResolvedAddresses"`
+	PublishDiagnostics       string `json:"publishDiagnostics" yaml:"publishDiagnostics" toml:"publishDiagnostics"`
+	NormalizeNames           string `json:"normalizeNames" yaml:"normalizeNames" toml:"normalizeNames"`
+	ActiveStatuses           string `json:"activeStatuses" yaml:"activeStatuses" toml:"activeStatuses"`
+	RequireVersionAtStartup  string `json:"requireVersionAtStartup" yaml:"requireVersionAtStartup" toml:"requireVersionAtStartup"`
+	CloneGroupPattern        string `json:"cloneGroupPattern" yaml:"cloneGroupPattern" toml:"cloneGroupPattern"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
-		PollInterval:   "30s", // Default to 30 seconds for polling
-		ApiValidateSSL: "true",
-		ApiLogging:     "info",
+		PollInterval:             "30s", // Default to 30 seconds for polling
+		ApiValidateSSL:           "true",
+		ApiLogging:               "info",
+		SkipAgentNotReady:        "false",
+		LabelSource:              labelSourceDescription,
+		LabelField:               defaultLabelField,
+		LabelPrefix:              internal.DefaultLabelPrefix,
+		DisableHostnameFallback:  "false",
+		InferScheme:              "false",
+		DefaultScheme:            "http",
+		ProviderPrefix:           "proxmox-",
+		AllowFastPolling:         "false",
+		PollJitter:               "0",
+		NodeRefreshInterval:      "5m",
+		ProbeBackends:            "false",
+		ProbeTimeout:             defaultProbeTimeout.String(),
+		DefaultMiddlewaresOrder:  middlewaresOrderFirst,
+		AutoDetectPort:           "false",
+		PortFromTags:             "false",
+		DebounceWindow:           "0s",
+		ResolveFallbackHostnames: "false",
+		UseResolvedAddresses:     "false",
+		PublishDiagnostics:       "false",
+		NormalizeNames:           "false",
+		ActiveStatuses:           defaultActiveStatus,
+		RequireVersionAtStartup:  "false",
+		GuestConcurrency:         strconv.Itoa(defaultGuestConcurrency),
+		MaxServersPerService:     strconv.Itoa(defaultMaxServersPerService),
 	}
 }
 
@@ -42,8 +125,166 @@ func CreateConfig() *Config {
 type Provider struct {
 	name         string
 	pollInterval time.Duration
-	client       *internal.ProxmoxClient
+	pollJitter   float64
+	clusters     []cluster
+	options      Options
 	cancel       func()
+	// done is closed once loadConfiguration has returned.
+	done      chan struct{}
+	published bool
+	// refreshAddress enables the refresh listener, see startRefreshListener.
+	refreshAddress string
+	// refresh queues an out-of-band poll.
+	refresh chan struct{}
+	// probeTimeout enables probing the backends of every poll, see probeServers.
+	probeTimeout time.Duration
+	// debounce holds changed configurations back, see holdConfiguration.
+	debounce time.Duration
+	// pending is the latest configuration held back by the debounce.
+	pending *dynamic.Configuration
+	// lastPublished is the JSON of the last published configuration, kept
+	// when debouncing to detect changes.
+	lastPublished []byte
+	// scanned holds the guests of each cluster found by the last full poll,
+	// updated by refreshGuests.
+	scanned []map[string][]internal.Service
+	// guestPolls schedules the guests with their own poll interval.
+	guestPolls []guestPoll
+
+	// mu guards the poll status reported by LastPollTime, LastError and
+	// RouteCount.
+	mu           sync.Mutex
+	lastPollTime time.Time
+	lastError    error
+	routeCount   int
+}
+
+// ProxmoxAPI is the part of the Proxmox API used to discover guests. It is
+// implemented by *internal.ProxmoxClient and can be faked in tests.
+type ProxmoxAPI interface {
+	GetVersion(ctx context.Context) (*internal.Version, error)
+	GetNodes(ctx context.Context) ([]internal.NodeStatus, error)
+	GetVirtualMachines(ctx context.Context, nodeName string) ([]internal.VirtualMachine, error)
+	GetContainers(ctx context.Context, nodeName string) ([]internal.Container, error)
+	GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error)
+	GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error)
+	GetVMNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error)
+	GetContainerNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error)
+	GetPool(ctx context.Context, poolID string) (*internal.Pool, error)
+	GetVMListeningPorts(ctx context.Context, nodeName string, vmID uint64) ([]int, error)
+}
+
+// initialRetryInterval bounds the wait before retrying a failed initial poll.
+const initialRetryInterval = 5 * time.Second
+
+// scanOptions controls which guests are considered during a scan.
+type scanOptions struct {
+	// Pools restricts scanning to members of these resource pools.
+	Pools []string
+	// PoolMembers holds the VMIDs resolved from Pools for the current scan.
+	PoolMembers map[uint64]bool
+	// IncludeVMIDs, when set, limits scanning to these VMIDs.
+	IncludeVMIDs []vmidRange
+	// ExcludeVMIDs are never scanned.
+	ExcludeVMIDs []vmidRange
+	// NameFilter, when set, limits scanning to guests with a matching name.
+	NameFilter *regexp.Regexp
+	// ActiveStatuses are the guest statuses that produce routes, see
+	// isActive.
+	ActiveStatuses []string
+	// LabelSource selects where labels are read from, see getLabels.
+	LabelSource string
+	// LabelField is the configuration key read with the field label source.
+	LabelField string
+	// LabelPrefix is the prefix of the labels read, "traefik." when empty.
+	LabelPrefix string
+	// BackendInterface selects the advertised backend addresses by interface
+	// name or CIDR subnet, see selectBackendIPs.
+	BackendInterface string
+	// Debug enables per-guest scan logging.
+	Debug bool
+	// SkipAgentNotReady leaves out VMs whose guest agent is not running yet
+	// instead of routing them to the hostname fallback.
+	SkipAgentNotReady bool
+	// Nodes, when set, serves the node list between polls, see nodeCache.
+	Nodes *nodeCache
+	// StaticIPs are used for guests whose agent reports no address.
+	StaticIPs map[uint64][]internal.IP
+	// AutoDetectPort asks the guest agent of enabled VMs without a port
+	// label for their listening ports, see detectPort.
+	AutoDetectPort bool
+	// PortDetectTimeout bounds each port detection, defaultPortDetectTimeout
+	// when zero.
+	PortDetectTimeout time.Duration
+	// ResolveFallbackHostnames looks up the fallback hostname of enabled
+	// guests without addresses, see resolveFallbackHostname.
+	ResolveFallbackHostnames bool
+	// UseResolvedAddresses uses the resolved addresses instead of the
+	// fallback hostname.
+	UseResolvedAddresses bool
+	// LookupIP resolves fallback hostnames, net.DefaultResolver when nil.
+	LookupIP lookupFunc
+	// PublishDiagnostics keeps guests that fail to scan, with their
+	// ScanError set, so BuildConfiguration can publish them, see
+	// addDiagnostics.
+	PublishDiagnostics bool
+	// PortFromTags reads the port of services without a port label from a
+	// port-<n> tag, see tagPort.
+	PortFromTags bool
+	// GuestConcurrency bounds how many guests of a node are fetched at once.
+	GuestConcurrency int
+}
+
+// vmidRange is an inclusive range of VMIDs.
+type vmidRange struct {
+	From uint64
+	To   uint64
+}
+
+// Options holds provider-wide defaults used by BuildConfiguration.
+type Options struct {
+	// DefaultEntrypoints are used for routers without an entrypoints label.
+	DefaultEntrypoints []string
+	// NodeEntrypoints override DefaultEntrypoints for guests on these nodes.
+	NodeEntrypoints map[string][]string
+	// DefaultTCPEntrypoints replace NodeEntrypoints and DefaultEntrypoints for
+	// TCP routers without an entrypoints label.
+	DefaultTCPEntrypoints []string
+	// DefaultUDPEntrypoints are used for UDP routers without an entrypoints
+	// label.
+	DefaultUDPEntrypoints []string
+	// DefaultMiddlewares are added to every HTTP router, before its own
+	// middlewares unless DefaultMiddlewaresLast is set.
+	DefaultMiddlewares     []string
+	DefaultMiddlewaresLast bool
+	// DisableHostnameFallback leaves services without a discovered IP
+	// without servers instead of pointing them at <name>.<node>.
+	DisableHostnameFallback bool
+	// InferScheme picks the scheme from well-known ports when no scheme
+	// label is set, see getServiceScheme.
+	InferScheme bool
+	// DefaultScheme is used for services without a scheme label, http when empty.
+	DefaultScheme string
+	// DefaultPort is used for services without a port label instead of the
+	// default port of the scheme.
+	DefaultPort string
+	// MaxServersPerService caps the servers of a load balancer, see
+	// capServers. Zero disables the cap.
+	MaxServersPerService int
+	// ProviderPrefix is prepended to every generated object name.
+	ProviderPrefix string
+	// NameTemplate names the routers and services of guests without labels
+	// naming them, see defaultServiceKey.
+	NameTemplate string
+	// NormalizeNames normalizes guest names in the default rules and names,
+	// see normalizeName.
+	NormalizeNames bool
+	// CloneGroupPattern groups guests whose names match it under the name
+	// captured by its group, see cloneGroup.
+	CloneGroupPattern *regexp.Regexp
+	// ServerURLTemplate, when set, formats the server URLs built from
+	// discovered addresses, see formatServerURL.
+	ServerURLTemplate *template.Template
 }
 
 // New creates a new Provider plugin.
@@ -52,157 +293,1222 @@ func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	pi, err := time.ParseDuration(config.PollInterval)
+	pi, err := parsePollInterval(config.PollInterval, config.AllowFastPolling == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	jitter, err := parsePollJitter(config.PollJitter)
+	if err != nil {
+		return nil, err
+	}
+
+	if effective, err := json.Marshal(redactedConfig(config)); err == nil {
+		log.Printf("Starting provider %s with poll interval %s and configuration %s", name, pi, effective)
+	}
+
+	includeVMIDs, err := parseVMIDList(config.IncludeVMIDs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid includeVMIDs: %w", err)
+	}
+
+	nameFilter, err := parseNameFilter(config.NameFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nameFilter: %w", err)
+	}
+
+	cloneGroupPattern, err := parseCloneGroupPattern(config.CloneGroupPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cloneGroupPattern: %w", err)
+	}
+
+	excludeVMIDs, err := parseVMIDList(config.ExcludeVMIDs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid excludeVMIDs: %w", err)
+	}
+
+	maxServers := defaultMaxServersPerService
+	if config.MaxServersPerService != "" {
+		maxServers, _ = strconv.Atoi(strings.TrimSpace(config.MaxServersPerService))
+	}
+
+	// defaultHTTPEntrypoints takes precedence over the older defaultEntrypoints.
+	httpEntrypoints := config.DefaultEntrypoints
+	if config.DefaultHTTPEntrypoints != "" {
+		httpEntrypoints = config.DefaultHTTPEntrypoints
+	}
+
+	nodeEntrypoints, err := parseNodeEntrypoints(config.NodeEntrypoints)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nodeEntrypoints: %w", err)
+	}
+
+	serverURLTemplate, err := parseServerURLTemplate(config.ServerURLTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serverURLTemplate: %w", err)
+	}
+
+	staticIPs, err := parseStaticIPs(config.VMIDToIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vmidToIP: %w", err)
+	}
+
+	guestConcurrency := defaultGuestConcurrency
+	if n, err := strconv.Atoi(config.GuestConcurrency); err == nil {
+		guestConcurrency = n
+	}
+
+	var nodeRefresh time.Duration
+	if config.NodeRefreshInterval != "" {
+		nodeRefresh, err = time.ParseDuration(config.NodeRefreshInterval)
+		if err != nil || nodeRefresh < 0 {
+			return nil, fmt.Errorf("invalid nodeRefreshInterval %q: must be a non-negative duration", config.NodeRefreshInterval)
+		}
+	}
+
+	endpoints, err := parseClusterEndpoints(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	var probeTimeout time.Duration
+	if config.ProbeBackends == "true" {
+		probeTimeout, err = parseProbeTimeout(config.ProbeTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	debounce, err := parseDebounceWindow(config.DebounceWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := make([]cluster, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		var pc ParserConfig
+		if config.ApiUser != "" {
+			pc, err = newPasswordParserConfig(
+				endpoint.ApiEndpoint,
+				config.ApiUser,
+				config.ApiRealm,
+				config.ApiPassword,
+				config.ApiLogging,
+				endpoint.ValidateSSL,
+			)
+		} else {
+			pc, err = newParserConfig(
+				endpoint.ApiEndpoint,
+				endpoint.TokenId,
+				endpoint.Token,
+				config.ApiLogging,
+				endpoint.ValidateSSL,
+			)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid parser config: %w", err)
+		}
+		pc.ClientCert = config.ApiClientCert
+		pc.CAFile = config.ApiCAFile
+		pc.ClientKey = config.ApiClientKey
+		if config.ApiMaxResponseSize != "" {
+			pc.MaxResponseSize, _ = strconv.ParseInt(config.ApiMaxResponseSize, 10, 64)
+		}
+		if config.ApiHeader != "" {
+			pc.HeaderName, pc.HeaderValue, _ = parseAPIHeader(config.ApiHeader)
+		}
+		client, err := newClient(pc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API client configuration: %w", err)
+		}
+
+		// A cluster that is briefly unreachable while Traefik starts is
+		// checked again on the next polls, unless the check is required.
+		versionPending := false
+		if err := logVersion(client, ctx); err != nil {
+			if config.RequireVersionAtStartup == "true" {
+				return nil, fmt.Errorf("failed to get Proxmox version from %s: %w%s", pc.ApiEndpoint, err, credentialsHint(err))
+			}
+			log.Printf("WARN: Failed to get Proxmox version from %s, starting anyway and retrying on the next poll: %v%s", pc.ApiEndpoint, err, credentialsHint(err))
+			versionPending = true
+		}
+
+		scanOpts := scanOptions{
+			Pools:                    splitList(config.PoolFilter),
+			IncludeVMIDs:             includeVMIDs,
+			ExcludeVMIDs:             excludeVMIDs,
+			NameFilter:               nameFilter,
+			ActiveStatuses:           parseActiveStatuses(config.ActiveStatuses),
+			SkipAgentNotReady:        config.SkipAgentNotReady == "true",
+			LabelSource:              config.LabelSource,
+			LabelField:               labelFieldOrDefault(config.LabelField),
+			LabelPrefix:              normalizeLabelPrefix(config.LabelPrefix),
+			BackendInterface:         config.BackendInterface,
+			Debug:                    config.ApiLogging == internal.LogLevelDebug,
+			Nodes:                    &nodeCache{refreshInterval: nodeRefresh},
+			StaticIPs:                staticIPs,
+			AutoDetectPort:           config.AutoDetectPort == "true",
+			PortFromTags:             config.PortFromTags == "true",
+			ResolveFallbackHostnames: config.ResolveFallbackHostnames == "true",
+			UseResolvedAddresses:     config.UseResolvedAddresses == "true",
+			PublishDiagnostics:       config.PublishDiagnostics == "true",
+			GuestConcurrency:         guestConcurrency,
+		}
+		if !versionPending {
+			logSelfTest(client, ctx, scanOpts)
+		}
+
+		clusters = append(clusters, cluster{Name: endpoint.Name, client: client, scanOptions: scanOpts, versionPending: versionPending})
+	}
+
+	return &Provider{
+		name:           name,
+		pollInterval:   pi,
+		pollJitter:     jitter,
+		clusters:       clusters,
+		refreshAddress: config.RefreshListenAddress,
+		refresh:        make(chan struct{}, 1),
+		probeTimeout:   probeTimeout,
+		debounce:       debounce,
+		options: Options{
+			DefaultEntrypoints:      splitList(httpEntrypoints),
+			NodeEntrypoints:         nodeEntrypoints,
+			DefaultTCPEntrypoints:   splitList(config.DefaultTCPEntrypoints),
+			DefaultUDPEntrypoints:   splitList(config.DefaultUDPEntrypoints),
+			DefaultMiddlewares:      splitList(config.DefaultMiddlewares),
+			DefaultMiddlewaresLast:  strings.EqualFold(config.DefaultMiddlewaresOrder, middlewaresOrderLast),
+			DisableHostnameFallback: config.DisableHostnameFallback == "true",
+			InferScheme:             config.InferScheme == "true",
+			DefaultScheme:           strings.ToLower(config.DefaultScheme),
+			DefaultPort:             strings.TrimSpace(config.DefaultPort),
+			MaxServersPerService:    maxServers,
+			ProviderPrefix:          config.ProviderPrefix,
+			NameTemplate:            config.NameTemplate,
+			NormalizeNames:          config.NormalizeNames == "true",
+			CloneGroupPattern:       cloneGroupPattern,
+			ServerURLTemplate:       serverURLTemplate,
+		},
+	}, nil
+}
+
+// redactedSecret replaces secrets in logged configuration.
+const redactedSecret = "REDACTED"
+
+// redactedConfig returns a copy of the configuration that is safe to log,
+// with the API token and password replaced.
+func redactedConfig(config *Config) Config {
+	redacted := *config
+	if redacted.ApiToken != "" {
+		redacted.ApiToken = redactedSecret
+	}
+	if redacted.ApiPassword != "" {
+		redacted.ApiPassword = redactedSecret
+	}
+	return redacted
+}
+
+// minPollInterval is the shortest poll interval accepted without AllowFastPolling.
+const minPollInterval = 5 * time.Second
+
+// parsePollInterval parses the poll interval and enforces minPollInterval
+// unless fast polling is explicitly allowed.
+func parsePollInterval(value string, allowFastPolling bool) (time.Duration, error) {
+	pi, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid poll interval: %w", err)
+	}
+	if pi <= 0 {
+		return 0, fmt.Errorf("poll interval must be positive, got %v", pi)
+	}
+
+	// Ensure minimum poll interval
+	if pi < minPollInterval {
+		if !allowFastPolling {
+			return 0, fmt.Errorf("poll interval must be at least %v, got %v (set allowFastPolling to use shorter intervals)", minPollInterval, pi)
+		}
+		log.Printf("WARN: Poll interval %v is below %v, expect increased load on the Proxmox API", pi, minPollInterval)
+	}
+	return pi, nil
+}
+
+// maxPollJitter bounds the poll jitter so polls never run back to back.
+const maxPollJitter = 50
+
+// parseDebounceWindow parses the debounce window, zero disabling it.
+func parseDebounceWindow(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("debounce window must be a non-negative duration such as 10s, got %q", value)
+	}
+	return window, nil
+}
+
+// parseProbeTimeout parses the backend probe timeout, defaultProbeTimeout
+// when empty.
+func parseProbeTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultProbeTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid probeTimeout %q: must be a positive duration", value)
+	}
+	return timeout, nil
+}
+
+// parsePollJitter parses the poll jitter, a percentage of the poll interval
+// such as "10" or "10%", into a fraction.
+func parsePollJitter(value string) (float64, error) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "%")
+	if value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > maxPollJitter {
+		return 0, fmt.Errorf("poll jitter must be a percentage between 0 and %d, got %q", maxPollJitter, value)
+	}
+	return percent / 100, nil
+}
+
+// jitteredInterval spreads interval by up to ±jitter, with r drawn from [0, 1).
+func jitteredInterval(interval time.Duration, jitter float64, r float64) time.Duration {
+	return time.Duration(float64(interval) * (1 + jitter*(2*r-1)))
+}
+
+// Init the provider.
+func (p *Provider) Init() error {
+	return nil
+}
+
+// Provide creates and send dynamic configuration.
+func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	if p.refreshAddress != "" {
+		if err := p.startRefreshListener(ctx, p.refreshAddress); err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	p.done = done
+
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Recovered from panic in provider: %v", err)
+			}
+		}()
+
+		p.loadConfiguration(ctx, cfgChan)
+	}()
+
+	return nil
+}
+
+func (p *Provider) loadConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) {
+	// A timer rearmed after every poll lets each interval carry its own
+	// jitter, so replicas polling the same cluster drift apart.
+	poll := time.NewTimer(p.nextPollInterval())
+	defer poll.Stop()
+
+	// Until the first configuration is published, failed polls are retried
+	// sooner than the regular poll interval.
+	retryInterval := initialRetryInterval
+	if p.pollInterval < retryInterval {
+		retryInterval = p.pollInterval
+	}
+	retry := time.NewTimer(retryInterval)
+	defer retry.Stop()
+
+	// flush fires once the debounce window of a held back configuration is
+	// over. It is nil while nothing is held back.
+	var flush <-chan time.Time
+	armFlush := func() {
+		if p.pending != nil && flush == nil {
+			flush = time.After(p.debounce)
+		}
+	}
+
+	// guestRefresh fires when a guest with its own poll interval is due. It
+	// is nil while no guest has one.
+	var guestRefresh <-chan time.Time
+	armGuestRefresh := func() {
+		guestRefresh = nil
+		if wait, ok := nextGuestPoll(p.guestPolls, time.Now()); ok {
+			guestRefresh = time.After(wait)
+		}
+	}
+
+	// Initial configuration
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		log.Printf("Error during initial configuration, retrying in %v: %v", retryInterval, err)
+	} else {
+		retry.Stop()
+	}
+
+	for {
+		armFlush()
+		armGuestRefresh()
+		select {
+		case <-flush:
+			flush = nil
+			p.flushPending(ctx, cfgChan)
+		case now := <-guestRefresh:
+			if err := p.refreshGuests(ctx, cfgChan, now); err != nil {
+				log.Printf("Error refreshing guests: %v", err)
+			}
+		case <-retry.C:
+			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+				log.Printf("Error during initial configuration, retrying in %v: %v", retryInterval, err)
+				retry.Reset(retryInterval)
+			}
+		case <-poll.C:
+			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+				log.Printf("Error updating configuration: %v", err)
+			} else {
+				retry.Stop()
+			}
+			poll.Reset(p.nextPollInterval())
+		case <-p.refresh:
+			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+				log.Printf("Error updating configuration on request: %v", err)
+				continue
+			}
+			retry.Stop()
+			// The refresh counts as a poll, so the next one waits a full interval.
+			if !poll.Stop() {
+				<-poll.C
+			}
+			poll.Reset(p.nextPollInterval())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nextPollInterval returns the poll interval with jitter applied.
+func (p *Provider) nextPollInterval() time.Duration {
+	if p.pollJitter == 0 {
+		return p.pollInterval
+	}
+	return jitteredInterval(p.pollInterval, p.pollJitter, rand.Float64())
+}
+
+func (p *Provider) updateConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) error {
+	start := time.Now()
+
+	p.checkVersions(ctx)
+
+	scanned, err := scanClusterServices(ctx, p.clusters)
+	if err != nil {
+		err = fmt.Errorf("error getting service map: %w", err)
+		p.recordPoll(nil, err)
+		return err
+	}
+	servicesMap, configuration := buildClusters(p.clusters, scanned, p.options)
+	p.scanned = scanned
+	p.guestPolls = scheduleGuestPolls(scanned, p.pollInterval, time.Now())
+
+	if p.probeTimeout > 0 {
+		probeServers(ctx, configuration, p.probeTimeout)
+	}
+
+	log.Print(pollSummary(servicesMap, configuration, time.Since(start)))
+
+	if p.holdConfiguration(configuration) {
+		return nil
+	}
+	return p.publishConfiguration(ctx, cfgChan, configuration)
+}
+
+// publishConfiguration hands a configuration to Traefik and records the poll.
+func (p *Provider) publishConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler, configuration *dynamic.Configuration) error {
+	if err := sendConfiguration(ctx, cfgChan, configuration, publishSlowAfter, publishTimeout); err != nil {
+		p.recordPoll(nil, err)
+		return err
+	}
+	p.recordPoll(configuration, nil)
+
+	if p.debounce > 0 {
+		p.lastPublished, _ = json.Marshal(configuration)
+	}
+
+	if !p.published {
+		p.published = true
+		log.Printf("First configuration published (%d routers, %d services)", len(configuration.HTTP.Routers), len(configuration.HTTP.Services))
+	}
+	return nil
+}
+
+// holdConfiguration reports whether a configuration is held back by the
+// debounce instead of being published. A configuration that differs from the
+// published one is held for the debounce window, during which later polls
+// replace it, so a burst of changes such as a node being drained results in
+// a single reload. The first configuration is never held. Holding a
+// configuration clears the error of a previous poll, as the scan succeeded;
+// the poll time and route count keep describing the published configuration.
+func (p *Provider) holdConfiguration(configuration *dynamic.Configuration) bool {
+	if p.debounce <= 0 || !p.published {
+		return false
+	}
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		return false
+	}
+	if bytes.Equal(data, p.lastPublished) {
+		if p.pending != nil {
+			log.Printf("Configuration changed back within the debounce window, nothing to publish")
+			p.pending = nil
+		}
+		return false
+	}
+	if p.pending == nil {
+		log.Printf("Configuration changed, publishing the latest one in %v", p.debounce)
+	}
+	p.pending = configuration
+
+	p.mu.Lock()
+	p.lastError = nil
+	p.mu.Unlock()
+	return true
+}
+
+// flushPending publishes the configuration held back by the debounce.
+func (p *Provider) flushPending(ctx context.Context, cfgChan chan<- json.Marshaler) {
+	configuration := p.pending
+	if configuration == nil {
+		return
+	}
+	p.pending = nil
+	if err := p.publishConfiguration(ctx, cfgChan, configuration); err != nil {
+		log.Printf("Error publishing debounced configuration: %v", err)
+	}
+}
+
+// Bounds for handing a configuration to Traefik, see sendConfiguration.
+const (
+	publishSlowAfter = 5 * time.Second
+	publishTimeout   = time.Minute
+)
+
+// sendConfiguration hands the configuration to Traefik. A consumer that does
+// not receive it within slowAfter is logged, and after timeout the
+// configuration is dropped, so a stalled consumer cannot hang the poll loop.
+// The next poll sends a fresh configuration.
+func sendConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler, configuration *dynamic.Configuration, slowAfter, timeout time.Duration) error {
+	payload := &dynamic.JSONPayload{Configuration: configuration}
+	start := time.Now()
+
+	slow := time.NewTimer(slowAfter)
+	defer slow.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case cfgChan <- payload:
+			if elapsed := time.Since(start); elapsed >= slowAfter {
+				log.Printf("Configuration was accepted by Traefik after %v", elapsed.Round(time.Millisecond))
+			}
+			return nil
+		case <-slow.C:
+			log.Printf("WARN: Traefik has not accepted the configuration after %v, still waiting", slowAfter)
+		case <-deadline.C:
+			return fmt.Errorf("traefik did not accept the configuration within %v, dropping it", timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// recordPoll updates the poll status with the result of a poll.
+func (p *Provider) recordPoll(configuration *dynamic.Configuration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastError = err
+	if err != nil {
+		return
+	}
+	p.lastPollTime = time.Now()
+	p.routeCount = len(configuration.HTTP.Routers) + len(configuration.TCP.Routers) + len(configuration.UDP.Routers)
+}
+
+// LastPollTime returns when a configuration was last published, or the zero
+// time before the first successful poll.
+func (p *Provider) LastPollTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastPollTime
+}
+
+// LastError returns the error of the latest poll, or nil if it succeeded.
+func (p *Provider) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastError
+}
+
+// RouteCount returns the number of HTTP, TCP and UDP routers in the last
+// published configuration.
+func (p *Provider) RouteCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.routeCount
+}
+
+// pollSummary describes the outcome of a poll in a single line.
+func pollSummary(servicesMap map[string][]internal.Service, configuration *dynamic.Configuration, elapsed time.Duration) string {
+	guests := 0
+	for _, services := range servicesMap {
+		guests += len(services)
+	}
+	routers := len(configuration.HTTP.Routers) + len(configuration.TCP.Routers) + len(configuration.UDP.Routers)
+	services := len(configuration.HTTP.Services) + len(configuration.TCP.Services) + len(configuration.UDP.Services)
+	return fmt.Sprintf("Poll complete: %d nodes, %d guests, %d routers, %d services in %v",
+		len(servicesMap), guests, routers, services, elapsed.Round(time.Millisecond))
+}
+
+// stopTimeout bounds how long Stop waits for an in-flight poll to finish.
+const stopTimeout = 10 * time.Second
+
+// Stop to stop the provider and the related go routines. It waits for a
+// running poll to finish, so the next provider instance does not poll the
+// API at the same time.
+func (p *Provider) Stop() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if p.done == nil {
+		return nil
+	}
+
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(stopTimeout):
+		return fmt.Errorf("provider did not stop within %v", stopTimeout)
+	}
+}
+
+// ParserConfig represents the configuration for the Proxmox API client
+type ParserConfig struct {
+	ApiEndpoint string
+	TokenId     string
+	Token       string
+	LogLevel    string
+	ValidateSSL bool
+	ClientCert  string
+	ClientKey   string
+	// CAFile is a PEM bundle of the CAs trusted for the API certificate.
+	CAFile   string
+	User     string
+	Password string
+	// MaxResponseSize overrides internal.DefaultMaxResponseSize when set.
+	MaxResponseSize int64
+	// HeaderName and HeaderValue are an extra header sent with every request.
+	HeaderName  string
+	HeaderValue string
+}
+
+func newParserConfig(apiEndpoint, tokenID, token string, logLevel string, validateSSL bool) (ParserConfig, error) {
+	if apiEndpoint == "" || tokenID == "" || token == "" {
+		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, tokenID or token")
+	}
+	if err := validateTokenFormat(tokenID, token); err != nil {
+		return ParserConfig{}, err
+	}
+	apiEndpoint, err := normalizeEndpoint(apiEndpoint)
+	if err != nil {
+		return ParserConfig{}, err
+	}
+	return ParserConfig{
+		ApiEndpoint: apiEndpoint,
+		TokenId:     tokenID,
+		Token:       token,
+		LogLevel:    logLevel,
+		ValidateSSL: validateSSL,
+	}, nil
+}
+
+func newPasswordParserConfig(apiEndpoint, user, realm, password string, logLevel string, validateSSL bool) (ParserConfig, error) {
+	if apiEndpoint == "" || user == "" || password == "" {
+		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, user or password")
+	}
+	user, err := qualifyUser(user, realm)
+	if err != nil {
+		return ParserConfig{}, err
+	}
+	apiEndpoint, err = normalizeEndpoint(apiEndpoint)
+	if err != nil {
+		return ParserConfig{}, err
+	}
+	return ParserConfig{
+		ApiEndpoint: apiEndpoint,
+		User:        user,
+		Password:    password,
+		LogLevel:    logLevel,
+		ValidateSSL: validateSSL,
+	}, nil
+}
+
+// validateTokenFormat catches the usual mix-ups when copying an API token
+// from the Proxmox UI, which shows it as user@realm!tokenname=secret.
+func validateTokenFormat(tokenID, token string) error {
+	if strings.HasPrefix(strings.ToUpper(tokenID), "PVEAPITOKEN=") {
+		return errors.New("apiTokenId must not include the PVEAPIToken= prefix, use only user@realm!tokenname")
+	}
+	if id, secret, found := strings.Cut(tokenID, "="); found {
+		return fmt.Errorf("apiTokenId %q contains the token secret: set apiTokenId to %q and apiToken to the part after '='", maskSecret(tokenID, secret), id)
+	}
+	if strings.Contains(token, "!") {
+		return errors.New("apiToken looks like a token ID: set apiTokenId to user@realm!tokenname and apiToken to the secret only")
+	}
+	if !strings.Contains(tokenID, "!") || !strings.Contains(tokenID, "@") {
+		return fmt.Errorf("apiTokenId %q must have the form user@realm!tokenname, e.g. root@pam!traefik", tokenID)
+	}
+	return nil
+}
+
+// maskSecret hides secret inside s, so it does not end up in logs.
+func maskSecret(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.Replace(s, secret, "***", 1)
+}
+
+// qualifyUser returns the user as user@realm, taking the realm from the user
+// name itself or from the separate realm setting.
+func qualifyUser(user, realm string) (string, error) {
+	name, userRealm, hasRealm := strings.Cut(user, "@")
+	switch {
+	case hasRealm && userRealm == "":
+		return "", fmt.Errorf("API user %q has an empty realm", user)
+	case hasRealm && realm != "" && !strings.EqualFold(userRealm, realm):
+		return "", fmt.Errorf("API user %q does not match realm %q", user, realm)
+	case hasRealm:
+		return user, nil
+	case realm != "":
+		return name + "@" + realm, nil
+	default:
+		return "", fmt.Errorf("API user %q has no realm, use user@realm (e.g. %s@pam) or set apiRealm", user, user)
+	}
+}
+
+// normalizeEndpoint validates the API endpoint URL, defaulting to https when no
+// scheme is given and dropping any trailing slash.
+func normalizeEndpoint(apiEndpoint string) (string, error) {
+	endpoint := strings.TrimSpace(apiEndpoint)
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+		log.Printf("API endpoint %q has no scheme, using %s", apiEndpoint, endpoint)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid API endpoint %q: %w", apiEndpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid API endpoint %q: scheme must be http or https", apiEndpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid API endpoint %q: missing host", apiEndpoint)
+	}
+
+	return strings.TrimRight(endpoint, "/"), nil
+}
+
+func newClient(pc ParserConfig) (*internal.ProxmoxClient, error) {
+	client := internal.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
+	if pc.User != "" {
+		client.SetPasswordAuth(pc.User, pc.Password)
+	}
+	if pc.MaxResponseSize > 0 {
+		client.MaxResponseSize = pc.MaxResponseSize
+	}
+	if pc.HeaderName != "" {
+		client.Headers.Set(pc.HeaderName, pc.HeaderValue)
+	}
+	if pc.ClientCert != "" {
+		if err := client.SetClientCertificate(pc.ClientCert, pc.ClientKey); err != nil {
+			return nil, err
+		}
+	}
+	if pc.CAFile != "" {
+		if err := client.SetRootCAs(pc.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// parseAPIHeader parses the apiHeader setting, given as "Name: value". The
+// authentication headers cannot be overridden.
+func parseAPIHeader(value string) (string, string, error) {
+	name, headerValue, found := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsAny(name, " \t\"=") {
+		return "", "", fmt.Errorf("API header must have the form \"Name: value\", got %q", value)
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Cookie", "Csrfpreventiontoken":
+		return "", "", fmt.Errorf("API header %s is set by the provider and cannot be overridden", name)
+	}
+	return name, strings.TrimSpace(headerValue), nil
+}
+
+// credentialsHint suggests what to check for authentication errors.
+func credentialsHint(err error) string {
+	switch {
+	case errors.Is(err, internal.ErrUnauthorized):
+		return " (check the API token or user credentials)"
+	case errors.Is(err, internal.ErrForbidden):
+		return " (check the permissions of the API token)"
+	}
+	return ""
+}
+
+// checkVersions retries the version check of clusters that could not be
+// reached at startup, and runs their self-test once they answer.
+func (p *Provider) checkVersions(ctx context.Context) {
+	for i := range p.clusters {
+		c := &p.clusters[i]
+		if !c.versionPending {
+			continue
+		}
+		if err := logVersion(c.client, ctx); err != nil {
+			if c.Name != "" {
+				log.Printf("WARN: Proxmox version of cluster %s is still unavailable: %v%s", c.Name, err, credentialsHint(err))
+			} else {
+				log.Printf("WARN: Proxmox version is still unavailable: %v%s", err, credentialsHint(err))
+			}
+			continue
+		}
+		c.versionPending = false
+		logSelfTest(c.client, ctx, c.scanOptions)
+	}
+}
+
+func logVersion(client ProxmoxAPI, ctx context.Context) error {
+	version, err := client.GetVersion(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid poll interval: %w", err)
+		return err
+	}
+	log.Printf("Connected to Proxmox VE version %s", version.Release)
+	return nil
+}
+
+// logSelfTest walks the cluster once at startup and logs what the provider can
+// see, so misconfigurations such as unreachable nodes or guests without
+// traefik.enable=true show up before the first poll.
+func logSelfTest(client ProxmoxAPI, ctx context.Context, opts scanOptions) {
+	nodes, err := client.GetNodes(ctx)
+	if err != nil {
+		log.Printf("WARN: Self-test could not list nodes: %v", err)
+		return
+	}
+
+	unreachable, guests, running, enabled := 0, 0, 0, 0
+	for _, node := range nodes {
+		vms, err := client.GetVirtualMachines(ctx, node.Node)
+		if err != nil {
+			log.Printf("WARN: Self-test could not list VMs on node %s: %v", node.Node, err)
+			unreachable++
+			continue
+		}
+		cts, err := client.GetContainers(ctx, node.Node)
+		if err != nil {
+			log.Printf("WARN: Self-test could not list containers on node %s: %v", node.Node, err)
+			unreachable++
+			continue
+		}
+
+		for _, vm := range vms {
+			if !opts.includeGuest(vm.VMID) || !opts.includeName(vm.Name) {
+				continue
+			}
+			guests++
+			if !opts.isActive(vm.Status) {
+				continue
+			}
+			running++
+			if config, err := client.GetVMConfig(ctx, node.Node, vm.VMID); err == nil && isBoolLabelEnabled(getLabels(config, opts), "traefik.enable") {
+				enabled++
+			}
+		}
+		for _, ct := range cts {
+			if !opts.includeGuest(ct.VMID) || !opts.includeName(ct.Name) {
+				continue
+			}
+			guests++
+			if !opts.isActive(ct.Status) {
+				continue
+			}
+			running++
+			if config, err := client.GetContainerConfig(ctx, node.Node, ct.VMID); err == nil && isBoolLabelEnabled(getLabels(config, opts), "traefik.enable") {
+				enabled++
+			}
+		}
+	}
+
+	log.Printf("Self-test: %d nodes (%d unreachable), %d guests (%d running), %d running with traefik.enable=true",
+		len(nodes), unreachable, guests, running, enabled)
+	if enabled == 0 {
+		log.Printf("WARN: No running guest has %senable=true in its %s, no routes will be created", labelPrefixOrDefault(opts.LabelPrefix), labelSourceName(opts))
+	}
+}
+
+// labelSourceName describes where labels are read from, for log messages.
+func labelSourceName(opts scanOptions) string {
+	switch opts.LabelSource {
+	case labelSourceBlock:
+		return "notes label block"
+	case labelSourceField:
+		return fmt.Sprintf("%s configuration field", labelFieldOrDefault(opts.LabelField))
+	}
+	return "notes"
+}
+
+func getServiceMap(client ProxmoxAPI, ctx context.Context, opts scanOptions) (map[string][]internal.Service, error) {
+	servicesMap := make(map[string][]internal.Service)
+
+	nodes, err := opts.Nodes.get(client, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning nodes: %w", err)
+	}
+
+	if len(opts.Pools) > 0 {
+		opts.PoolMembers, err = getPoolMembers(client, ctx, opts.Pools)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning pools: %w", err)
+		}
+	}
+
+	for _, nodeStatus := range nodes {
+		if !isNodeOnline(nodeStatus) {
+			if opts.Nodes.markOffline(nodeStatus.Node) {
+				log.Printf("Node %s is %s, skipping it until it is back online", nodeStatus.Node, nodeStatus.Status)
+			}
+			continue
+		}
+		if opts.Nodes.markOnline(nodeStatus.Node) {
+			log.Printf("Node %s is back online", nodeStatus.Node)
+		}
+
+		services, err := scanServices(client, ctx, nodeStatus.Node, opts)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("scan aborted: %w", ctxErr)
+		}
+		if err != nil {
+			log.Printf("Error scanning services on node %s: %v", nodeStatus.Node, err)
+			// The node may have left the cluster, so fetch the list again next poll.
+			opts.Nodes.invalidate()
+			continue
+		}
+		servicesMap[nodeStatus.Node] = services
+	}
+	return servicesMap, nil
+}
+
+// nodeCache keeps the cluster node list between polls. Nodes rarely change,
+// so they are only fetched again after refreshInterval or after a node failed
+// to scan. A nil cache or a zero interval fetches the nodes on every poll.
+// The list is not kept while a node is offline, so its return is noticed on
+// the next poll.
+type nodeCache struct {
+	refreshInterval time.Duration
+	nodes           []internal.NodeStatus
+	expires         time.Time
+	// offline holds the nodes skipped as not online, to log each change once.
+	offline map[string]bool
+}
+
+func (c *nodeCache) get(client ProxmoxAPI, ctx context.Context) ([]internal.NodeStatus, error) {
+	if c != nil && c.nodes != nil && time.Now().Before(c.expires) {
+		return c.nodes, nil
+	}
+	nodes, err := client.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil && c.refreshInterval > 0 && allNodesOnline(nodes) {
+		c.nodes = nodes
+		c.expires = time.Now().Add(c.refreshInterval)
+	}
+	return nodes, nil
+}
+
+func (c *nodeCache) invalidate() {
+	if c != nil {
+		c.nodes = nil
+	}
+}
+
+// markOffline records a node as offline and reports whether it was online
+// before. Without a cache every call reports a change.
+func (c *nodeCache) markOffline(node string) bool {
+	if c == nil {
+		return true
+	}
+	if c.offline[node] {
+		return false
+	}
+	if c.offline == nil {
+		c.offline = make(map[string]bool)
+	}
+	c.offline[node] = true
+	return true
+}
+
+// markOnline records a node as online and reports whether it was offline.
+func (c *nodeCache) markOnline(node string) bool {
+	if c == nil || !c.offline[node] {
+		return false
+	}
+	delete(c.offline, node)
+	return true
+}
+
+// isNodeOnline reports whether a node can be scanned. Nodes without a status
+// are assumed to be online.
+func isNodeOnline(node internal.NodeStatus) bool {
+	return node.Status == "" || node.Status == "online"
+}
+
+func allNodesOnline(nodes []internal.NodeStatus) bool {
+	for _, node := range nodes {
+		if !isNodeOnline(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// getPoolMembers returns the VMIDs of all guests in the given resource pools.
+func getPoolMembers(client ProxmoxAPI, ctx context.Context, pools []string) (map[uint64]bool, error) {
+	members := make(map[uint64]bool)
+	for _, poolID := range pools {
+		pool, err := client.GetPool(ctx, poolID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pool %s: %w", poolID, err)
+		}
+		for _, member := range pool.Members {
+			if member.Type == "qemu" || member.Type == "lxc" {
+				members[member.VMID] = true
+			}
+		}
 	}
+	return members, nil
+}
 
-	// Ensure minimum poll interval
-	if pi < 5*time.Second {
-		return nil, fmt.Errorf("poll interval must be at least 5 seconds, got %v", pi)
+// includeGuest reports whether a guest passes the configured scan filters.
+func (opts scanOptions) includeGuest(vmID uint64) bool {
+	if opts.PoolMembers != nil && !opts.PoolMembers[vmID] {
+		return false
 	}
-
-	pc, err := newParserConfig(
-		config.ApiEndpoint,
-		config.ApiTokenId,
-		config.ApiToken,
-		config.ApiLogging,
-		config.ApiValidateSSL == "true",
-	)
-	if err != nil {
-		return nil, fmt.Errorf("invalid parser config: %w", err)
+	if len(opts.IncludeVMIDs) > 0 && !vmidInRanges(vmID, opts.IncludeVMIDs) {
+		return false
 	}
-	client := newClient(pc)
-
-	if err := logVersion(client, ctx); err != nil {
-		return nil, fmt.Errorf("failed to get Proxmox version: %w", err)
+	if vmidInRanges(vmID, opts.ExcludeVMIDs) {
+		return false
 	}
-
-	return &Provider{
-		name:         name,
-		pollInterval: pi,
-		client:       client,
-	}, nil
+	return true
 }
 
-// Init the provider.
-func (p *Provider) Init() error {
-	return nil
+// includeName reports whether a guest name passes the name filter.
+func (opts scanOptions) includeName(name string) bool {
+	return opts.NameFilter == nil || opts.NameFilter.MatchString(name)
 }
 
-// Provide creates and send dynamic configuration.
-func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	p.cancel = cancel
-
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("Recovered from panic in provider: %v", err)
-			}
-		}()
-
-		p.loadConfiguration(ctx, cfgChan)
-	}()
+// defaultActiveStatus is the guest status that produces routes unless
+// ActiveStatuses says otherwise.
+const defaultActiveStatus = "running"
 
-	return nil
+// isActive reports whether guests with status produce routes.
+func (opts scanOptions) isActive(status string) bool {
+	if len(opts.ActiveStatuses) == 0 {
+		return status == defaultActiveStatus
+	}
+	for _, active := range opts.ActiveStatuses {
+		if strings.EqualFold(status, active) {
+			return true
+		}
+	}
+	return false
 }
 
-func (p *Provider) loadConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) {
-	ticker := time.NewTicker(p.pollInterval)
-	defer ticker.Stop()
-
-	// Initial configuration
-	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
-		log.Printf("Error during initial configuration: %v", err)
+// parseActiveStatuses parses the comma-separated active statuses. An empty
+// list returns nil, which keeps the default.
+func parseActiveStatuses(s string) []string {
+	var statuses []string
+	for _, status := range splitList(s) {
+		statuses = append(statuses, strings.ToLower(status))
 	}
+	return statuses
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
-				log.Printf("Error updating configuration: %v", err)
-			}
-		case <-ctx.Done():
-			return
-		}
+// parseNameFilter compiles the guest name filter. An empty filter returns nil.
+func parseNameFilter(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
 	}
+	return regexp.Compile(s)
 }
 
-func (p *Provider) updateConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) error {
-	servicesMap, err := getServiceMap(p.client, ctx)
+// parseCloneGroupPattern compiles the clone group pattern, which must have
+// exactly one capture group naming the group. An empty pattern returns nil.
+func parseCloneGroupPattern(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(s)
 	if err != nil {
-		return fmt.Errorf("error getting service map: %w", err)
+		return nil, err
+	}
+	if re.NumSubexp() != 1 {
+		return nil, fmt.Errorf("%q must have exactly one capture group, found %d", s, re.NumSubexp())
 	}
+	return re, nil
+}
 
-	configuration := generateConfiguration(servicesMap)
-	cfgChan <- &dynamic.JSONPayload{Configuration: configuration}
-	return nil
+// parseVMIDList parses a comma-separated list of VMIDs and ranges like 100-199.
+func parseVMIDList(s string) ([]vmidRange, error) {
+	ranges := make([]vmidRange, 0)
+	for _, item := range splitList(s) {
+		from, to, isRange := strings.Cut(item, "-")
+		start, err := strconv.ParseUint(strings.TrimSpace(from), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMID %q", item)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.ParseUint(strings.TrimSpace(to), 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid VMID range %q", item)
+			}
+		}
+		ranges = append(ranges, vmidRange{From: start, To: end})
+	}
+	return ranges, nil
 }
 
-// Stop to stop the provider and the related go routines.
-func (p *Provider) Stop() error {
-	if p.cancel != nil {
-		p.cancel()
+func vmidInRanges(vmID uint64, ranges []vmidRange) bool {
+	for _, r := range ranges {
+		if vmID >= r.From && vmID <= r.To {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
-// ParserConfig represents the configuration for the Proxmox API client
-type ParserConfig struct {
-	ApiEndpoint string
-	TokenId     string
-	Token       string
-	LogLevel    string
-	ValidateSSL bool
+// Supported label sources
+const (
+	labelSourceDescription = "description"
+	labelSourceBlock       = "block"
+	labelSourceField       = "field"
+)
+
+// defaultLabelField is read by the field label source when labelField is
+// unset. Its serial and other properties can be set through cloud-init or
+// qm set --smbios1 when a guest is created.
+const defaultLabelField = "smbios1"
+
+// getLabels reads the traefik labels of a guest from the configured source.
+// Labels with a custom prefix are returned with the traefik. prefix.
+func getLabels(config *internal.ParsedConfig, opts scanOptions) map[string]string {
+	prefix := labelPrefixOrDefault(opts.LabelPrefix)
+	switch opts.LabelSource {
+	case labelSourceBlock:
+		return config.GetLabelBlockMap(prefix)
+	case labelSourceField:
+		return config.GetFieldLabelMap(labelFieldOrDefault(opts.LabelField), prefix)
+	}
+	return config.GetLabelMap(prefix)
 }
 
-func newParserConfig(apiEndpoint, tokenID, token string, logLevel string, validateSSL bool) (ParserConfig, error) {
-	if apiEndpoint == "" || tokenID == "" || token == "" {
-		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, tokenID or token")
+func labelFieldOrDefault(field string) string {
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field == "" {
+		return defaultLabelField
 	}
-	return ParserConfig{
-		ApiEndpoint: apiEndpoint,
-		TokenId:     tokenID,
-		Token:       token,
-		LogLevel:    logLevel,
-		ValidateSSL: validateSSL,
-	}, nil
+	return field
 }
 
-func newClient(pc ParserConfig) *internal.ProxmoxClient {
-	return internal.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
+// normalizeLabelPrefix lowercases a label prefix and adds the trailing dot,
+// so both pxtraefik and pxtraefik. read pxtraefik.enable.
+func normalizeLabelPrefix(prefix string) string {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return prefix
 }
 
-func logVersion(client *internal.ProxmoxClient, ctx context.Context) error {
-	version, err := client.GetVersion(ctx)
-	if err != nil {
-		return err
+func labelPrefixOrDefault(prefix string) string {
+	if prefix == "" {
+		return internal.DefaultLabelPrefix
 	}
-	log.Printf("Connected to Proxmox VE version %s", version.Release)
-	return nil
+	return prefix
 }
 
-func getServiceMap(client *internal.ProxmoxClient, ctx context.Context) (map[string][]internal.Service, error) {
-	servicesMap := make(map[string][]internal.Service)
+// errAgentNotReady is returned when a running VM's guest agent does not answer yet.
+var errAgentNotReady = errors.New("guest agent not ready")
 
-	nodes, err := client.GetNodes(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning nodes: %w", err)
-	}
+// isAgentNotReady reports whether the API error comes from a guest agent that
+// is configured but not (yet) running inside the VM.
+func isAgentNotReady(err error) bool {
+	return errors.Is(err, internal.ErrAgentUnavailable)
+}
 
-	for _, nodeStatus := range nodes {
-		services, err := scanServices(client, ctx, nodeStatus.Node)
+// parseStaticIPs parses a comma-separated list of vmid=ip pairs. A VMID may
+// be listed several times to give a guest more than one address.
+func parseStaticIPs(s string) (map[uint64][]internal.IP, error) {
+	staticIPs := make(map[uint64][]internal.IP)
+	for _, entry := range splitList(s) {
+		id, address, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("entry %q must have the form vmid=ip", entry)
+		}
+		vmID, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
 		if err != nil {
-			log.Printf("Error scanning services on node %s: %v", nodeStatus.Node, err)
-			continue
+			return nil, fmt.Errorf("invalid VMID %q", id)
 		}
-		servicesMap[nodeStatus.Node] = services
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q for VMID %d", address, vmID)
+		}
+		addressType := "ipv4"
+		if ip.To4() == nil {
+			addressType = "ipv6"
+		}
+		staticIPs[vmID] = append(staticIPs[vmID], internal.IP{Address: ip.String(), AddressType: addressType})
 	}
-	return servicesMap, nil
+	return staticIPs, nil
+}
+
+// getIPsOfService returns the addresses reported by the guest agent. Guests
+// without a usable agent answer fall back to their configured static address.
+func getIPsOfService(client ProxmoxAPI, ctx context.Context, nodeName string, vmID uint64, isContainer bool, opts scanOptions) ([]internal.IP, error) {
+	ips, err := getAgentIPs(client, ctx, nodeName, vmID, isContainer, opts.Debug)
+	if static, exists := opts.StaticIPs[vmID]; exists && (err != nil || len(ips) == 0) {
+		if opts.Debug {
+			log.Printf("DEBUG: Using static address %v for %s/%d", static, nodeName, vmID)
+		}
+		return static, nil
+	}
+	return ips, err
 }
 
-func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64, isContainer bool) (ips []internal.IP, err error) {
+func getAgentIPs(client ProxmoxAPI, ctx context.Context, nodeName string, vmID uint64, isContainer bool, debug bool) (ips []internal.IP, err error) {
 	var agentInterfaces *internal.ParsedAgentInterfaces
 	if isContainer {
 		agentInterfaces, err = client.GetContainerNetworkInterfaces(ctx, nodeName, vmID)
@@ -213,6 +1519,10 @@ func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeNa
 	} else {
 		agentInterfaces, err = client.GetVMNetworkInterfaces(ctx, nodeName, vmID)
 		if err != nil {
+			if isAgentNotReady(err) {
+				log.Printf("Guest agent for VM %s/%d is not running yet", nodeName, vmID)
+				return nil, fmt.Errorf("%w: %v", errAgentNotReady, err)
+			}
 			log.Printf("ERROR: Error getting VM network interfaces for %s/%d: %v", nodeName, vmID, err)
 			return nil, fmt.Errorf("error getting VM network interfaces: %w", err)
 		}
@@ -222,19 +1532,238 @@ func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeNa
 
 	filteredIPs := make([]internal.IP, 0)
 	for _, ip := range rawIPs {
-		if (ip.AddressType == "ipv4" || ip.AddressType == "inet") && ip.Address != "127.0.0.1" {
+		if isUsableAgentIP(ip) {
 			filteredIPs = append(filteredIPs, ip)
 		}
 	}
 
-	if len(filteredIPs) == 0 && client.LogLevel == internal.LogLevelDebug {
+	if len(filteredIPs) == 0 && debug {
 		log.Printf("ERROR: No valid IPs found for %s/%d (isContainer: %t). Raw IPs were: %+v", nodeName, vmID, isContainer, rawIPs)
 	}
 
+	sortIPs(filteredIPs)
 	return filteredIPs, nil
 }
 
-func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName string) (services []internal.Service, err error) {
+// isUsableAgentIP reports whether an address from the guest agent can back a
+// service. Loopback addresses are dropped; IPv6 link-local addresses are kept
+// here and only used when selected with backendInterface.
+func isUsableAgentIP(ip internal.IP) bool {
+	switch ip.AddressType {
+	case "ipv4", "inet", "ipv6", "inet6":
+	default:
+		return false
+	}
+	addr := parseIPAddress(ip.Address)
+	return addr != nil && !addr.IsLoopback()
+}
+
+// parseIPAddress parses an address that may carry a %zone suffix, as IPv6
+// link-local addresses reported by the guest agent do.
+func parseIPAddress(address string) net.IP {
+	address, _, _ = strings.Cut(address, "%")
+	return net.ParseIP(address)
+}
+
+// isLinkLocalIPv6 reports whether ip is an IPv6 link-local address, which is
+// only reachable through the interface named by its zone.
+func isLinkLocalIPv6(ip internal.IP) bool {
+	addr := parseIPAddress(ip.Address)
+	return addr != nil && addr.To4() == nil && addr.IsLinkLocalUnicast()
+}
+
+// sortIPs orders IPv4 before IPv6 addresses, then by interface name and then
+// numerically by address, so the first address, which backs the service, does
+// not change between polls just because the guest agent listed the interfaces
+// differently.
+func sortIPs(ips []internal.IP) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		a, b := parseIPAddress(ips[i].Address), parseIPAddress(ips[j].Address)
+		if a4, b4 := a != nil && a.To4() != nil, b != nil && b.To4() != nil; a4 != b4 {
+			return a4
+		}
+		if ips[i].Interface != ips[j].Interface {
+			return ips[i].Interface < ips[j].Interface
+		}
+		if a == nil || b == nil {
+			return ips[i].Address < ips[j].Address
+		}
+		return bytes.Compare(a.To16(), b.To16()) < 0
+	})
+}
+
+// selectBackendIPs narrows the discovered addresses to those on the backend
+// interface, given as an interface name like eth1 or a subnet like
+// 10.0.1.0/24. When nothing matches, all addresses are kept except IPv6
+// link-local ones, which are only used when selected.
+func selectBackendIPs(ips []internal.IP, backendInterface string) []internal.IP {
+	if backendInterface == "" {
+		return withoutLinkLocalIPv6(ips)
+	}
+
+	_, subnet, _ := net.ParseCIDR(backendInterface)
+
+	selected := make([]internal.IP, 0, len(ips))
+	for _, ip := range ips {
+		if subnet != nil {
+			if addr := parseIPAddress(ip.Address); addr != nil && subnet.Contains(addr) {
+				selected = append(selected, ip)
+			}
+		} else if ip.Interface == backendInterface {
+			selected = append(selected, ip)
+		}
+	}
+
+	if len(selected) == 0 {
+		return withoutLinkLocalIPv6(ips)
+	}
+	return selected
+}
+
+// withoutLinkLocalIPv6 drops IPv6 link-local addresses.
+func withoutLinkLocalIPv6(ips []internal.IP) []internal.IP {
+	kept := make([]internal.IP, 0, len(ips))
+	for _, ip := range ips {
+		if !isLinkLocalIPv6(ip) {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
+
+// nonHTTPPorts are well-known ports of services that do not speak HTTP and are
+// ignored when detecting the port of a guest.
+var nonHTTPPorts = map[int]bool{
+	22: true, 25: true, 53: true, 111: true, 123: true, 139: true, 445: true,
+	631: true, 3306: true, 5355: true, 5432: true, 6379: true, 11211: true, 27017: true,
+}
+
+// needsDetectedPort reports whether an enabled guest leaves the port of all
+// its HTTP services to the provider.
+func needsDetectedPort(labels map[string]string) bool {
+	if !isBoolLabelEnabled(labels, "traefik.enable") {
+		return false
+	}
+	if _, exists := labels[guestPortLabel]; exists {
+		return false
+	}
+	for key := range labels {
+		if !strings.HasPrefix(key, "traefik.http.services.") {
+			continue
+		}
+		if strings.Contains(key, ".loadbalancer.server.port") || strings.HasSuffix(key, ".loadbalancer.server.url") {
+			return false
+		}
+	}
+	return true
+}
+
+// tagPort returns the port of the first port-<n> tag, e.g. 8080 for the tags
+// "web;port-8080", and "" when there is none.
+func tagPort(tags string) string {
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		tag = strings.ToLower(tag)
+		if !strings.HasPrefix(tag, "port-") {
+			continue
+		}
+		value := strings.TrimPrefix(tag, "port-")
+		if port, err := strconv.Atoi(value); err == nil && port >= 1 && port <= 65535 {
+			return value
+		}
+	}
+	return ""
+}
+
+// guestPortLabel sets the port of every HTTP service of a guest that has no
+// loadbalancer.server.port label, so simple guests need only two labels.
+const guestPortLabel = "traefik.port"
+
+// guestPort returns the port of the traefik.port label, and "" when it is
+// missing or not a valid port.
+func guestPort(service internal.Service) string {
+	value, exists := service.Config[guestPortLabel]
+	if !exists {
+		return ""
+	}
+	value = strings.TrimSpace(value)
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		log.Printf("WARN: Invalid %s %q on %s (ID: %d), expected a number between 1 and 65535", guestPortLabel, value, service.Name, service.ID)
+		return ""
+	}
+	return value
+}
+
+// defaultPortDetectTimeout matches the timeout of a single API request, so a
+// command that never exits in the guest cannot hold a scan slot forever.
+const defaultPortDetectTimeout = 30 * time.Second
+
+// detectPort returns the port a VM listens on when exactly one port that is
+// not a well-known non-HTTP port is open, and "" otherwise, including when
+// the guest agent does not answer within timeout.
+func detectPort(client ProxmoxAPI, ctx context.Context, nodeName string, vmID uint64, timeout time.Duration, debug bool) string {
+	if timeout <= 0 {
+		timeout = defaultPortDetectTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ports, err := client.GetVMListeningPorts(ctx, nodeName, vmID)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("WARN: Detecting the listening ports of VM %s/%d timed out after %s, no port detected", nodeName, vmID, timeout)
+		return ""
+	}
+	if err != nil {
+		log.Printf("WARN: Could not detect the listening ports of VM %s/%d: %v", nodeName, vmID, err)
+		return ""
+	}
+
+	candidates := make([]int, 0, len(ports))
+	for _, port := range ports {
+		if !nonHTTPPorts[port] {
+			candidates = append(candidates, port)
+		}
+	}
+	if len(candidates) != 1 {
+		if debug {
+			log.Printf("DEBUG: VM %s/%d listens on %v, no single port to use", nodeName, vmID, candidates)
+		}
+		return ""
+	}
+	if debug {
+		log.Printf("DEBUG: Detected port %d for VM %s/%d", candidates[0], nodeName, vmID)
+	}
+	return strconv.Itoa(candidates[0])
+}
+
+// describeAgentResult summarizes an IP lookup for diagnostics.
+func describeAgentResult(ips []internal.IP, err error) string {
+	if err != nil {
+		return fmt.Sprintf("interface lookup failed: %v", err)
+	}
+	if len(ips) == 0 {
+		return "no usable address reported"
+	}
+	return fmt.Sprintf("%d usable address(es) reported", len(ips))
+}
+
+// defaultGuestConcurrency fetches a few guests of a node at once without
+// loading small nodes.
+const defaultGuestConcurrency = 4
+
+// maxGuestConcurrency keeps parallel guest fetches within what a Proxmox node
+// comfortably serves.
+const maxGuestConcurrency = 32
+
+// guestRef identifies a running guest found while listing a node.
+type guestRef struct {
+	VMID        uint64
+	Name        string
+	IsContainer bool
+}
+
+func scanServices(client ProxmoxAPI, ctx context.Context, nodeName string, opts scanOptions) (services []internal.Service, err error) {
+	guests := make([]guestRef, 0)
+
 	// Scan virtual machines
 	vms, err := client.GetVirtualMachines(ctx, nodeName)
 	if err != nil {
@@ -242,30 +1771,11 @@ func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName
 	}
 
 	for _, vm := range vms {
-		if client.LogLevel == "debug" {
+		if opts.Debug {
 			log.Printf("DEBUG: Scanning VM %s/%s (%d): %s", nodeName, vm.Name, vm.VMID, vm.Status)
 		}
-		
-		if vm.Status == "running" {
-			config, err := client.GetVMConfig(ctx, nodeName, vm.VMID)
-			if err != nil {
-				log.Printf("ERROR: Error getting VM config for %d: %v", vm.VMID, err)
-				continue
-			}
-			
-			traefikConfig := config.GetTraefikMap()
-			if client.LogLevel == "debug" {
-				log.Printf("VM %s (%d) traefik config: %v", vm.Name, vm.VMID, traefikConfig)
-			}
-			
-			service := internal.NewService(vm.VMID, vm.Name, traefikConfig)
-			
-			ips, err := getIPsOfService(client, ctx, nodeName, vm.VMID, false)
-			if err == nil {
-				service.IPs = ips
-			}
-
-			services = append(services, service)
+		if opts.includeGuest(vm.VMID) && opts.includeName(vm.Name) && opts.isActive(vm.Status) {
+			guests = append(guests, guestRef{VMID: vm.VMID, Name: vm.Name})
 		}
 	}
 
@@ -276,39 +1786,111 @@ func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName
 	}
 
 	for _, ct := range cts {
-		if client.LogLevel == "debug" {
+		if opts.Debug {
 			log.Printf("DEBUG: Scanning container %s/%s (%d): %s", nodeName, ct.Name, ct.VMID, ct.Status)
 		}
-			
+		if opts.includeGuest(ct.VMID) && opts.includeName(ct.Name) && opts.isActive(ct.Status) {
+			guests = append(guests, guestRef{VMID: ct.VMID, Name: ct.Name, IsContainer: true})
+		}
+	}
 
-		if ct.Status == "running" {
-			config, err := client.GetContainerConfig(ctx, nodeName, ct.VMID)
-			if err != nil {
-				log.Printf("ERROR: Error getting container config for %d: %v", ct.VMID, err)
-				continue
-			}
+	// Fetch the guests with a bounded number of requests in flight. Results
+	// keep the listing order, so the generated configuration is stable.
+	concurrency := opts.GuestConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]*internal.Service, len(guests))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, guest := range guests {
+		if ctx.Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, guest guestRef) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = scanGuest(client, ctx, nodeName, guest, opts)
+		}(i, guest)
+	}
+	wg.Wait()
 
-			traefikConfig := config.GetTraefikMap()
-			if client.LogLevel == "debug" {
-				log.Printf("DEBUG: Container %s (%d) traefik config: %v", ct.Name, ct.VMID, traefikConfig)
-			}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("scan of node %s aborted: %w", nodeName, err)
+	}
 
-			service := internal.NewService(ct.VMID, ct.Name, traefikConfig)
+	for _, service := range results {
+		if service != nil {
+			services = append(services, *service)
+		}
+	}
+	return services, nil
+}
 
-			// Try to get container IPs if possible
-			ips, err := getIPsOfService(client, ctx, nodeName, ct.VMID, true)
-			if err == nil {
-				service.IPs = ips
-			}
+// scanGuest reads the labels and addresses of a running guest. It returns
+// nil for guests that are left out of this poll.
+func scanGuest(client ProxmoxAPI, ctx context.Context, nodeName string, guest guestRef, opts scanOptions) *internal.Service {
+	kind, guestType, getConfig := "VM", internal.GuestTypeVM, client.GetVMConfig
+	if guest.IsContainer {
+		kind, guestType, getConfig = "container", internal.GuestTypeContainer, client.GetContainerConfig
+	}
 
-			services = append(services, service)
+	config, err := getConfig(ctx, nodeName, guest.VMID)
+	if err != nil {
+		log.Printf("ERROR: Error getting %s config for %d: %v", kind, guest.VMID, err)
+		if opts.PublishDiagnostics {
+			service := internal.NewService(guest.VMID, guest.Name, map[string]string{})
+			service.Type = guestType
+			service.ScanError = fmt.Sprintf("error getting %s config: %v", kind, err)
+			return &service
 		}
+		return nil
 	}
 
-	return services, nil
+	traefikConfig := getLabels(config, opts)
+	if opts.Debug {
+		log.Printf("DEBUG: %s %s (%d) traefik config: %v", kind, guest.Name, guest.VMID, traefikConfig)
+	}
+
+	service := internal.NewService(guest.VMID, guest.Name, traefikConfig)
+	service.Type = guestType
+
+	ips, err := getIPsOfService(client, ctx, nodeName, guest.VMID, guest.IsContainer, opts)
+	service.AgentFailed = err != nil
+	if err == nil {
+		ips = selectBackendIPs(ips, opts.BackendInterface)
+		service.IPs = ips
+	} else if errors.Is(err, errAgentNotReady) && opts.SkipAgentNotReady {
+		log.Printf("Skipping VM %s (%d) until its guest agent reports an IP", guest.Name, guest.VMID)
+		return nil
+	}
+	service.AgentStatus = describeAgentResult(ips, err)
+	if err != nil && opts.PublishDiagnostics && isBoolLabelEnabled(traefikConfig, "traefik.enable") {
+		service.ScanError = err.Error()
+	}
+
+	if opts.ResolveFallbackHostnames && len(service.IPs) == 0 && isBoolLabelEnabled(traefikConfig, "traefik.enable") {
+		if resolved := resolveFallbackHostname(ctx, opts.LookupIP, service, nodeName, opts.UseResolvedAddresses); len(resolved) > 0 {
+			service.IPs = resolved
+		}
+	}
+
+	if !guest.IsContainer && opts.AutoDetectPort && err == nil && needsDetectedPort(traefikConfig) {
+		service.DetectedPort = detectPort(client, ctx, nodeName, guest.VMID, opts.PortDetectTimeout, opts.Debug)
+	}
+	if opts.PortFromTags {
+		service.TagPort = tagPort(config.Fields["tags"])
+	}
+
+	return &service
 }
 
-func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.Configuration {
+// BuildConfiguration turns the guests found on each node into Traefik's dynamic
+// configuration. It does not talk to the Proxmox API, so it can be used to
+// test label mappings or embed the provider logic in other tools.
+func BuildConfiguration(servicesMap map[string][]internal.Service, opts Options) *dynamic.Configuration {
 	config := &dynamic.Configuration{
 		HTTP: &dynamic.HTTPConfiguration{
 			Routers:           make(map[string]*dynamic.Router),
@@ -330,15 +1912,82 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 		},
 	}
 
-	// Loop through all node service maps
-	for nodeName, services := range servicesMap {
+	// Track which guest defined each router and service so collisions can be reported
+	routerOwners := make(map[string]string)
+	// Default routers of clone groups, shared by every clone of the group
+	cloneRouters := make(map[string]bool)
+	serviceOwners := make(map[string]string)
+	tcpRouterOwners := make(map[string]string)
+	udpRouterOwners := make(map[string]string)
+	tlsOptionOwners := make(map[string]string)
+	tlsStoreOwners := make(map[string]string)
+	middlewareOwners := make(map[string]string)
+	transportOwners := make(map[string]string)
+	groups := make(map[string]*appGroup)
+
+	// Loop through all node service maps in a stable order
+	nodeNames := make([]string, 0, len(servicesMap))
+	for nodeName := range servicesMap {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	for _, nodeName := range nodeNames {
 		// Loop through all services in this node
-		for _, service := range services {
+		for _, service := range servicesMap[nodeName] {
+			owner := fmt.Sprintf("%s (ID: %d) on node %s", service.Name, service.ID, nodeName)
+
+			// Guests that failed to scan are shown in the dashboard
+			if service.ScanError != "" {
+				addDiagnostics(config, service, nodeName, opts, routerOwners, serviceOwners, middlewareOwners, owner)
+				if len(service.Config) == 0 {
+					continue
+				}
+			}
+
 			// Skip disabled services
+			if value, exists := service.Config["traefik.enable"]; exists {
+				if _, err := stringToBool(value); err != nil {
+					log.Printf("WARN: %s has traefik.enable=%q, which is not a recognized boolean (true/false, yes/no, 1/0), so it stays disabled", owner, value)
+				}
+			}
 			if len(service.Config) == 0 || !isBoolLabelEnabled(service.Config, "traefik.enable") {
 				log.Printf("Skipping service %s (ID: %d) because traefik.enable is not true", service.Name, service.ID)
 				continue
 			}
+
+			logUnhandledLabels(service)
+
+			// Create TCP routers and services
+			addTCPConfiguration(config, service, nodeName, opts, tcpRouterOwners, owner)
+
+			// Create UDP routers and services
+			addUDPConfiguration(config, service, nodeName, opts, udpRouterOwners, owner)
+
+			// Create TLS options declared on this guest
+			for optionName, option := range buildTLSOptions(service) {
+				if previous, exists := tlsOptionOwners[optionName]; exists {
+					log.Printf("WARN: TLS options %s are defined by both %s and %s, keeping the first definition", optionName, previous, owner)
+					continue
+				}
+				config.TLS.Options[optionName] = option
+				tlsOptionOwners[optionName] = owner
+			}
+
+			// Create the TLS store declared on this guest
+			for storeName, store := range buildTLSStores(service) {
+				if previous, exists := tlsStoreOwners[storeName]; exists {
+					log.Printf("WARN: TLS store %s is defined by both %s and %s, keeping the first definition", storeName, previous, owner)
+					continue
+				}
+				config.TLS.Stores[storeName] = store
+				tlsStoreOwners[storeName] = owner
+			}
+
+			// Group members are turned into routers once all guests are known
+			if hasGroupLabel(service) {
+				addGroupMember(groups, service, nodeName, owner, opts)
+			}
 			
 			// Extract router and service names from labels
 			routerPrefixMap := make(map[string]bool)
@@ -359,8 +2008,14 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 				}
 			}
 			
-			// Default to service ID if no names found
-			defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
+			// Guests declaring only TCP, UDP or group labels get no default HTTP router
+			if len(routerPrefixMap) == 0 && len(servicePrefixMap) == 0 && (hasTCPLabels(service) || hasUDPLabels(service) || hasGroupLabel(service)) {
+				continue
+			}
+
+			// Default to a key unique across nodes and guest types if no names found
+			defaultID := defaultServiceKey(service, nodeName, opts)
+			_, isClone := cloneGroup(service, opts)
 			
 			// Convert maps to slices
 			routerNames := mapKeysToSlice(routerPrefixMap)
@@ -375,7 +2030,29 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 			}
 			
 			// Create services
+			reachable := false
 			for _, serviceName := range serviceNames {
+				// Weighted and mirroring services only refer to other services
+				composite, err := buildCompositeService(service, serviceName)
+				if err != nil {
+					log.Printf("WARN: Service %s of %s is invalid and was skipped: %v", serviceName, owner, err)
+					continue
+				}
+				if composite != nil {
+					reachable = true
+					if previous, exists := serviceOwners[serviceName]; exists {
+						log.Printf("WARN: Service %s is defined by both %s and %s, keeping the first definition", serviceName, previous, owner)
+						continue
+					}
+					config.HTTP.Services[serviceName] = composite
+					serviceOwners[serviceName] = owner
+					continue
+				}
+
+				if len(service.IPs) > 0 || hasExplicitBackend(service, serviceName) {
+					reachable = true
+				}
+
 				// Configure load balancer options
 				loadBalancer := &dynamic.ServersLoadBalancer{
 					PassHostHeader: boolPtr(true), // Default is true
@@ -385,27 +2062,64 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 				// Apply service options
 				applyServiceOptions(loadBalancer, service, serviceName)
 				
-				// Add server URL(s)
-				serverURL := getServiceURL(service, serviceName, nodeName)
-				loadBalancer.Servers = append(loadBalancer.Servers, dynamic.Server{
-					URL: serverURL,
-				})
+				// Add server URL(s), unless the guest is drained for maintenance
+				if isDraining(service, serviceName) {
+					log.Printf("Service %s of %s is draining, its servers are left out of rotation", serviceName, owner)
+				} else {
+					for _, serverURL := range getServerURLs(service, serviceName, nodeName, opts) {
+						loadBalancer.Servers = append(loadBalancer.Servers, dynamic.Server{
+							URL: serverURL,
+						})
+					}
+				}
 				
-				config.HTTP.Services[serviceName] = &dynamic.Service{
+				httpService := &dynamic.Service{
 					LoadBalancer: loadBalancer,
 				}
+				applyLabelPassthrough(httpService, service.Config, labelKey("http.services", serviceName, "")+".", isHandledServiceLabel)
+				if failover := httpService.Failover; failover != nil {
+					failover.Service = labelReference(failover.Service)
+					failover.Fallback = labelReference(failover.Fallback)
+				}
+
+				// Guests sharing a service name are merged into one load balancer
+				if existing, exists := config.HTTP.Services[serviceName]; exists {
+					if existing.LoadBalancer == nil {
+						log.Printf("WARN: Service %s is defined by both %s and %s, keeping the first definition", serviceName, serviceOwners[serviceName], owner)
+						continue
+					}
+					log.Printf("Service %s is shared by %s and %s, merging servers", serviceName, serviceOwners[serviceName], owner)
+					existing.LoadBalancer.Servers = mergeServers(existing.LoadBalancer.Servers, loadBalancer.Servers)
+					continue
+				}
+
+				config.HTTP.Services[serviceName] = httpService
+				serviceOwners[serviceName] = owner
+			}
+
+			if !reachable {
+				agentStatus := service.AgentStatus
+				if agentStatus == "" {
+					agentStatus = "no interface lookup result"
+				}
+				fallback := fmt.Sprintf("routing to unverified hostname %s.%s", service.Name, nodeName)
+				if opts.DisableHostnameFallback {
+					fallback = "no server was generated"
+				}
+				log.Printf("WARN: %s (VMID: %d) on node %s has traefik.enable=true but no reachable backend was found (%s); %s",
+					service.Name, service.ID, nodeName, agentStatus, fallback)
 			}
 			
 			// Create routers
 			for _, routerName := range routerNames {
 				// Get router rule
-				rule := getRouterRule(service, routerName)
+				rule := getRouterRule(service, routerName, opts)
 				
 				// Find target service (prefer explicit mapping)
 				targetService := serviceNames[0]
-				serviceLabel := fmt.Sprintf("traefik.http.routers.%s.service", routerName)
+				serviceLabel := labelKey("http.routers", routerName, "service")
 				if val, exists := service.Config[serviceLabel]; exists {
-					targetService = val
+					targetService = labelReference(val)
 				}
 				
 				// Create basic router
@@ -417,20 +2131,235 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 				
 				// Apply additional router options from labels
 				applyRouterOptions(router, service, routerName)
+
+				// Fall back to the entrypoints of the node or the provider
+				if len(router.EntryPoints) == 0 {
+					router.EntryPoints = defaultEntrypoints(nodeName, opts)
+				}
+				router.Middlewares = withDefaultMiddlewares(router.Middlewares, opts)
 				
+				if previous, exists := routerOwners[routerName]; exists {
+					// Clones of a group share the router of the first clone
+					if isClone && routerName == defaultID && cloneRouters[routerName] {
+						continue
+					}
+					log.Printf("WARN: Router %s is defined by both %s and %s, keeping the first definition", routerName, previous, owner)
+					continue
+				}
+
 				config.HTTP.Routers[routerName] = router
+				routerOwners[routerName] = owner
+				if isClone && routerName == defaultID {
+					cloneRouters[routerName] = true
+				}
+			}
+
+			// Create middlewares declared inline on this guest
+			for middlewareName, middleware := range buildMiddlewares(service) {
+				if previous, exists := middlewareOwners[middlewareName]; exists {
+					log.Printf("WARN: Middleware %s is defined by both %s and %s, keeping the first definition", middlewareName, previous, owner)
+					continue
+				}
+				config.HTTP.Middlewares[middlewareName] = middleware
+				middlewareOwners[middlewareName] = owner
+			}
+
+			// Create servers transports declared on this guest
+			for transportName, transport := range buildServersTransports(service) {
+				if previous, exists := transportOwners[transportName]; exists {
+					log.Printf("WARN: Servers transport %s is defined by both %s and %s, keeping the first definition", transportName, previous, owner)
+					continue
+				}
+				config.HTTP.ServersTransports[transportName] = transport
+				transportOwners[transportName] = owner
 			}
 			
 			log.Printf("Created router and service for %s (ID: %d)", service.Name, service.ID)
 		}
 	}
+
+	addGroupConfiguration(config, groups, opts, routerOwners, serviceOwners)
+	validateRouterServices(config, routerOwners)
+	validateCompositeServices(config, serviceOwners)
+	warnDuplicateRules(config, routerOwners)
+	capServers(config, opts.MaxServersPerService)
+	applyProviderPrefix(config, opts.ProviderPrefix)
 	
 	return config
 }
 
+// parseNodeEntrypoints parses a comma-separated list of node:entrypoint
+// pairs. A node may be listed several times to get more entrypoints.
+func parseNodeEntrypoints(s string) (map[string][]string, error) {
+	nodeEntrypoints := make(map[string][]string)
+	for _, entry := range splitList(s) {
+		node, entrypoint, found := strings.Cut(entry, ":")
+		node, entrypoint = strings.TrimSpace(node), strings.TrimSpace(entrypoint)
+		if !found || node == "" || entrypoint == "" {
+			return nil, fmt.Errorf("entry %q must have the form node:entrypoint", entry)
+		}
+		nodeEntrypoints[node] = append(nodeEntrypoints[node], entrypoint)
+	}
+	return nodeEntrypoints, nil
+}
+
+// defaultEntrypoints returns the entrypoints for routers of a guest on the
+// given node that do not set their own, or nil to use all entrypoints.
+func defaultEntrypoints(nodeName string, opts Options) []string {
+	if entrypoints := opts.NodeEntrypoints[nodeName]; len(entrypoints) > 0 {
+		return append([]string{}, entrypoints...)
+	}
+	if len(opts.DefaultEntrypoints) > 0 {
+		return append([]string{}, opts.DefaultEntrypoints...)
+	}
+	return nil
+}
+
+// defaultTCPEntrypoints returns the entrypoints of a TCP router without an
+// entrypoints label. Without defaultTCPEntrypoints, TCP routers share the
+// defaults of HTTP routers.
+func defaultTCPEntrypoints(nodeName string, opts Options) []string {
+	if len(opts.DefaultTCPEntrypoints) > 0 {
+		return append([]string{}, opts.DefaultTCPEntrypoints...)
+	}
+	return defaultEntrypoints(nodeName, opts)
+}
+
+// Values of DefaultMiddlewaresOrder.
+const (
+	middlewaresOrderFirst = "first"
+	middlewaresOrderLast  = "last"
+)
+
+// withDefaultMiddlewares adds the provider-wide middlewares to the middlewares
+// of a router, skipping those the router already lists itself.
+func withDefaultMiddlewares(middlewares []string, opts Options) []string {
+	if len(opts.DefaultMiddlewares) == 0 {
+		return middlewares
+	}
+
+	listed := make(map[string]bool, len(middlewares))
+	for _, middleware := range middlewares {
+		listed[middleware] = true
+	}
+	defaults := make([]string, 0, len(opts.DefaultMiddlewares))
+	for _, middleware := range opts.DefaultMiddlewares {
+		if !listed[middleware] {
+			defaults = append(defaults, middleware)
+		}
+	}
+
+	if opts.DefaultMiddlewaresLast {
+		return append(append([]string{}, middlewares...), defaults...)
+	}
+	return append(defaults, middlewares...)
+}
+
+// warnDuplicateRules warns about routers sharing a rule on the same
+// entrypoints. Traefik only serves one of them, so the other guests are
+// silently unreachable.
+func warnDuplicateRules(config *dynamic.Configuration, routerOwners map[string]string) {
+	routersByRule := make(map[string][]string)
+	for routerName, router := range config.HTTP.Routers {
+		entryPoints := append([]string{}, router.EntryPoints...)
+		sort.Strings(entryPoints)
+		key := strings.TrimSpace(router.Rule) + " on " + strings.Join(entryPoints, ",")
+		routersByRule[key] = append(routersByRule[key], routerName)
+	}
+
+	for _, routerNames := range routersByRule {
+		if len(routerNames) < 2 {
+			continue
+		}
+		sort.Strings(routerNames)
+		conflicts := make([]string, 0, len(routerNames))
+		for _, routerName := range routerNames {
+			conflicts = append(conflicts, fmt.Sprintf("%s of %s", routerName, routerOwners[routerName]))
+		}
+		router := config.HTTP.Routers[routerNames[0]]
+		log.Printf("WARN: Routers %s share the rule %s on entrypoints %v, Traefik will only serve one of them",
+			strings.Join(conflicts, ", "), router.Rule, router.EntryPoints)
+	}
+}
+
+// validateRouterServices warns about routers pointing at services that are
+// neither generated here nor qualified with another provider.
+func validateRouterServices(config *dynamic.Configuration, routerOwners map[string]string) {
+	for routerName, router := range config.HTTP.Routers {
+		if strings.Contains(router.Service, "@") {
+			continue
+		}
+		if _, exists := config.HTTP.Services[router.Service]; !exists {
+			log.Printf("WARN: Router %s of %s references unknown service %s", routerName, routerOwners[routerName], router.Service)
+		}
+	}
+}
+
+// defaultMaxServersPerService is far above the replicas of a normal service
+// and only stops a shared service name from collecting every guest.
+const defaultMaxServersPerService = 100
+
+// capServers truncates the servers of HTTP, TCP and UDP load balancers to
+// max, logging the backends that were left out. Servers are added in node
+// and guest order, so the same backends are kept on every poll.
+func capServers(config *dynamic.Configuration, max int) {
+	if max <= 0 {
+		return
+	}
+	for serviceName, service := range config.HTTP.Services {
+		if service.LoadBalancer == nil || len(service.LoadBalancer.Servers) <= max {
+			continue
+		}
+		var dropped []string
+		for _, server := range service.LoadBalancer.Servers[max:] {
+			dropped = append(dropped, server.URL)
+		}
+		log.Printf("WARN: Service %s has %d servers, more than maxServersPerService (%d); left out %s", serviceName, len(service.LoadBalancer.Servers), max, strings.Join(dropped, ", "))
+		service.LoadBalancer.Servers = service.LoadBalancer.Servers[:max]
+	}
+	for serviceName, service := range config.TCP.Services {
+		if service.LoadBalancer == nil || len(service.LoadBalancer.Servers) <= max {
+			continue
+		}
+		var dropped []string
+		for _, server := range service.LoadBalancer.Servers[max:] {
+			dropped = append(dropped, server.Address)
+		}
+		log.Printf("WARN: TCP service %s has %d servers, more than maxServersPerService (%d); left out %s", serviceName, len(service.LoadBalancer.Servers), max, strings.Join(dropped, ", "))
+		service.LoadBalancer.Servers = service.LoadBalancer.Servers[:max]
+	}
+	for serviceName, service := range config.UDP.Services {
+		if service.LoadBalancer == nil || len(service.LoadBalancer.Servers) <= max {
+			continue
+		}
+		var dropped []string
+		for _, server := range service.LoadBalancer.Servers[max:] {
+			dropped = append(dropped, server.Address)
+		}
+		log.Printf("WARN: UDP service %s has %d servers, more than maxServersPerService (%d); left out %s", serviceName, len(service.LoadBalancer.Servers), max, strings.Join(dropped, ", "))
+		service.LoadBalancer.Servers = service.LoadBalancer.Servers[:max]
+	}
+}
+
+// validateCompositeServices warns about weighted and mirroring services
+// referencing services that are neither generated here nor qualified with
+// another provider.
+func validateCompositeServices(config *dynamic.Configuration, serviceOwners map[string]string) {
+	for serviceName, service := range config.HTTP.Services {
+		for _, target := range compositeServiceNames(service) {
+			if strings.Contains(target, "@") {
+				continue
+			}
+			if _, exists := config.HTTP.Services[target]; !exists {
+				log.Printf("WARN: Service %s of %s references unknown service %s", serviceName, serviceOwners[serviceName], target)
+			}
+		}
+	}
+}
+
 // Apply router configuration options from labels
 func applyRouterOptions(router *dynamic.Router, service internal.Service, routerName string) {
-	prefix := fmt.Sprintf("traefik.http.routers.%s", routerName)
+	prefix := labelKey("http.routers", routerName, "")
 	
 	// Handle EntryPoints
 	if entrypoints, exists := service.Config[prefix+".entrypoints"]; exists {
@@ -442,7 +2371,7 @@ func applyRouterOptions(router *dynamic.Router, service internal.Service, router
 	
 	// Handle Middlewares
 	if middlewares, exists := service.Config[prefix+".middlewares"]; exists {
-		router.Middlewares = strings.Split(middlewares, ",")
+		router.Middlewares = labelReferences(strings.Split(middlewares, ","))
 	}
 	
 	// Handle Priority
@@ -457,16 +2386,50 @@ func applyRouterOptions(router *dynamic.Router, service internal.Service, router
 	if tls != nil {
 		router.TLS = tls
 	}
+
+	// The router of the dynamic configuration has no ruleSyntax setting, so
+	// rules are always parsed with the default syntax of the Traefik version.
+	if syntax, exists := service.Config[prefix+".rulesyntax"]; exists {
+		switch strings.ToLower(strings.TrimSpace(syntax)) {
+		case "v3", "default":
+		case "v2":
+			log.Printf("WARN: ruleSyntax v2 of router %s is not supported and was ignored, its rule is parsed with the default syntax; rewrite v2 matchers such as HostRegexp with {name:regex} placeholders for Traefik v3", routerName)
+		default:
+			log.Printf("WARN: Invalid ruleSyntax %q for router %s, expected v2, v3 or default", syntax, routerName)
+		}
+	}
+
+	// The router of the dynamic configuration has no observability settings,
+	// so access logs and tracing follow the static configuration of Traefik.
+	for _, field := range []string{"accesslogs", "tracing"} {
+		value, exists := service.Config[prefix+".observability."+field]
+		if !exists {
+			continue
+		}
+		enabled, err := stringToBool(value)
+		if err != nil {
+			log.Printf("WARN: Invalid observability.%s %q for router %s, expected a boolean", field, value, routerName)
+			continue
+		}
+		if !enabled {
+			log.Printf("WARN: Disabling observability.%s of router %s is not supported and was ignored, it follows the static configuration of Traefik", field, routerName)
+		}
+	}
+
+	// Reflect any remaining router labels onto the router
+	applyLabelPassthrough(router, service.Config, prefix+".", isHandledRouterLabel)
 }
 
 // Apply service configuration options from labels
 func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Service, serviceName string) {
-	prefix := fmt.Sprintf("traefik.http.services.%s.loadbalancer", serviceName)
+	prefix := labelKey("http.services", serviceName, "loadbalancer")
 	
 	// Handle PassHostHeader
 	if passHostHeader, exists := service.Config[prefix+".passhostheader"]; exists {
 		if val, err := stringToBool(passHostHeader); err == nil {
 			lb.PassHostHeader = &val
+		} else {
+			log.Printf("WARN: Invalid passhostheader value %q for service %s, keeping default: %v", passHostHeader, serviceName, err)
 		}
 	}
 	
@@ -509,11 +2472,25 @@ func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Servi
 		
 		lb.Sticky = sticky
 	}
+
+	// The sticky cookie of the dynamic configuration has no max-age, so
+	// Traefik always sets a session cookie.
+	if maxAge, exists := service.Config[prefix+".sticky.cookie.maxage"]; exists {
+		if _, err := strconv.Atoi(strings.TrimSpace(maxAge)); err != nil {
+			log.Printf("WARN: Invalid sticky cookie maxage %q for service %s, expected a number of seconds", maxAge, serviceName)
+		} else {
+			log.Printf("WARN: Sticky cookie maxage of service %s is not supported and was ignored, the cookie lasts for the browser session", serviceName)
+		}
+	}
 	
 	// Handle ResponseForwarding
 	if flushInterval, exists := service.Config[prefix+".responseforwarding.flushinterval"]; exists {
-		lb.ResponseForwarding = &dynamic.ResponseForwarding{
-			FlushInterval: flushInterval,
+		if isValidDuration(flushInterval) {
+			lb.ResponseForwarding = &dynamic.ResponseForwarding{
+				FlushInterval: flushInterval,
+			}
+		} else {
+			log.Printf("WARN: Invalid flushinterval value %q for service %s, skipping", flushInterval, serviceName)
 		}
 	}
 	
@@ -521,6 +2498,37 @@ func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Servi
 	if serverTransport, exists := service.Config[prefix+".serverstransport"]; exists {
 		lb.ServersTransport = serverTransport
 	}
+
+	// The HTTP load balancer of the dynamic configuration has no PROXY
+	// protocol setting, so point users at the TCP equivalent.
+	if version, exists := service.Config[prefix+".server.proxyprotocol.version"]; exists {
+		if _, err := parseProxyProtocolVersion(version); err != nil {
+			log.Printf("WARN: Invalid proxyprotocol version for service %s, skipping: %v", serviceName, err)
+		} else {
+			log.Printf("WARN: PROXY protocol is not supported for HTTP service %s and was ignored, use traefik.tcp.services.%s.loadbalancer.proxyprotocol.version instead", serviceName, serviceName)
+		}
+	}
+
+	// Neither has it a strategy setting, so Traefik always uses its default
+	// weighted round robin.
+	if strategy, exists := service.Config[prefix+".strategy"]; exists {
+		switch strings.ToLower(strings.TrimSpace(strategy)) {
+		case "wrr":
+		case "p2c", "hrw", "leasttime":
+			log.Printf("WARN: Load balancing strategy %s of service %s is not supported and was ignored, using the default weighted round robin", strategy, serviceName)
+		default:
+			log.Printf("WARN: Invalid load balancing strategy %q for service %s, expected wrr, p2c, hrw or leasttime", strategy, serviceName)
+		}
+	}
+}
+
+// parseProxyProtocolVersion accepts the PROXY protocol versions 1 and 2.
+func parseProxyProtocolVersion(value string) (int, error) {
+	version, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || (version != 1 && version != 2) {
+		return 0, fmt.Errorf("version must be 1 or 2, got %q", value)
+	}
+	return version, nil
 }
 
 // Handle TLS configuration
@@ -588,35 +2596,42 @@ func handleRouterTLS(service internal.Service, prefix string) *dynamic.RouterTLS
 }
 
 // Helper to get service URL with correct port
-func getServiceURL(service internal.Service, serviceName string, nodeName string) string {
+func getServiceURL(service internal.Service, serviceName string, nodeName string, opts Options) string {
 	// Check for direct URL override
-	urlLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.url", serviceName)
-	if url, exists := service.Config[urlLabel]; exists {
-		return url
+	urlLabel := labelKey("http.services", serviceName, "loadbalancer.server.url")
+	if serverURL, exists := service.Config[urlLabel]; exists {
+		// The URL is used verbatim, bypassing address discovery entirely
+		if u, err := url.Parse(serverURL); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Printf("WARN: Server URL %q of service %s is not an absolute URL such as http://host:port", serverURL, serviceName)
+		}
+		return serverURL
 	}
 
 	// Default protocol and port
-	protocol := "http"
-	port := "80"
-	
-	// Check for HTTPS protocol setting
-	httpsLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.scheme", serviceName)
-	if scheme, exists := service.Config[httpsLabel]; exists && scheme == "https" {
-		protocol = "https"
-		// Update default port for HTTPS
-		port = "443"
+	protocol, port := getServiceScheme(service, serviceName, opts)
+	if opts.DefaultPort != "" {
+		port = opts.DefaultPort
+	}
+	if service.TagPort != "" {
+		port = service.TagPort
+	}
+	if service.DetectedPort != "" {
+		port = service.DetectedPort
+	}
+	if val := guestPort(service); val != "" {
+		port = val
 	}
 	
 	// Look for service-specific port
-	portLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", serviceName)
+	portLabel := labelKey("http.services", serviceName, "loadbalancer.server.port")
 	if val, exists := service.Config[portLabel]; exists {
 		port = val
 	}
 
 	// Look for service-specific ip
-	ipLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.ip", serviceName)
+	ipLabel := labelKey("http.services", serviceName, "loadbalancer.server.ip")
 	if val, exists := service.Config[ipLabel]; exists {
-		return fmt.Sprintf("%s://%s:%s", protocol, val, port)
+		return formatServerURL(protocol, val, port, service, nodeName, opts)
 	}
 	
 	// Use IP if available, otherwise fall back to hostname
@@ -624,24 +2639,308 @@ func getServiceURL(service internal.Service, serviceName string, nodeName string
 		// Create a list of server URLs from all IPs
 		for _, ip := range service.IPs {
 			if ip.Address != "" {
-				return fmt.Sprintf("%s://%s:%s", protocol, ip.Address, port)
+				return formatServerURL(protocol, ip.Address, port, service, nodeName, opts)
 			}
 		}
 	}
 	
 	// Fall back to hostname
-	url := fmt.Sprintf("%s://%s.%s:%s", protocol, service.Name, nodeName, port)
+	url := formatServerURL(protocol, service.Name+"."+nodeName, port, service, nodeName, opts)
 	log.Printf("No IPs found, using hostname URL %s for service %s (ID: %d)", url, service.Name, service.ID)
 	return url
 }
 
+// serverURLData is the data available to the serverURLTemplate.
+type serverURLData struct {
+	Scheme string
+	// IP is the backend address, in brackets for IPv6, or the fallback hostname.
+	IP   string
+	Port string
+	Name string
+	Node string
+	ID   uint64
+}
+
+// parseServerURLTemplate parses a server URL template such as
+// "{{.Scheme}}://{{.IP}}:{{.Port}}/app" and checks it renders an absolute
+// URL. An empty template returns nil.
+func parseServerURLTemplate(s string) (*template.Template, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("serverURL").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	sample := serverURLData{Scheme: "http", IP: "10.0.0.1", Port: "80", Name: "guest", Node: "pve", ID: 100}
+	if err := tmpl.Execute(&b, sample); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(b.String()); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("template renders %q, which is not an absolute URL", b.String())
+	}
+	return tmpl, nil
+}
+
+// formatServerURL builds the URL of a backend server, using the
+// serverURLTemplate when one is configured.
+func formatServerURL(protocol, host, port string, service internal.Service, nodeName string, opts Options) string {
+	if opts.ServerURLTemplate == nil {
+		return fmt.Sprintf("%s://%s", protocol, urlHostPort(host, port))
+	}
+	var b strings.Builder
+	data := serverURLData{Scheme: protocol, IP: urlHost(host), Port: port, Name: service.Name, Node: nodeName, ID: service.ID}
+	if err := opts.ServerURLTemplate.Execute(&b, data); err != nil {
+		log.Printf("WARN: Server URL template failed for %s (ID: %d), using the default URL: %v", service.Name, service.ID, err)
+		return fmt.Sprintf("%s://%s", protocol, urlHostPort(host, port))
+	}
+	return b.String()
+}
+
+// urlHostPort joins host and port for use in a URL, see urlHost.
+func urlHostPort(host, port string) string {
+	return urlHost(host) + ":" + port
+}
+
+// urlHost prepares a host for use in a URL. IPv6 addresses are put in
+// brackets; a zone is kept, escaped as %25, only for link-local addresses,
+// where it selects the interface, and dropped otherwise.
+func urlHost(host string) string {
+	address, zone, _ := strings.Cut(strings.Trim(host, "[]"), "%")
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return host
+	}
+	if zone != "" && ip.IsLinkLocalUnicast() {
+		address += "%25" + zone
+	}
+	return "[" + address + "]"
+}
+
+// getServiceScheme returns the protocol and its default port for a service
+func getServiceScheme(service internal.Service, serviceName string, opts Options) (protocol string, port string) {
+	schemeLabel := labelKey("http.services", serviceName, "loadbalancer.server.scheme")
+	scheme, exists := service.Config[schemeLabel]
+	if !exists && opts.InferScheme {
+		scheme, exists = inferScheme(service, serviceName)
+	}
+	if !exists && opts.DefaultScheme != "" {
+		scheme, exists = opts.DefaultScheme, true
+	}
+	if !exists {
+		return "http", "80"
+	}
+
+	switch strings.ToLower(scheme) {
+	case "https":
+		return "https", "443"
+	case "h2c":
+		// HTTP/2 over cleartext, no TLS involved
+		return "h2c", "80"
+	case "http":
+		return "http", "80"
+	default:
+		log.Printf("WARN: Unsupported scheme %q for service %s, using http", scheme, serviceName)
+		return "http", "80"
+	}
+}
+
+// inferScheme guesses the scheme from a well-known port label.
+func inferScheme(service internal.Service, serviceName string) (string, bool) {
+	portLabel := labelKey("http.services", serviceName, "loadbalancer.server.port")
+	port, exists := service.Config[portLabel]
+	if !exists {
+		port = guestPort(service)
+	}
+	if port == "" {
+		port = service.DetectedPort
+	}
+	if port == "" {
+		port = service.TagPort
+	}
+	switch port {
+	case "443", "8443":
+		return "https", true
+	case "80", "8080":
+		return "http", true
+	default:
+		return "", false
+	}
+}
+
+// getServerURLs returns the backend URLs for a service. Addresses with their
+// own port label each get a server; otherwise a single URL is built.
+func getServerURLs(service internal.Service, serviceName string, nodeName string, opts Options) []string {
+	if !hasExplicitBackend(service, serviceName) {
+		if urls := getPerAddressURLs(service, serviceName, nodeName, opts); len(urls) > 0 {
+			return urls
+		}
+		if len(service.IPs) == 0 && opts.DisableHostnameFallback {
+			return nil
+		}
+	}
+	return []string{getServiceURL(service, serviceName, nodeName, opts)}
+}
+
+// getPerAddressURLs builds one URL for every discovered IP that has a
+// loadbalancer.server.port.<ip> override.
+func getPerAddressURLs(service internal.Service, serviceName string, nodeName string, opts Options) []string {
+	portPrefix := labelKey("http.services", serviceName, "loadbalancer.server.port") + "."
+	protocol, _ := getServiceScheme(service, serviceName, opts)
+
+	urls := make([]string, 0)
+	for _, ip := range service.IPs {
+		if port, exists := service.Config[portPrefix+ip.Address]; exists && ip.Address != "" {
+			urls = append(urls, formatServerURL(protocol, ip.Address, port, service, nodeName, opts))
+		}
+	}
+	return urls
+}
+
+// guestName returns the name of a guest used in default rules and names,
+// normalized with NormalizeNames. Clones use the name of their group, see
+// cloneGroup. Logs keep the raw service.Name.
+func guestName(service internal.Service, opts Options) string {
+	name := service.Name
+	if group, ok := cloneGroup(service, opts); ok {
+		name = group
+	}
+	if !opts.NormalizeNames {
+		return name
+	}
+	return normalizeName(name)
+}
+
+// cloneGroup returns the name captured by CloneGroupPattern from the name of
+// a guest, so "web-1" and "web-2" both belong to group "web" with
+// ^(web)-\d+$. Guests that do not match, or capture nothing, are not clones.
+func cloneGroup(service internal.Service, opts Options) (string, bool) {
+	if opts.CloneGroupPattern == nil {
+		return "", false
+	}
+	match := opts.CloneGroupPattern.FindStringSubmatch(service.Name)
+	if len(match) < 2 || match[1] == "" {
+		return "", false
+	}
+	return match[1], true
+}
+
+// normalizeName lowercases a guest name and replaces every run of characters
+// other than letters, digits and '-' with a single '-', so "My App_01"
+// becomes "my-app-01". Names without any valid character are kept as is.
+func normalizeName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	normalized := strings.TrimSuffix(b.String(), "-")
+	if normalized == "" {
+		return name
+	}
+	return normalized
+}
+
+// defaultServiceKey builds the router and service name used when a guest
+// declares none, qualified by guest type and node so keys cannot collide.
+// A name template replaces the {type}, {node}, {name} and {id} placeholders.
+// Clones share one key per group and guest type, so their servers are merged
+// into a single service across nodes.
+func defaultServiceKey(service internal.Service, nodeName string, opts Options) string {
+	name := guestName(service, opts)
+	if _, ok := cloneGroup(service, opts); ok {
+		if service.Type != "" {
+			return service.Type + "-" + name
+		}
+		return name
+	}
+	if opts.NameTemplate != "" {
+		guestType := service.Type
+		if guestType == "" {
+			guestType = "guest"
+		}
+		return strings.NewReplacer(
+			"{type}", guestType,
+			"{node}", nodeName,
+			"{name}", name,
+			"{id}", strconv.FormatUint(service.ID, 10),
+		).Replace(opts.NameTemplate)
+	}
+
+	key := fmt.Sprintf("%s-%s-%d", nodeName, name, service.ID)
+	if service.Type != "" {
+		key = service.Type + "-" + key
+	}
+	return key
+}
+
+// mergeServers appends the servers not already present in existing.
+func mergeServers(existing, servers []dynamic.Server) []dynamic.Server {
+	for _, server := range servers {
+		duplicate := false
+		for _, current := range existing {
+			if current.URL == server.URL {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			existing = append(existing, server)
+		}
+	}
+	return existing
+}
+
+// isDraining reports whether a guest's servers should be taken out of rotation
+// for a service, via traefik.drain=true or a server weight of 0. The router and
+// service stay in the configuration so routes do not flap during maintenance.
+func isDraining(service internal.Service, serviceName string) bool {
+	if isBoolLabelEnabled(service.Config, "traefik.drain") {
+		return true
+	}
+
+	weightLabel := labelKey("http.services", serviceName, "loadbalancer.server.weight")
+	weight, exists := service.Config[weightLabel]
+	if !exists {
+		return false
+	}
+	w, err := strconv.Atoi(weight)
+	if err != nil || w < 0 {
+		log.Printf("WARN: Invalid server weight %q for service %s, ignoring", weight, serviceName)
+		return false
+	}
+	if w > 0 {
+		// Traefik's dynamic configuration used here has no per-server weight
+		log.Printf("WARN: Server weight %d for service %s is not supported, only 0 (drain) is honoured", w, serviceName)
+	}
+	return w == 0
+}
+
+// hasExplicitBackend reports whether the labels pin the backend address so no
+// discovered IP is needed.
+func hasExplicitBackend(service internal.Service, serviceName string) bool {
+	prefix := labelKey("http.services", serviceName, "loadbalancer.server") + "."
+	_, hasURL := service.Config[prefix+"url"]
+	_, hasIP := service.Config[prefix+"ip"]
+	return hasURL || hasIP
+}
+
 // Helper to get router rule
-func getRouterRule(service internal.Service, routerName string) string {
+func getRouterRule(service internal.Service, routerName string, opts Options) string {
 	// Default rule
-	rule := fmt.Sprintf("Host(`%s`)", service.Name)
+	rule := fmt.Sprintf("Host(`%s`)", guestName(service, opts))
 	
 	// Look for router-specific rule
-	ruleLabel := fmt.Sprintf("traefik.http.routers.%s.rule", routerName)
+	ruleLabel := labelKey("http.routers", routerName, "rule")
 	if val, exists := service.Config[ruleLabel]; exists {
 		rule = val
 	}
@@ -658,6 +2957,16 @@ func stringToInt(s string) (int, error) {
 	return i, nil
 }
 
+// Helper to check a duration label, accepting Go durations and plain seconds
+// like Traefik does
+func isValidDuration(s string) bool {
+	if _, err := time.ParseDuration(s); err == nil {
+		return true
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
 // Helper to convert string to bool
 func stringToBool(s string) (bool, error) {
 	switch strings.ToLower(s) {
@@ -670,12 +2979,24 @@ func stringToBool(s string) (bool, error) {
 	}
 }
 
-// Helper to convert map keys to slice
+// Helper to split a comma-separated config value, dropping empty items
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Helper to convert map keys to a sorted slice
 func mapKeysToSlice(m map[string]bool) []string {
 	result := make([]string, 0, len(m))
 	for k := range m {
 		result = append(result, k)
 	}
+	sort.Strings(result)
 	return result
 }
 
@@ -697,18 +3018,144 @@ func validateConfig(config *Config) error {
 		return errors.New("API endpoint must be set")
 	}
 
-	if config.ApiTokenId == "" {
-		return errors.New("API token ID must be set")
+	if _, err := parseClusterEndpoints(config); err != nil {
+		return err
+	}
+
+	if config.ApiUser != "" {
+		if config.ApiTokenId != "" || config.ApiToken != "" {
+			return errors.New("API token and user/password authentication cannot be combined")
+		}
+		if config.ApiPassword == "" {
+			return errors.New("API password must be set")
+		}
+		if _, err := qualifyUser(config.ApiUser, config.ApiRealm); err != nil {
+			return err
+		}
+	} else {
+		if config.ApiTokenId == "" {
+			return errors.New("API token ID must be set")
+		}
+
+		if config.ApiToken == "" {
+			return errors.New("API token must be set")
+		}
+	}
+
+	if config.ApiMaxResponseSize != "" {
+		if size, err := strconv.ParseInt(config.ApiMaxResponseSize, 10, 64); err != nil || size <= 0 {
+			return fmt.Errorf("API max response size must be a positive number of bytes, got %q", config.ApiMaxResponseSize)
+		}
+	}
+
+	if config.ApiHeader != "" {
+		if _, _, err := parseAPIHeader(config.ApiHeader); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToLower(config.DefaultScheme) {
+	case "", "http", "https", "h2c":
+	default:
+		return fmt.Errorf("default scheme must be http, https or h2c, got %q", config.DefaultScheme)
+	}
+
+	if (config.ApiClientCert == "") != (config.ApiClientKey == "") {
+		return errors.New("API client certificate and key must be set together")
+	}
+
+	if config.ApiCAFile != "" {
+		if _, err := internal.LoadCertPool(config.ApiCAFile); err != nil {
+			return fmt.Errorf("invalid apiCAFile: %w", err)
+		}
+	}
+
+	if strings.Contains(config.BackendInterface, "/") {
+		if _, _, err := net.ParseCIDR(config.BackendInterface); err != nil {
+			return fmt.Errorf("backend interface %q is not a valid subnet: %w", config.BackendInterface, err)
+		}
+	}
+
+	if config.DefaultPort != "" {
+		if port, err := strconv.Atoi(strings.TrimSpace(config.DefaultPort)); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("default port must be a number between 1 and 65535, got %q", config.DefaultPort)
+		}
+	}
+
+	if config.MaxServersPerService != "" {
+		if max, err := strconv.Atoi(strings.TrimSpace(config.MaxServersPerService)); err != nil || max < 0 {
+			return fmt.Errorf("max servers per service must be a non-negative number, got %q", config.MaxServersPerService)
+		}
+	}
+
+	if _, err := parseProbeTimeout(config.ProbeTimeout); err != nil {
+		return err
+	}
+
+	if _, err := parseDebounceWindow(config.DebounceWindow); err != nil {
+		return err
+	}
+
+	if _, err := parseNameFilter(config.NameFilter); err != nil {
+		return fmt.Errorf("invalid nameFilter: %w", err)
+	}
+
+	if _, err := parseCloneGroupPattern(config.CloneGroupPattern); err != nil {
+		return fmt.Errorf("invalid cloneGroupPattern: %w", err)
+	}
+
+	if _, err := parseNodeEntrypoints(config.NodeEntrypoints); err != nil {
+		return fmt.Errorf("invalid nodeEntrypoints: %w", err)
+	}
+
+	if _, err := parseServerURLTemplate(config.ServerURLTemplate); err != nil {
+		return fmt.Errorf("invalid serverURLTemplate: %w", err)
 	}
 
-	if config.ApiToken == "" {
-		return errors.New("API token must be set")
+	if _, err := parseStaticIPs(config.VMIDToIP); err != nil {
+		return fmt.Errorf("invalid vmidToIP: %w", err)
+	}
+
+	if config.GuestConcurrency != "" {
+		if n, err := strconv.Atoi(config.GuestConcurrency); err != nil || n < 1 || n > maxGuestConcurrency {
+			return fmt.Errorf("guest concurrency must be a number between 1 and %d, got %q", maxGuestConcurrency, config.GuestConcurrency)
+		}
+	}
+
+	if config.NameTemplate != "" && !strings.Contains(config.NameTemplate, "{id}") {
+		return fmt.Errorf("name template %q must contain {id} to keep names unique", config.NameTemplate)
+	}
+
+	switch strings.ToLower(config.DefaultMiddlewaresOrder) {
+	case "", middlewaresOrderFirst, middlewaresOrderLast:
+	default:
+		return fmt.Errorf("default middlewares order must be %q or %q, got %q", middlewaresOrderFirst, middlewaresOrderLast, config.DefaultMiddlewaresOrder)
+	}
+
+	if strings.ContainsAny(config.LabelPrefix, " \t\r\n=\"'") {
+		return fmt.Errorf("label prefix must not contain whitespace, quotes or '=', got %q", config.LabelPrefix)
+	}
+
+	switch config.LabelSource {
+	case "", labelSourceDescription, labelSourceBlock, labelSourceField:
+	default:
+		return fmt.Errorf("label source must be %q, %q or %q, got %q", labelSourceDescription, labelSourceBlock, labelSourceField, config.LabelSource)
+	}
+
+	if strings.ContainsAny(strings.TrimSpace(config.LabelField), " \t\r\n=,\"'") {
+		return fmt.Errorf("label field must be a configuration key such as %s, got %q", defaultLabelField, config.LabelField)
 	}
 
 	return nil
 }
 
+// isBoolLabelEnabled reports whether a label is set to a true value such as
+// true, yes or 1. Unrecognized values count as false.
 func isBoolLabelEnabled(labels map[string]string, label string) bool {
 	val, exists := labels[label]
-	return exists && val == "true"
+	if !exists {
+		return false
+	}
+	enabled, err := stringToBool(val)
+	return err == nil && enabled
 }
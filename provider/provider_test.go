@@ -1,10 +1,29 @@
 package provider
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+	"github.com/traefik/genconf/dynamic/tls"
+	"github.com/traefik/genconf/dynamic/types"
 )
 
 func TestProviderConfig(t *testing.T) {
@@ -28,6 +47,19 @@ func TestProviderNew(t *testing.T) {
 	}{
 		{
 			name: "Valid config",
+			config: &Config{
+				PollInterval:            "5s",
+				ApiEndpoint:             "https://proxmox.example.com",
+				ApiTokenId:              "test@pam!test",
+				ApiToken:                "test-token",
+				ApiValidateSSL:          "true",
+				ApiLogging:              "info",
+				RequireVersionAtStartup: "true",
+			},
+			wantErr: true, // We expect an error because the domain doesn't exist
+		},
+		{
+			name: "Unreachable endpoint without required version",
 			config: &Config{
 				PollInterval:   "5s",
 				ApiEndpoint:    "https://proxmox.example.com",
@@ -36,7 +68,7 @@ func TestProviderNew(t *testing.T) {
 				ApiValidateSSL: "true",
 				ApiLogging:     "info",
 			},
-			wantErr: true, // We expect an error because the domain doesn't exist
+			wantErr: false, // The version check is retried on the next poll
 		},
 		{
 			name:    "Nil config",
@@ -81,6 +113,25 @@ func TestProviderNew(t *testing.T) {
 	}
 }
 
+func TestProviderNew_DefaultGuestConcurrency(t *testing.T) {
+	config := &Config{
+		PollInterval: "5s",
+		ApiEndpoint:  "https://proxmox.example.com",
+		ApiTokenId:   "test@pam!test",
+		ApiToken:     "test-token",
+	}
+	provider, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := provider.clusters[0].scanOptions.GuestConcurrency; got != defaultGuestConcurrency {
+		t.Errorf("Expected guest concurrency %d without guestConcurrency, got %d", defaultGuestConcurrency, got)
+	}
+	if CreateConfig().GuestConcurrency != strconv.Itoa(defaultGuestConcurrency) {
+		t.Errorf("Expected CreateConfig to use the same default, got %q", CreateConfig().GuestConcurrency)
+	}
+}
+
 func TestProviderValidateConfig(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -148,6 +199,291 @@ func TestProviderValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Invalid backend subnet",
+			config: &Config{
+				PollInterval:     "5s",
+				ApiEndpoint:      "https://proxmox.example.com",
+				ApiTokenId:       "test@pam!test",
+				ApiToken:         "test-token",
+				BackendInterface: "10.0.1.0/33",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid default scheme",
+			config: &Config{
+				PollInterval:  "5s",
+				ApiEndpoint:   "https://proxmox.example.com",
+				ApiTokenId:    "test@pam!test",
+				ApiToken:      "test-token",
+				DefaultScheme: "ftp",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Default scheme h2c",
+			config: &Config{
+				PollInterval:  "5s",
+				ApiEndpoint:   "https://proxmox.example.com",
+				ApiTokenId:    "test@pam!test",
+				ApiToken:      "test-token",
+				DefaultScheme: "h2c",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Server URL template without scheme",
+			config: &Config{
+				PollInterval:      "5s",
+				ApiEndpoint:       "https://proxmox.example.com",
+				ApiTokenId:        "test@pam!test",
+				ApiToken:          "test-token",
+				ServerURLTemplate: "{{.IP}}:{{.Port}}",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Server URL template with unknown field",
+			config: &Config{
+				PollInterval:      "5s",
+				ApiEndpoint:       "https://proxmox.example.com",
+				ApiTokenId:        "test@pam!test",
+				ApiToken:          "test-token",
+				ServerURLTemplate: "http://{{.Address}}",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Missing API CA file",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				ApiCAFile:    "/nonexistent/ca.pem",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid debounce window",
+			config: &Config{
+				PollInterval:   "5s",
+				ApiEndpoint:    "https://proxmox.example.com",
+				ApiTokenId:     "test@pam!test",
+				ApiToken:       "test-token",
+				DebounceWindow: "soon",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative max servers per service",
+			config: &Config{
+				PollInterval:         "5s",
+				ApiEndpoint:          "https://proxmox.example.com",
+				ApiTokenId:           "test@pam!test",
+				ApiToken:             "test-token",
+				MaxServersPerService: "-1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown label source",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				LabelSource:  "cloudinit",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Label field with a value",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				LabelSource:  "field",
+				LabelField:   "smbios1=serial",
+			},
+			wantErr: true,
+		},
+		{
+			name: "API header without value separator",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				ApiHeader:    "X-Traefik-Instance",
+			},
+			wantErr: true,
+		},
+		{
+			name: "API header overriding authorization",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				ApiHeader:    "authorization: PVEAPIToken=x",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid default port",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				DefaultPort:  "http",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid probe timeout",
+			config: &Config{
+				PollInterval:  "5s",
+				ApiEndpoint:   "https://proxmox.example.com",
+				ApiTokenId:    "test@pam!test",
+				ApiToken:      "test-token",
+				ProbeBackends: "true",
+				ProbeTimeout:  "0s",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid name filter",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				NameFilter:   "ingress-(",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Label prefix with spaces",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				LabelPrefix:  "px traefik.",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid node entrypoints",
+			config: &Config{
+				PollInterval:    "5s",
+				ApiEndpoint:     "https://proxmox.example.com",
+				ApiTokenId:      "test@pam!test",
+				ApiToken:        "test-token",
+				NodeEntrypoints: "pve-a",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid guest concurrency",
+			config: &Config{
+				PollInterval:     "5s",
+				ApiEndpoint:      "https://proxmox.example.com",
+				ApiTokenId:       "test@pam!test",
+				ApiToken:         "test-token",
+				GuestConcurrency: "0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Name template without id",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				NameTemplate: "{type}-{name}",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid default middlewares order",
+			config: &Config{
+				PollInterval:            "5s",
+				ApiEndpoint:             "https://proxmox.example.com",
+				ApiTokenId:              "test@pam!test",
+				ApiToken:                "test-token",
+				DefaultMiddlewaresOrder: "middle",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Client certificate without key",
+			config: &Config{
+				PollInterval:  "5s",
+				ApiEndpoint:   "https://proxmox.example.com",
+				ApiTokenId:    "test@pam!test",
+				ApiToken:      "test-token",
+				ApiClientCert: "/etc/traefik/proxmox-client.pem",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Password auth",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiUser:      "traefik",
+				ApiRealm:     "pve",
+				ApiPassword:  "secret",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Password auth without realm",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiUser:      "traefik",
+				ApiPassword:  "secret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Password auth without password",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiUser:      "traefik@pve",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Clone group pattern",
+			config: &Config{
+				PollInterval:      "5s",
+				ApiEndpoint:       "https://proxmox.example.com",
+				ApiTokenId:        "test@pam!test",
+				ApiToken:          "test-token",
+				CloneGroupPattern: `^(web)-\d+$`,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Clone group pattern without capture group",
+			config: &Config{
+				PollInterval:      "5s",
+				ApiEndpoint:       "https://proxmox.example.com",
+				ApiTokenId:        "test@pam!test",
+				ApiToken:          "test-token",
+				CloneGroupPattern: `^web-\d+$`,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -220,6 +556,40 @@ func TestProviderParserConfig(t *testing.T) {
 	}
 }
 
+func TestValidateTokenFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokenID string
+		token   string
+		wantErr string
+	}{
+		{name: "Valid", tokenID: "root@pam!traefik", token: "3b6a4f0e-9c1d-4e2a-8f3b-1a2b3c4d5e6f"},
+		{name: "Secret in token ID", tokenID: "root@pam!traefik=3b6a4f0e", token: "3b6a4f0e", wantErr: `set apiTokenId to "root@pam!traefik"`},
+		{name: "Header prefix in token ID", tokenID: "PVEAPIToken=root@pam!traefik", token: "3b6a4f0e", wantErr: "PVEAPIToken= prefix"},
+		{name: "Token ID in token", tokenID: "root@pam!traefik", token: "root@pam!traefik=3b6a4f0e", wantErr: "apiToken looks like a token ID"},
+		{name: "Token name missing", tokenID: "root@pam", token: "3b6a4f0e", wantErr: "must have the form user@realm!tokenname"},
+		{name: "Realm missing", tokenID: "root!traefik", token: "3b6a4f0e", wantErr: "must have the form user@realm!tokenname"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTokenFormat(tt.tokenID, tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateTokenFormat() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTokenFormat() error = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "3b6a4f0e") {
+				t.Errorf("Expected the secret to be masked, got %v", err)
+			}
+		})
+	}
+}
+
 func TestProviderService(t *testing.T) {
 	config := map[string]string{
 		"traefik.enable":                 "true",
@@ -247,6 +617,7 @@ func TestGetServiceURL(t *testing.T) {
 		service     internal.Service
 		serviceName string
 		nodeName    string
+		opts        Options
 		expectedUrl string
 	}{
 		{
@@ -293,6 +664,29 @@ func TestGetServiceURL(t *testing.T) {
 			},
 			expectedUrl: "https://1.2.3.4:8080",
 		},
+		{
+			name:        "IP and h2c scheme set, default port",
+			serviceName: "service",
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":     "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.scheme": "h2c",
+				},
+			},
+			expectedUrl: "h2c://1.2.3.4:80",
+		},
+		{
+			name:        "IP, port and h2c scheme set",
+			serviceName: "service",
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":     "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.scheme": "h2c",
+					"traefik.http.services.service.loadbalancer.server.port":   "50051",
+				},
+			},
+			expectedUrl: "h2c://1.2.3.4:50051",
+		},
 		{
 			name:        "URL is set",
 			serviceName: "service",
@@ -316,11 +710,105 @@ func TestGetServiceURL(t *testing.T) {
 			},
 			expectedUrl: "http://test.com:1234",
 		},
+		{
+			name:        "Port 443 without inference",
+			serviceName: "service",
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":   "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.port": "443",
+				},
+			},
+			expectedUrl: "http://1.2.3.4:443",
+		},
+		{
+			name:        "Port 8443 infers https",
+			serviceName: "service",
+			opts:        Options{InferScheme: true},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":   "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.port": "8443",
+				},
+			},
+			expectedUrl: "https://1.2.3.4:8443",
+		},
+		{
+			name:        "Port 8080 infers http",
+			serviceName: "service",
+			opts:        Options{InferScheme: true},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":   "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.port": "8080",
+				},
+			},
+			expectedUrl: "http://1.2.3.4:8080",
+		},
+		{
+			name:        "Explicit scheme wins over inference",
+			serviceName: "service",
+			opts:        Options{InferScheme: true},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":     "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.port":   "443",
+					"traefik.http.services.service.loadbalancer.server.scheme": "http",
+				},
+			},
+			expectedUrl: "http://1.2.3.4:443",
+		},
+		{
+			name:        "Default scheme https",
+			serviceName: "service",
+			opts:        Options{DefaultScheme: "https"},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip": "1.2.3.4",
+				},
+			},
+			expectedUrl: "https://1.2.3.4:443",
+		},
+		{
+			name:        "Default scheme h2c",
+			serviceName: "service",
+			opts:        Options{DefaultScheme: "h2c"},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip": "1.2.3.4",
+				},
+			},
+			expectedUrl: "h2c://1.2.3.4:80",
+		},
+		{
+			name:        "Scheme label overrides default scheme",
+			serviceName: "service",
+			opts:        Options{DefaultScheme: "https"},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":     "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.scheme": "http",
+				},
+			},
+			expectedUrl: "http://1.2.3.4:80",
+		},
+		{
+			name:        "Inferred scheme wins over default scheme",
+			serviceName: "service",
+			opts:        Options{DefaultScheme: "https", InferScheme: true},
+			service: internal.Service{
+				Config: map[string]string{
+					"traefik.http.services.service.loadbalancer.server.ip":   "1.2.3.4",
+					"traefik.http.services.service.loadbalancer.server.port": "8080",
+				},
+			},
+			expectedUrl: "http://1.2.3.4:8080",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			url := getServiceURL(tt.service, tt.serviceName, tt.nodeName)
+			url := getServiceURL(tt.service, tt.serviceName, tt.nodeName, tt.opts)
 			if url != tt.expectedUrl {
 				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
 			}
@@ -330,11 +818,11 @@ func TestGetServiceURL(t *testing.T) {
 
 func TestHandleRouterTLS_ArrayDomains(t *testing.T) {
 	tests := []struct {
-		name           string
-		config         map[string]string
-		expectedMain   []string
-		expectedSANs   [][]string
-		expectNil      bool
+		name         string
+		config       map[string]string
+		expectedMain []string
+		expectedSANs [][]string
+		expectNil    bool
 	}{
 		{
 			name: "Array syntax with main and sans",
@@ -394,3 +882,4255 @@ func TestHandleRouterTLS_ArrayDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateConfiguration_LabelPassthrough(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                                "true",
+					"traefik.http.routers.app.rule":                                 "Host(`app.example.com`)",
+					"traefik.http.services.app.loadbalancer.healthcheck.path":       "/health",
+					"traefik.http.services.app.loadbalancer.healthcheck.scheme":     "https",
+					"traefik.http.services.app.loadbalancer.sticky.cookie.samesite": "strict",
+					"traefik.http.middlewares.strip.stripprefix.prefixes":           "/api,/v1",
+					"traefik.http.middlewares.strip.stripprefix.forceslash":         "true",
+					"traefik.http.middlewares.zip.compress":                         "true",
+					"traefik.http.middlewares.ratelimit.ratelimit.average":          "100",
+					"traefik.http.middlewares.broken.ratelimit.average":             "lots",
+					"traefik.http.middlewares.unknown.doesnotexist.option":          "x",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	svc := config.HTTP.Services["app"]
+	if svc == nil || svc.LoadBalancer == nil {
+		t.Fatal("Expected service app with a load balancer")
+	}
+	if svc.LoadBalancer.HealthCheck == nil || svc.LoadBalancer.HealthCheck.Scheme != "https" {
+		t.Errorf("Expected healthcheck scheme https, got %+v", svc.LoadBalancer.HealthCheck)
+	}
+	if svc.LoadBalancer.HealthCheck != nil && svc.LoadBalancer.HealthCheck.Path != "/health" {
+		t.Errorf("Expected healthcheck path /health, got %s", svc.LoadBalancer.HealthCheck.Path)
+	}
+	if svc.LoadBalancer.Sticky == nil || svc.LoadBalancer.Sticky.Cookie == nil || svc.LoadBalancer.Sticky.Cookie.SameSite != "strict" {
+		t.Errorf("Expected sticky cookie samesite strict, got %+v", svc.LoadBalancer.Sticky)
+	}
+
+	strip := config.HTTP.Middlewares["strip"]
+	if strip == nil || strip.StripPrefix == nil {
+		t.Fatal("Expected stripprefix middleware")
+	}
+	if len(strip.StripPrefix.Prefixes) != 2 || strip.StripPrefix.Prefixes[1] != "/v1" {
+		t.Errorf("Expected prefixes [/api /v1], got %v", strip.StripPrefix.Prefixes)
+	}
+	if !strip.StripPrefix.ForceSlash {
+		t.Error("Expected forceSlash to be true")
+	}
+
+	if zip := config.HTTP.Middlewares["zip"]; zip == nil || zip.Compress == nil {
+		t.Error("Expected compress middleware")
+	}
+
+	if rl := config.HTTP.Middlewares["ratelimit"]; rl == nil || rl.RateLimit == nil || rl.RateLimit.Average != 100 {
+		t.Errorf("Expected ratelimit average 100, got %+v", rl)
+	}
+
+	if _, exists := config.HTTP.Middlewares["broken"]; exists {
+		t.Error("Expected middleware with invalid value to be skipped")
+	}
+	if _, exists := config.HTTP.Middlewares["unknown"]; exists {
+		t.Error("Expected middleware with unknown type to be skipped")
+	}
+}
+
+func TestGenerateConfiguration_WarnsWithoutReachableBackend(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:          101,
+				Name:        "noip",
+				Config:      map[string]string{"traefik.enable": "true"},
+				AgentStatus: "interface lookup failed: guest agent is not running",
+			},
+			{
+				ID:     102,
+				Name:   "withip",
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:   103,
+				Name: "pinned",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.http.services.pinned.loadbalancer.server.ip": "10.0.0.7",
+				},
+			},
+		},
+	}
+
+	BuildConfiguration(servicesMap, Options{})
+	out := buf.String()
+
+	if !strings.Contains(out, "noip (VMID: 101)") || !strings.Contains(out, "guest agent is not running") {
+		t.Errorf("Expected warning for service without reachable backend, got:\n%s", out)
+	}
+	if strings.Contains(out, "withip (VMID: 102) on node") || strings.Contains(out, "pinned (VMID: 103) on node") {
+		t.Errorf("Did not expect warnings for reachable services, got:\n%s", out)
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		expected string
+		wantErr  bool
+	}{
+		{name: "Valid endpoint", endpoint: "https://192.168.2.102:8006", expected: "https://192.168.2.102:8006"},
+		{name: "Trailing slash", endpoint: "https://pve.example.com:8006/", expected: "https://pve.example.com:8006"},
+		{name: "Missing scheme", endpoint: "192.168.2.102:8006", expected: "https://192.168.2.102:8006"},
+		{name: "Plain http", endpoint: "http://pve.local", expected: "http://pve.local"},
+		{name: "Unsupported scheme", endpoint: "ftp://pve.local", wantErr: true},
+		{name: "Missing host", endpoint: "https://", wantErr: true},
+		{name: "Malformed", endpoint: "https://pve.local:port", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, err := normalizeEndpoint(tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.endpoint) {
+					t.Errorf("Expected error to name %q, got %v", tt.endpoint, err)
+				}
+				return
+			}
+			if endpoint != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, endpoint)
+			}
+		})
+	}
+}
+
+func TestScanOptionsIncludeGuest(t *testing.T) {
+	opts := scanOptions{Pools: splitList("traefik, web ,")}
+	if len(opts.Pools) != 2 || opts.Pools[0] != "traefik" || opts.Pools[1] != "web" {
+		t.Fatalf("Expected pools [traefik web], got %v", opts.Pools)
+	}
+
+	if !opts.includeGuest(100) {
+		t.Error("Expected guests to be included before pool members are resolved")
+	}
+
+	opts.PoolMembers = map[uint64]bool{100: true}
+	if !opts.includeGuest(100) {
+		t.Error("Expected pool member 100 to be included")
+	}
+	if opts.includeGuest(101) {
+		t.Error("Expected guest 101 outside the pool to be excluded")
+	}
+}
+
+func TestGenerateConfiguration_DefaultEntrypoints(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "plain",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                  "true",
+					"traefik.http.routers.plain.rule": "Host(`plain.example.com`)",
+				},
+			},
+			{
+				ID:   101,
+				Name: "custom",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                          "true",
+					"traefik.http.routers.custom.rule":        "Host(`custom.example.com`)",
+					"traefik.http.routers.custom.entrypoints": "web",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{DefaultEntrypoints: []string{"websecure", "internal"}})
+
+	plain := config.HTTP.Routers["plain"]
+	if plain == nil || len(plain.EntryPoints) != 2 || plain.EntryPoints[0] != "websecure" || plain.EntryPoints[1] != "internal" {
+		t.Errorf("Expected default entrypoints [websecure internal], got %+v", plain)
+	}
+
+	custom := config.HTTP.Routers["custom"]
+	if custom == nil || len(custom.EntryPoints) != 1 || custom.EntryPoints[0] != "web" {
+		t.Errorf("Expected label entrypoints [web] to override defaults, got %+v", custom)
+	}
+}
+
+func TestGenerateConfiguration_UniqueDefaultKeys(t *testing.T) {
+	// A VM and a container with the same name and VMID on different nodes
+	// previously both produced the key "web-100".
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:     100,
+				Name:   "web",
+				Type:   internal.GuestTypeVM,
+				IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+		"node2": {
+			{
+				ID:     100,
+				Name:   "web",
+				Type:   internal.GuestTypeContainer,
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	expected := map[string]string{
+		"vm-node1-web-100":  "http://10.0.0.5:80",
+		"lxc-node2-web-100": "http://10.0.0.6:80",
+	}
+	if len(config.HTTP.Services) != len(expected) {
+		t.Fatalf("Expected %d services, got %d", len(expected), len(config.HTTP.Services))
+	}
+	for key, url := range expected {
+		svc := config.HTTP.Services[key]
+		if svc == nil || len(svc.LoadBalancer.Servers) != 1 || svc.LoadBalancer.Servers[0].URL != url {
+			t.Errorf("Expected service %s with server %s, got %+v", key, url, svc)
+		}
+		router := config.HTTP.Routers[key]
+		if router == nil || router.Service != key {
+			t.Errorf("Expected router %s targeting service %s, got %+v", key, key, router)
+		}
+	}
+}
+
+func TestGenerateConfiguration_SharedServiceName(t *testing.T) {
+	newGuest := func(id uint64, ip string) internal.Service {
+		return internal.Service{
+			ID:   id,
+			Name: fmt.Sprintf("web-%d", id),
+			Type: internal.GuestTypeVM,
+			IPs:  []internal.IP{{Address: ip, AddressType: "ipv4"}},
+			Config: map[string]string{
+				"traefik.enable":                                     "true",
+				"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+				"traefik.http.services.web.loadbalancer.server.port": "8080",
+			},
+		}
+	}
+	servicesMap := map[string][]internal.Service{
+		"node1": {newGuest(100, "10.0.0.5")},
+		"node2": {newGuest(101, "10.0.0.6")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	svc := config.HTTP.Services["web"]
+	if svc == nil || len(svc.LoadBalancer.Servers) != 2 {
+		t.Fatalf("Expected shared service web with 2 servers, got %+v", svc)
+	}
+	if svc.LoadBalancer.Servers[0].URL != "http://10.0.0.5:8080" || svc.LoadBalancer.Servers[1].URL != "http://10.0.0.6:8080" {
+		t.Errorf("Unexpected servers %+v", svc.LoadBalancer.Servers)
+	}
+	if len(config.HTTP.Routers) != 1 {
+		t.Errorf("Expected a single router, got %d", len(config.HTTP.Routers))
+	}
+}
+
+func TestApplyServiceOptions_PassHostHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		expected bool
+	}{
+		{name: "Default", set: false, expected: true},
+		{name: "Disabled", value: "false", set: true, expected: false},
+		{name: "Enabled", value: "true", set: true, expected: true},
+		{name: "Invalid falls back to default", value: "maybe", set: true, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := internal.Service{Config: map[string]string{}}
+			if tt.set {
+				service.Config["traefik.http.services.app.loadbalancer.passhostheader"] = tt.value
+			}
+			lb := &dynamic.ServersLoadBalancer{PassHostHeader: boolPtr(true)}
+			applyServiceOptions(lb, service, "app")
+			if lb.PassHostHeader == nil || *lb.PassHostHeader != tt.expected {
+				t.Errorf("Expected PassHostHeader %v, got %v", tt.expected, lb.PassHostHeader)
+			}
+		})
+	}
+}
+
+func TestApplyServiceOptions_FlushInterval(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "100ms", expected: "100ms"},
+		{value: "-1", expected: "-1"},
+		{value: "soon", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			service := internal.Service{Config: map[string]string{
+				"traefik.http.services.app.loadbalancer.responseforwarding.flushinterval": tt.value,
+			}}
+			lb := &dynamic.ServersLoadBalancer{}
+			applyServiceOptions(lb, service, "app")
+			if tt.expected == "" {
+				if lb.ResponseForwarding != nil {
+					t.Errorf("Expected invalid flush interval to be skipped, got %+v", lb.ResponseForwarding)
+				}
+				return
+			}
+			if lb.ResponseForwarding == nil || lb.ResponseForwarding.FlushInterval != tt.expected {
+				t.Errorf("Expected flush interval %s, got %+v", tt.expected, lb.ResponseForwarding)
+			}
+		})
+	}
+}
+
+func TestGetServerURLs_PerAddressPorts(t *testing.T) {
+	service := internal.Service{
+		Name: "multi",
+		IPs: []internal.IP{
+			{Address: "10.0.0.5", AddressType: "ipv4"},
+			{Address: "10.0.1.5", AddressType: "ipv4"},
+			{Address: "10.0.2.5", AddressType: "ipv4"},
+		},
+		Config: map[string]string{
+			"traefik.http.services.multi.loadbalancer.server.port":          "8080",
+			"traefik.http.services.multi.loadbalancer.server.port.10.0.0.5": "8081",
+			"traefik.http.services.multi.loadbalancer.server.port.10.0.1.5": "9090",
+		},
+	}
+
+	urls := getServerURLs(service, "multi", "node1", Options{})
+	expected := []string{"http://10.0.0.5:8081", "http://10.0.1.5:9090"}
+	if len(urls) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, urls)
+	}
+	for i := range expected {
+		if urls[i] != expected[i] {
+			t.Errorf("Expected URL %s, got %s", expected[i], urls[i])
+		}
+	}
+
+	// Without per-address overrides the shared port applies to the first IP
+	delete(service.Config, "traefik.http.services.multi.loadbalancer.server.port.10.0.0.5")
+	delete(service.Config, "traefik.http.services.multi.loadbalancer.server.port.10.0.1.5")
+	urls = getServerURLs(service, "multi", "node1", Options{})
+	if len(urls) != 1 || urls[0] != "http://10.0.0.5:8080" {
+		t.Errorf("Expected [http://10.0.0.5:8080], got %v", urls)
+	}
+}
+
+func TestIsAgentNotReady(t *testing.T) {
+	notRunning := &internal.APIError{StatusCode: 500, Body: `{"data":null,"message":"QEMU guest agent is not running\n"}`}
+	if !isAgentNotReady(fmt.Errorf("wrapped: %w", notRunning)) {
+		t.Error("Expected guest agent not running error to be detected")
+	}
+
+	forbidden := &internal.APIError{StatusCode: 403, Body: `{"data":null}`}
+	if isAgentNotReady(forbidden) {
+		t.Error("Did not expect permission error to be treated as agent not ready")
+	}
+
+	if isAgentNotReady(errors.New("QEMU guest agent is not running")) {
+		t.Error("Did not expect an untyped error to be treated as agent not ready")
+	}
+}
+
+func TestScanServices_StopsOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/qemu"):
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"a","status":"running"},{"vmid":101,"name":"b","status":"running"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/config"):
+			configRequests++
+			// The provider is stopped while the first guest is being scanned
+			cancel()
+			fmt.Fprint(w, `{"data":{"description":""}}`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	client := internal.NewProxmoxClient(server.URL, "test@pam!test", "token", true, "info")
+	_, err := scanServices(client, ctx, "node1", scanOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if configRequests != 1 {
+		t.Errorf("Expected scan to stop after the first guest, got %d config requests", configRequests)
+	}
+}
+
+func TestGenerateConfiguration_RouterServiceReference(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                          "true",
+					"traefik.http.routers.ui.rule":                            "Host(`app.example.com`)",
+					"traefik.http.routers.ui.service":                         "frontend",
+					"traefik.http.routers.api.rule":                           "Host(`app.example.com`) && PathPrefix(`/api`)",
+					"traefik.http.routers.api.service":                        "backend",
+					"traefik.http.routers.typo.rule":                          "Host(`typo.example.com`)",
+					"traefik.http.routers.typo.service":                       "backedn",
+					"traefik.http.routers.external.rule":                      "Host(`ext.example.com`)",
+					"traefik.http.routers.external.service":                   "legacy@file",
+					"traefik.http.services.frontend.loadbalancer.server.port": "3000",
+					"traefik.http.services.backend.loadbalancer.server.port":  "8080",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	if config.HTTP.Routers["ui"].Service != "frontend" {
+		t.Errorf("Expected router ui to target frontend, got %s", config.HTTP.Routers["ui"].Service)
+	}
+	if config.HTTP.Routers["api"].Service != "backend" {
+		t.Errorf("Expected router api to target backend, got %s", config.HTTP.Routers["api"].Service)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Router typo") || !strings.Contains(out, "unknown service backedn") {
+		t.Errorf("Expected warning for unknown service reference, got:\n%s", out)
+	}
+	if strings.Contains(out, "unknown service legacy@file") || strings.Contains(out, "unknown service frontend") {
+		t.Errorf("Did not expect warnings for valid references, got:\n%s", out)
+	}
+}
+
+func TestApplyRouterOptions_Observability(t *testing.T) {
+	tests := []struct {
+		label string
+		value string
+		want  string
+	}{
+		{"accesslogs", "true", ""},
+		{"tracing", "true", ""},
+		{"accesslogs", "false", "Disabling observability.accesslogs of router web is not supported"},
+		{"tracing", "false", "Disabling observability.tracing of router web is not supported"},
+		{"tracing", "sometimes", `Invalid observability.tracing "sometimes" for router web`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label+"="+tt.value, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			router := &dynamic.Router{Rule: "Host(`web.example.com`)"}
+			service := internal.Service{Config: map[string]string{"traefik.http.routers.web.observability." + tt.label: tt.value}}
+			applyRouterOptions(router, service, "web")
+
+			if tt.want == "" && buf.Len() > 0 {
+				t.Errorf("Expected no warning, got:\n%s", buf.String())
+			}
+			if tt.want != "" && !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("Expected warning %q, got:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestGenerateConfiguration_ServersTransportRootCAs(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "secure",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                             "true",
+					"traefik.http.routers.secure.rule":                           "Host(`secure.example.com`)",
+					"traefik.http.services.secure.loadbalancer.server.scheme":    "https",
+					"traefik.http.services.secure.loadbalancer.serverstransport": "internal-ca",
+					"traefik.http.serverstransports.internal-ca.rootcas":         "/etc/traefik/ca.pem,/etc/traefik/ca2.pem",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	transport := config.HTTP.ServersTransports["internal-ca"]
+	if transport == nil {
+		t.Fatal("Expected servers transport internal-ca")
+	}
+	if len(transport.RootCAs) != 2 || transport.RootCAs[0] != "/etc/traefik/ca.pem" || transport.RootCAs[1] != "/etc/traefik/ca2.pem" {
+		t.Errorf("Expected root CAs to be set, got %v", transport.RootCAs)
+	}
+	if transport.InsecureSkipVerify {
+		t.Error("Did not expect insecureSkipVerify to be enabled")
+	}
+
+	svc := config.HTTP.Services["secure"]
+	if svc.LoadBalancer.ServersTransport != "internal-ca" {
+		t.Errorf("Expected service to use transport internal-ca, got %s", svc.LoadBalancer.ServersTransport)
+	}
+	if svc.LoadBalancer.Servers[0].URL != "https://10.0.0.5:443" {
+		t.Errorf("Expected https server URL, got %s", svc.LoadBalancer.Servers[0].URL)
+	}
+}
+
+func TestGenerateConfiguration_ServersTransportServerName(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "sni",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                                "true",
+					"traefik.http.routers.sni.rule":                                 "Host(`app.example.com`)",
+					"traefik.http.services.sni.loadbalancer.server.scheme":          "https",
+					"traefik.http.services.sni.loadbalancer.serverstransport":       "backend-sni",
+					"traefik.http.services.sni.loadbalancer.passhostheader":         "false",
+					"traefik.http.serverstransports.backend-sni.servername":         "App.Internal.Example.com",
+					"traefik.http.serverstransports.backend-sni.insecureskipverify": "true",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	transport := config.HTTP.ServersTransports["backend-sni"]
+	if transport == nil {
+		t.Fatal("Expected servers transport backend-sni")
+	}
+	if transport.ServerName != "App.Internal.Example.com" {
+		t.Errorf("Expected the server name to be kept verbatim, got %q", transport.ServerName)
+	}
+	if !transport.InsecureSkipVerify {
+		t.Error("Expected insecureSkipVerify to be combined with the server name")
+	}
+
+	lb := config.HTTP.Services["sni"].LoadBalancer
+	if lb.ServersTransport != "backend-sni" || lb.PassHostHeader == nil || *lb.PassHostHeader {
+		t.Errorf("Expected the service to use backend-sni without passing the host header, got %+v", lb)
+	}
+}
+
+func TestGetServerURLs_DisableHostnameFallback(t *testing.T) {
+	service := internal.Service{
+		ID:     100,
+		Name:   "noip",
+		Config: map[string]string{"traefik.enable": "true"},
+	}
+
+	urls := getServerURLs(service, "noip", "node1", Options{})
+	if len(urls) != 1 || urls[0] != "http://noip.node1:80" {
+		t.Errorf("Expected hostname fallback URL, got %v", urls)
+	}
+
+	urls = getServerURLs(service, "noip", "node1", Options{DisableHostnameFallback: true})
+	if len(urls) != 0 {
+		t.Errorf("Expected no server URLs with the fallback disabled, got %v", urls)
+	}
+
+	// Explicit backends do not depend on discovery
+	service.Config["traefik.http.services.noip.loadbalancer.server.ip"] = "10.0.0.9"
+	urls = getServerURLs(service, "noip", "node1", Options{DisableHostnameFallback: true})
+	if len(urls) != 1 || urls[0] != "http://10.0.0.9:80" {
+		t.Errorf("Expected explicit IP URL, got %v", urls)
+	}
+}
+
+func TestParseVMIDList(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []vmidRange
+		wantErr  bool
+	}{
+		{name: "Empty", input: "", expected: []vmidRange{}},
+		{name: "Single IDs", input: "100, 105", expected: []vmidRange{{100, 100}, {105, 105}}},
+		{name: "Range", input: "100-199", expected: []vmidRange{{100, 199}}},
+		{name: "Mixed", input: "100-199,250", expected: []vmidRange{{100, 199}, {250, 250}}},
+		{name: "Not a number", input: "abc", wantErr: true},
+		{name: "Reversed range", input: "199-100", wantErr: true},
+		{name: "Open range", input: "100-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := parseVMIDList(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVMIDList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(ranges) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ranges)
+			}
+			for i := range ranges {
+				if ranges[i] != tt.expected[i] {
+					t.Errorf("Expected %v, got %v", tt.expected[i], ranges[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScanOptionsIncludeGuest_VMIDs(t *testing.T) {
+	opts := scanOptions{
+		IncludeVMIDs: []vmidRange{{100, 199}},
+		ExcludeVMIDs: []vmidRange{{150, 150}},
+	}
+
+	tests := map[uint64]bool{100: true, 149: true, 150: false, 199: true, 200: false, 99: false}
+	for vmID, expected := range tests {
+		if opts.includeGuest(vmID) != expected {
+			t.Errorf("includeGuest(%d) = %v, want %v", vmID, !expected, expected)
+		}
+	}
+
+	excludeOnly := scanOptions{ExcludeVMIDs: []vmidRange{{150, 150}}}
+	if !excludeOnly.includeGuest(500) || excludeOnly.includeGuest(150) {
+		t.Error("Expected exclusions to apply without an include list")
+	}
+}
+
+func TestGenerateConfiguration_TCPHostSNI(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "db",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                   "true",
+					"traefik.tcp.routers.db.rule":                      "HostSNI(`db.example.com`)",
+					"traefik.tcp.routers.db.entrypoints":               "websecure",
+					"traefik.tcp.routers.db.tls.passthrough":           "true",
+					"traefik.tcp.routers.catchall.tls.passthrough":     "true",
+					"traefik.tcp.routers.catchall.rule":                "ClientIP(`10.0.0.0/8`)",
+					"traefik.tcp.services.db.loadbalancer.server.port": "5432",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	router := config.TCP.Routers["db"]
+	if router == nil {
+		t.Fatal("Expected TCP router db")
+	}
+	if router.Rule != "HostSNI(`db.example.com`)" {
+		t.Errorf("Expected HostSNI rule, got %s", router.Rule)
+	}
+	if router.TLS == nil || !router.TLS.Passthrough {
+		t.Errorf("Expected TLS passthrough, got %+v", router.TLS)
+	}
+	if router.Service != "db" || len(router.EntryPoints) != 1 || router.EntryPoints[0] != "websecure" {
+		t.Errorf("Unexpected router %+v", router)
+	}
+
+	svc := config.TCP.Services["db"]
+	if svc == nil || len(svc.LoadBalancer.Servers) != 1 || svc.LoadBalancer.Servers[0].Address != "10.0.0.5:5432" {
+		t.Errorf("Expected TCP server 10.0.0.5:5432, got %+v", svc)
+	}
+
+	if _, exists := config.TCP.Routers["catchall"]; exists {
+		t.Error("Expected passthrough router without HostSNI rule to be skipped")
+	}
+	if !strings.Contains(buf.String(), "TLS passthrough requires a HostSNI rule") {
+		t.Errorf("Expected validation warning, got:\n%s", buf.String())
+	}
+
+	// TCP-only guests do not get a default HTTP router
+	if len(config.HTTP.Routers) != 0 || len(config.HTTP.Services) != 0 {
+		t.Errorf("Expected no HTTP configuration, got %d routers and %d services", len(config.HTTP.Routers), len(config.HTTP.Services))
+	}
+}
+
+func TestSelectBackendIPs(t *testing.T) {
+	ips := []internal.IP{
+		{Address: "192.168.1.10", AddressType: "ipv4", Interface: "eth0"},
+		{Address: "10.0.1.10", AddressType: "ipv4", Interface: "eth1"},
+	}
+
+	tests := []struct {
+		name             string
+		backendInterface string
+		expected         []string
+	}{
+		{name: "Not configured", backendInterface: "", expected: []string{"192.168.1.10", "10.0.1.10"}},
+		{name: "Interface name", backendInterface: "eth1", expected: []string{"10.0.1.10"}},
+		{name: "Subnet", backendInterface: "192.168.1.0/24", expected: []string{"192.168.1.10"}},
+		{name: "No match keeps all", backendInterface: "eth9", expected: []string{"192.168.1.10", "10.0.1.10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := selectBackendIPs(ips, tt.backendInterface)
+			if len(selected) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, selected)
+			}
+			for i, ip := range selected {
+				if ip.Address != tt.expected[i] {
+					t.Errorf("Expected %s, got %s", tt.expected[i], ip.Address)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateConfiguration_Draining(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "web1",
+				IPs:  []internal.IP{{Address: "10.0.0.1", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                       "true",
+					"traefik.http.routers.web.rule":                        "Host(`web.example.com`)",
+					"traefik.http.services.web.loadbalancer.server.port":   "8080",
+					"traefik.http.services.web.loadbalancer.server.weight": "0",
+				},
+			},
+			{
+				ID:   101,
+				Name: "web2",
+				IPs:  []internal.IP{{Address: "10.0.0.2", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.http.services.web.loadbalancer.server.port": "8080",
+				},
+			},
+			{
+				ID:   102,
+				Name: "api",
+				IPs:  []internal.IP{{Address: "10.0.0.3", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                "true",
+					"traefik.drain":                 "true",
+					"traefik.http.routers.api.rule": "Host(`api.example.com`)",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	web := config.HTTP.Services["web"]
+	if web == nil || len(web.LoadBalancer.Servers) != 1 || web.LoadBalancer.Servers[0].URL != "http://10.0.0.2:8080" {
+		t.Errorf("Expected only the non-draining server, got %+v", web.LoadBalancer.Servers)
+	}
+
+	if _, exists := config.HTTP.Routers["api"]; !exists {
+		t.Error("Expected router of draining guest to be kept")
+	}
+	api := config.HTTP.Services["node1-api-102"]
+	if api == nil {
+		t.Fatal("Expected service of draining guest to be kept")
+	}
+	if len(api.LoadBalancer.Servers) != 0 {
+		t.Errorf("Expected no servers for draining guest, got %+v", api.LoadBalancer.Servers)
+	}
+}
+
+func TestQualifyUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		realm    string
+		expected string
+		wantErr  bool
+	}{
+		{name: "Qualified user", user: "traefik@pve", expected: "traefik@pve"},
+		{name: "Separate realm", user: "traefik", realm: "pam", expected: "traefik@pam"},
+		{name: "Matching realms", user: "traefik@pam", realm: "pam", expected: "traefik@pam"},
+		{name: "Conflicting realms", user: "traefik@pam", realm: "pve", wantErr: true},
+		{name: "Empty realm", user: "traefik@", wantErr: true},
+		{name: "No realm", user: "traefik", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := qualifyUser(tt.user, tt.realm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("qualifyUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if user != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, user)
+			}
+		})
+	}
+}
+
+func TestPollSummary(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{ID: 100, Name: "web", IPs: []internal.IP{{Address: "10.0.0.1"}}, Config: map[string]string{"traefik.enable": "true"}},
+			{ID: 101, Name: "off", Config: map[string]string{}},
+		},
+		"node2": {},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+	summary := pollSummary(servicesMap, config, 1500*time.Millisecond)
+
+	expected := "Poll complete: 2 nodes, 2 guests, 1 routers, 1 services in 1.5s"
+	if summary != expected {
+		t.Errorf("Expected %q, got %q", expected, summary)
+	}
+}
+
+func TestGenerateConfiguration_MultipleRouters(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                     "true",
+					"traefik.http.routers.public.rule":                   "Host(`app.example.com`)",
+					"traefik.http.routers.public.entrypoints":            "websecure",
+					"traefik.http.routers.admin.rule":                    "Host(`admin.example.com`)",
+					"traefik.http.routers.admin.middlewares":             "auth@file",
+					"traefik.http.routers.metrics.rule":                  "Host(`metrics.example.com`)",
+					"traefik.http.routers.metrics.service":               "prometheus@file",
+					"traefik.http.services.app.loadbalancer.server.port": "8080",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	if len(config.HTTP.Routers) != 3 {
+		t.Fatalf("Expected 3 routers, got %d", len(config.HTTP.Routers))
+	}
+	if len(config.HTTP.Services) != 1 {
+		t.Errorf("Expected 1 service, got %d", len(config.HTTP.Services))
+	}
+
+	public := config.HTTP.Routers["public"]
+	if public.Rule != "Host(`app.example.com`)" || public.Service != "app" || len(public.EntryPoints) != 1 || public.EntryPoints[0] != "websecure" {
+		t.Errorf("Unexpected public router %+v", public)
+	}
+	admin := config.HTTP.Routers["admin"]
+	if admin.Rule != "Host(`admin.example.com`)" || admin.Service != "app" || len(admin.Middlewares) != 1 || admin.Middlewares[0] != "auth@file" {
+		t.Errorf("Unexpected admin router %+v", admin)
+	}
+	if config.HTTP.Routers["metrics"].Service != "prometheus@file" {
+		t.Errorf("Expected metrics router to keep its service override, got %s", config.HTTP.Routers["metrics"].Service)
+	}
+}
+
+// fakeProxmoxAPI serves a fixed cluster from memory.
+type fakeProxmoxAPI struct {
+	nodes         []internal.NodeStatus
+	vms           map[string][]internal.VirtualMachine
+	containers    map[string][]internal.Container
+	descriptions  map[uint64]string
+	ips           map[uint64][]internal.IP
+	interfaceErrs map[uint64]error
+	configErrs    map[uint64]error
+	pools         map[string][]internal.PoolMember
+	nodeErrs      map[string]error
+	nodesErr      error
+	nodeCalls     int
+	ports         map[uint64][]int
+	portCalls     int
+	portsHang     bool
+	mu            sync.Mutex
+}
+
+func (f *fakeProxmoxAPI) GetVersion(ctx context.Context) (*internal.Version, error) {
+	return &internal.Version{Release: "8.2"}, nil
+}
+
+func (f *fakeProxmoxAPI) GetNodes(ctx context.Context) ([]internal.NodeStatus, error) {
+	f.nodeCalls++
+	if f.nodesErr != nil {
+		return nil, f.nodesErr
+	}
+	return f.nodes, nil
+}
+
+func (f *fakeProxmoxAPI) GetVirtualMachines(ctx context.Context, nodeName string) ([]internal.VirtualMachine, error) {
+	if err := f.nodeErrs[nodeName]; err != nil {
+		return nil, err
+	}
+	return f.vms[nodeName], nil
+}
+
+func (f *fakeProxmoxAPI) GetContainers(ctx context.Context, nodeName string) ([]internal.Container, error) {
+	return f.containers[nodeName], nil
+}
+
+func (f *fakeProxmoxAPI) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error) {
+	if err := f.configErrs[vmID]; err != nil {
+		return nil, err
+	}
+	return &internal.ParsedConfig{Description: f.descriptions[vmID]}, nil
+}
+
+func (f *fakeProxmoxAPI) GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error) {
+	return &internal.ParsedConfig{Description: f.descriptions[vmID]}, nil
+}
+
+func (f *fakeProxmoxAPI) GetVMNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error) {
+	return f.interfaces(vmID)
+}
+
+func (f *fakeProxmoxAPI) GetContainerNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error) {
+	return f.interfaces(vmID)
+}
+
+func (f *fakeProxmoxAPI) GetPool(ctx context.Context, poolID string) (*internal.Pool, error) {
+	members, exists := f.pools[poolID]
+	if !exists {
+		return nil, fmt.Errorf("pool %s does not exist", poolID)
+	}
+	return &internal.Pool{PoolID: poolID, Members: members}, nil
+}
+
+func (f *fakeProxmoxAPI) GetVMListeningPorts(ctx context.Context, nodeName string, vmID uint64) ([]int, error) {
+	f.mu.Lock()
+	f.portCalls++
+	f.mu.Unlock()
+	if f.portsHang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ports, exists := f.ports[vmID]
+	if !exists {
+		return nil, fmt.Errorf("agent exec is not permitted")
+	}
+	return ports, nil
+}
+
+func (f *fakeProxmoxAPI) interfaces(vmID uint64) (*internal.ParsedAgentInterfaces, error) {
+	if err := f.interfaceErrs[vmID]; err != nil {
+		return nil, err
+	}
+	return &internal.ParsedAgentInterfaces{
+		Result: []internal.AgentInterface{{Name: "eth0", IPAddresses: f.ips[vmID]}},
+	}, nil
+}
+
+func newFakeCluster() *fakeProxmoxAPI {
+	return &fakeProxmoxAPI{
+		nodes: []internal.NodeStatus{{Node: "node1"}, {Node: "node2"}},
+		vms: map[string][]internal.VirtualMachine{
+			"node1": {
+				{VMID: 100, Name: "web", Status: "running"},
+				{VMID: 101, Name: "stopped", Status: "stopped"},
+				{VMID: 102, Name: "booting", Status: "running"},
+			},
+		},
+		containers: map[string][]internal.Container{
+			"node2": {{VMID: 200, Name: "db", Status: "running"}},
+		},
+		descriptions: map[uint64]string{
+			100: "traefik.enable=true\ntraefik.http.routers.web.rule=Host(`web.example.com`)",
+			101: "traefik.enable=true",
+			102: "traefik.enable=true",
+			200: "traefik.enable=true",
+		},
+		ips: map[uint64][]internal.IP{
+			100: {{Address: "10.0.0.1", AddressType: "ipv4"}, {Address: "127.0.0.1", AddressType: "ipv4"}},
+			200: {{Address: "10.0.0.2", AddressType: "inet"}},
+		},
+		interfaceErrs: map[uint64]error{
+			102: &internal.APIError{StatusCode: 500, Endpoint: "/nodes/node1/qemu/102/agent/network-get-interfaces", Body: "QEMU guest agent is not running"},
+		},
+		pools: map[string][]internal.PoolMember{
+			"prod": {{VMID: 200, Node: "node2", Type: "lxc"}},
+		},
+	}
+}
+
+func TestGetServiceMap_FakeClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     scanOptions
+		expected map[string][]uint64
+		wantErr  bool
+	}{
+		{
+			name:     "All running guests",
+			opts:     scanOptions{},
+			expected: map[string][]uint64{"node1": {100, 102}, "node2": {200}},
+		},
+		{
+			name:     "Skip guests without agent",
+			opts:     scanOptions{SkipAgentNotReady: true},
+			expected: map[string][]uint64{"node1": {100}, "node2": {200}},
+		},
+		{
+			name:     "Pool filter",
+			opts:     scanOptions{Pools: []string{"prod"}},
+			expected: map[string][]uint64{"node1": {}, "node2": {200}},
+		},
+		{
+			name:     "Excluded VMIDs",
+			opts:     scanOptions{ExcludeVMIDs: []vmidRange{{100, 199}}},
+			expected: map[string][]uint64{"node1": {}, "node2": {200}},
+		},
+		{
+			name:    "Unknown pool",
+			opts:    scanOptions{Pools: []string{"missing"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servicesMap, err := getServiceMap(newFakeCluster(), context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for nodeName, expectedIDs := range tt.expected {
+				services := servicesMap[nodeName]
+				if len(services) != len(expectedIDs) {
+					t.Fatalf("Expected %d services on %s, got %+v", len(expectedIDs), nodeName, services)
+				}
+				for i, service := range services {
+					if service.ID != expectedIDs[i] {
+						t.Errorf("Expected VMID %d on %s, got %d", expectedIDs[i], nodeName, service.ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestScanServices_FakeClient(t *testing.T) {
+	services, err := scanServices(newFakeCluster(), context.Background(), "node1", scanOptions{})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+
+	web := services[0]
+	if web.Type != internal.GuestTypeVM || web.Config["traefik.http.routers.web.rule"] != "Host(`web.example.com`)" {
+		t.Errorf("Unexpected service %+v", web)
+	}
+	if len(web.IPs) != 1 || web.IPs[0].Address != "10.0.0.1" {
+		t.Errorf("Expected loopback to be filtered, got %+v", web.IPs)
+	}
+
+	booting := services[1]
+	if len(booting.IPs) != 0 || !strings.Contains(booting.AgentStatus, "interface lookup failed") {
+		t.Errorf("Expected agent failure to be recorded, got %+v", booting)
+	}
+
+	config := BuildConfiguration(map[string][]internal.Service{"node1": services}, Options{})
+	svc := config.HTTP.Services[config.HTTP.Routers["web"].Service]
+	if svc == nil || svc.LoadBalancer.Servers[0].URL != "http://10.0.0.1:80" {
+		t.Errorf("Unexpected service %+v", svc)
+	}
+}
+
+func TestGetServiceURL_MixedCaseNames(t *testing.T) {
+	// Label keys are lowercased when parsed, so names with capitals, such as
+	// the default names derived from a guest name, are looked up lowercased
+	pc := internal.ParsedConfig{Description: "Traefik.Enable=true\nTraefik.HTTP.Services.Node1-MyApp-100.LoadBalancer.Server.Port=8080\ntraefik.http.services.node1-myapp-100.loadbalancer.server.Scheme=https"}
+	service := internal.NewService(100, "MyApp", pc.GetTraefikMap())
+	service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+
+	if got := getServiceURL(service, "node1-MyApp-100", "node1", Options{}); got != "https://10.0.0.5:8080" {
+		t.Errorf("getServiceURL() = %s, want the mixed-case labels to apply", got)
+	}
+
+	service.Config["traefik.http.routers.node1-myapp-100.rule"] = "Host(`myapp.example.com`)"
+	if got := getRouterRule(service, "node1-MyApp-100", Options{}); got != "Host(`myapp.example.com`)" {
+		t.Errorf("getRouterRule() = %s, want the rule label of the lowercased router name", got)
+	}
+}
+
+func TestBuildConfiguration_MixedCaseReferences(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	pc := internal.ParsedConfig{Description: strings.Join([]string{
+		"traefik.enable=true",
+		"traefik.http.routers.MyApp.rule=Host(`myapp.example.com`)",
+		"traefik.http.routers.MyApp.service=MyApp",
+		"traefik.http.routers.MyApp.middlewares=StripApp,auth@file",
+		"traefik.http.middlewares.StripApp.stripprefix.prefixes=/app",
+		"traefik.http.services.MyApp.loadbalancer.server.port=8080",
+		"traefik.http.services.Canary.weighted.services=MyApp:90,Legacy@file:10",
+	}, "\n")}
+	service := internal.NewService(100, "web", pc.GetTraefikMap())
+	service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+
+	config := BuildConfiguration(map[string][]internal.Service{"node1": {service}}, Options{})
+
+	router, exists := config.HTTP.Routers["myapp"]
+	if !exists {
+		t.Fatalf("Expected router myapp, got %v", config.HTTP.Routers)
+	}
+	if router.Service != "myapp" {
+		t.Errorf("Expected the service reference to be lowercased, got %q", router.Service)
+	}
+	if !reflect.DeepEqual(router.Middlewares, []string{"stripapp", "auth@file"}) {
+		t.Errorf("Expected lowercased middleware references, got %v", router.Middlewares)
+	}
+	if _, exists := config.HTTP.Middlewares["stripapp"]; !exists {
+		t.Errorf("Expected middleware stripapp, got %v", config.HTTP.Middlewares)
+	}
+	canary, exists := config.HTTP.Services["canary"]
+	if !exists || canary.Weighted == nil {
+		t.Fatalf("Expected weighted service canary, got %v", config.HTTP.Services)
+	}
+	if canary.Weighted.Services[0].Name != "myapp" || canary.Weighted.Services[1].Name != "Legacy@file" {
+		t.Errorf("Expected only same-provider references to be lowercased, got %+v", canary.Weighted.Services)
+	}
+	if strings.Contains(buf.String(), "unknown service") {
+		t.Errorf("Expected every reference to resolve, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildConfiguration_MiddlewareCollision(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	guest := func(id uint64, name, prefix string) internal.Service {
+		service := internal.NewService(id, name, map[string]string{
+			"traefik.enable": "true",
+			"traefik.http.middlewares.strip.stripprefix.prefixes": prefix,
+		})
+		service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+		return service
+	}
+	servicesMap := map[string][]internal.Service{
+		"node1": {guest(100, "first", "/first"), guest(101, "second", "/second")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	middleware, exists := config.HTTP.Middlewares["strip"]
+	if !exists || middleware.StripPrefix == nil || !reflect.DeepEqual(middleware.StripPrefix.Prefixes, []string{"/first"}) {
+		t.Errorf("Expected the first definition to be kept, got %+v", middleware)
+	}
+	if !strings.Contains(buf.String(), "WARN: Middleware strip is defined by both first (ID: 100) on node node1 and second (ID: 101) on node node1") {
+		t.Errorf("Expected a collision warning, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildConfiguration_ServersTransportCollision(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	guest := func(id uint64, name, serverName string) internal.Service {
+		service := internal.NewService(id, name, map[string]string{
+			"traefik.enable": "true",
+			"traefik.http.serverstransports.backend.servername": serverName,
+		})
+		service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+		return service
+	}
+	servicesMap := map[string][]internal.Service{
+		"node1": {guest(100, "first", "first.internal"), guest(101, "second", "second.internal")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	transport, exists := config.HTTP.ServersTransports["backend"]
+	if !exists || transport.ServerName != "first.internal" {
+		t.Errorf("Expected the first definition to be kept, got %+v", transport)
+	}
+	if !strings.Contains(buf.String(), "WARN: Servers transport backend is defined by both first (ID: 100) on node node1 and second (ID: 101) on node node1") {
+		t.Errorf("Expected a collision warning, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildConfiguration_NormalizeNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"web", "web"},
+		{"My App_01", "my-app-01"},
+		{"  Web--Server.lab ", "web-server-lab"},
+		{"日本", "日本"},
+	}
+	for _, tt := range tests {
+		if got := normalizeName(tt.name); got != tt.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	service := internal.NewService(100, "My App", map[string]string{"traefik.enable": "true"})
+	service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	servicesMap := map[string][]internal.Service{"node1": {service}}
+
+	config := BuildConfiguration(servicesMap, Options{})
+	if router, exists := config.HTTP.Routers["node1-My App-100"]; !exists || router.Rule != "Host(`My App`)" {
+		t.Errorf("Expected the raw name without normalizeNames, got %v", config.HTTP.Routers)
+	}
+
+	config = BuildConfiguration(servicesMap, Options{NormalizeNames: true})
+	router, exists := config.HTTP.Routers["node1-my-app-100"]
+	if !exists {
+		t.Fatalf("Expected the normalized name in the default router name, got %v", config.HTTP.Routers)
+	}
+	if router.Rule != "Host(`my-app`)" || router.Service != "node1-my-app-100" {
+		t.Errorf("Expected the normalized name in the default rule and service, got %+v", router)
+	}
+	if service.Name != "My App" {
+		t.Errorf("Expected the raw name to be kept, got %q", service.Name)
+	}
+}
+
+func TestBuildConfiguration_CloneGroups(t *testing.T) {
+	pattern, err := parseCloneGroupPattern(`^(.+)-clone\d+$`)
+	if err != nil {
+		t.Fatalf("parseCloneGroupPattern() error = %v", err)
+	}
+
+	newGuest := func(id uint64, name, address string) internal.Service {
+		service := internal.NewService(id, name, map[string]string{"traefik.enable": "true"})
+		service.Type = "vm"
+		service.IPs = []internal.IP{{Address: address, AddressType: "ipv4"}}
+		return service
+	}
+	servicesMap := map[string][]internal.Service{
+		"node1": {newGuest(100, "web-clone1", "10.0.0.5"), newGuest(101, "db", "10.0.0.7")},
+		"node2": {newGuest(200, "web-clone2", "10.0.0.6")},
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	config := BuildConfiguration(servicesMap, Options{CloneGroupPattern: pattern})
+
+	router, exists := config.HTTP.Routers["vm-web"]
+	if !exists {
+		t.Fatalf("Expected one router for the clone group, got %v", config.HTTP.Routers)
+	}
+	if router.Rule != "Host(`web`)" || router.Service != "vm-web" {
+		t.Errorf("Expected the group name in the rule and service, got %+v", router)
+	}
+	service, exists := config.HTTP.Services["vm-web"]
+	if !exists || len(service.LoadBalancer.Servers) != 2 {
+		t.Fatalf("Expected the servers of both clones in one service, got %v", config.HTTP.Services)
+	}
+	if service.LoadBalancer.Servers[0].URL != "http://10.0.0.5:80" || service.LoadBalancer.Servers[1].URL != "http://10.0.0.6:80" {
+		t.Errorf("Unexpected servers %+v", service.LoadBalancer.Servers)
+	}
+	if _, exists := config.HTTP.Routers["vm-node1-db-101"]; !exists {
+		t.Errorf("Expected guests not matching the pattern to keep their default name, got %v", config.HTTP.Routers)
+	}
+	if strings.Contains(buf.String(), "WARN: Router vm-web") {
+		t.Errorf("Expected no collision warning for clones, got:\n%s", buf.String())
+	}
+
+	// Clone group names are normalized like guest names
+	if got := guestName(internal.Service{Name: "My App-clone3"}, Options{CloneGroupPattern: pattern, NormalizeNames: true}); got != "my-app" {
+		t.Errorf("guestName() = %q, want %q", got, "my-app")
+	}
+}
+
+func TestBuildConfiguration_ProviderPrefix(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                          "true",
+					"traefik.http.routers.app.rule":                           "Host(`app.example.com`)",
+					"traefik.http.routers.app.service":                        "app",
+					"traefik.http.routers.app.middlewares":                    "strip,auth@file",
+					"traefik.http.middlewares.strip.stripprefix.prefixes":     "/app",
+					"traefik.http.services.app.loadbalancer.server.port":      "8080",
+					"traefik.http.services.app.loadbalancer.serverstransport": "internal-ca",
+					"traefik.http.serverstransports.internal-ca.rootcas":      "/etc/traefik/ca.pem",
+					"traefik.tcp.routers.db.rule":                             "HostSNI(`db.example.com`)",
+					"traefik.tcp.services.db.loadbalancer.server.port":        "5432",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{ProviderPrefix: "proxmox-"})
+
+	router := config.HTTP.Routers["proxmox-app"]
+	if router == nil {
+		t.Fatalf("Expected prefixed router, got %v", config.HTTP.Routers)
+	}
+	if router.Service != "proxmox-app" {
+		t.Errorf("Expected prefixed service reference, got %s", router.Service)
+	}
+	if len(router.Middlewares) != 2 || router.Middlewares[0] != "proxmox-strip" || router.Middlewares[1] != "auth@file" {
+		t.Errorf("Expected local middleware to be prefixed, got %v", router.Middlewares)
+	}
+	if _, exists := config.HTTP.Middlewares["proxmox-strip"]; !exists {
+		t.Error("Expected prefixed middleware")
+	}
+	service := config.HTTP.Services["proxmox-app"]
+	if service == nil || service.LoadBalancer.ServersTransport != "proxmox-internal-ca" {
+		t.Errorf("Expected prefixed service and transport reference, got %+v", service)
+	}
+	if _, exists := config.HTTP.ServersTransports["proxmox-internal-ca"]; !exists {
+		t.Error("Expected prefixed servers transport")
+	}
+	if tcpRouter := config.TCP.Routers["proxmox-db"]; tcpRouter == nil || tcpRouter.Service != "proxmox-db" {
+		t.Errorf("Expected prefixed TCP router and service reference, got %+v", tcpRouter)
+	}
+	if _, exists := config.TCP.Services["proxmox-db"]; !exists {
+		t.Error("Expected prefixed TCP service")
+	}
+}
+
+func TestLogSelfTest(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	logSelfTest(newFakeCluster(), context.Background(), scanOptions{})
+
+	out := buf.String()
+	if !strings.Contains(out, "Self-test: 2 nodes (0 unreachable), 4 guests (3 running), 3 running with traefik.enable=true") {
+		t.Errorf("Unexpected self-test summary:\n%s", out)
+	}
+	if strings.Contains(out, "No running guest") {
+		t.Errorf("Did not expect a warning, got:\n%s", out)
+	}
+
+	buf.Reset()
+	cluster := newFakeCluster()
+	cluster.descriptions = map[uint64]string{}
+	logSelfTest(cluster, context.Background(), scanOptions{})
+	if !strings.Contains(buf.String(), "WARN: No running guest has traefik.enable=true") {
+		t.Errorf("Expected warning about missing enabled guests, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildConfiguration_TLSStores(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "certs",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.tls.stores.default.defaultcertificate.certfile": "/certs/wildcard.crt",
+					"traefik.tls.stores.default.defaultcertificate.keyfile":  "/certs/wildcard.key",
+					"traefik.tls.stores.other.defaultcertificate.certfile":   "/certs/other.crt",
+				},
+			},
+			{
+				ID:   101,
+				Name: "late",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.tls.stores.default.defaultgeneratedcert.resolver": "letsencrypt",
+				},
+			},
+		},
+		"node2": {
+			{
+				ID:   200,
+				Name: "broken",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.tls.stores.default.defaultcertificate.certfile": "/certs/broken.crt",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	want := tls.Store{DefaultCertificate: &tls.Certificate{CertFile: "/certs/wildcard.crt", KeyFile: "/certs/wildcard.key"}}
+	if store, exists := config.TLS.Stores["default"]; !exists || !reflect.DeepEqual(store, want) {
+		t.Errorf("Expected the default store of the first guest %+v, got %+v", want, config.TLS.Stores)
+	}
+	if len(config.TLS.Stores) != 1 {
+		t.Errorf("Expected only the default store, got %v", config.TLS.Stores)
+	}
+	for _, warning := range []string{
+		"TLS store other of certs (ID: 100) was skipped, Traefik only supports the default store",
+		"TLS store default is defined by both certs (ID: 100) on node node1 and late (ID: 101) on node node1",
+		"TLS store default of broken (ID: 200) is invalid and was skipped: defaultcertificate needs both certfile and keyfile",
+	} {
+		if !strings.Contains(buf.String(), warning) {
+			t.Errorf("Expected a warning containing %q, got %q", warning, buf.String())
+		}
+	}
+}
+
+func TestBuildConfiguration_TLSOptions(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "secure",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                          "true",
+					"traefik.http.routers.secure.rule":        "Host(`secure.example.com`)",
+					"traefik.http.routers.secure.tls.options": "modern",
+					"traefik.tls.options.modern.minversion":   "VersionTLS13",
+					"traefik.tls.options.modern.snistrict":    "true",
+					"traefik.tls.options.compat.minversion":   "VersionTLS12",
+					"traefik.tls.options.compat.ciphersuites": "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+					"traefik.tls.options.broken.minversion":   "TLS1.3",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	modern, exists := config.TLS.Options["modern"]
+	if !exists || modern.MinVersion != "VersionTLS13" || !modern.SniStrict {
+		t.Errorf("Unexpected TLS options modern: %+v", modern)
+	}
+	compat := config.TLS.Options["compat"]
+	if len(compat.CipherSuites) != 2 || compat.CipherSuites[1] != "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384" {
+		t.Errorf("Unexpected cipher suites: %v", compat.CipherSuites)
+	}
+	if _, exists := config.TLS.Options["broken"]; exists {
+		t.Error("Expected TLS options with an unknown version to be skipped")
+	}
+	if !strings.Contains(buf.String(), `unknown TLS version "TLS1.3"`) {
+		t.Errorf("Expected warning for unknown TLS version, got:\n%s", buf.String())
+	}
+	if config.HTTP.Routers["secure"].TLS.Options != "modern" {
+		t.Errorf("Expected router to reference TLS options modern, got %+v", config.HTTP.Routers["secure"].TLS)
+	}
+}
+
+func TestParsePollInterval(t *testing.T) {
+	tests := []struct {
+		name             string
+		value            string
+		allowFastPolling bool
+		expected         time.Duration
+		wantErr          bool
+	}{
+		{name: "Default", value: "30s", expected: 30 * time.Second},
+		{name: "Minimum", value: "5s", expected: 5 * time.Second},
+		{name: "Too fast", value: "2s", wantErr: true},
+		{name: "Fast polling allowed", value: "2s", allowFastPolling: true, expected: 2 * time.Second},
+		{name: "Zero", value: "0s", allowFastPolling: true, wantErr: true},
+		{name: "Invalid", value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pi, err := parsePollInterval(tt.value, tt.allowFastPolling)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePollInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if pi != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, pi)
+			}
+		})
+	}
+}
+
+func TestPollJitter(t *testing.T) {
+	jitter, err := parsePollJitter("10%")
+	if err != nil || jitter != 0.1 {
+		t.Fatalf("parsePollJitter() = %v, %v", jitter, err)
+	}
+	for _, invalid := range []string{"-5", "75", "often"} {
+		if _, err := parsePollJitter(invalid); err == nil {
+			t.Errorf("Expected error for poll jitter %q", invalid)
+		}
+	}
+
+	interval := 30 * time.Second
+	if got := jitteredInterval(interval, jitter, 0); got != 27*time.Second {
+		t.Errorf("Expected lower bound 27s, got %v", got)
+	}
+	if got := jitteredInterval(interval, jitter, 0.5); got != interval {
+		t.Errorf("Expected midpoint 30s, got %v", got)
+	}
+	if got := jitteredInterval(interval, jitter, 0.999); got < 32*time.Second || got > 33*time.Second {
+		t.Errorf("Expected upper bound close to 33s, got %v", got)
+	}
+
+	p := &Provider{pollInterval: interval}
+	if p.nextPollInterval() != interval {
+		t.Error("Expected no jitter by default")
+	}
+}
+
+func TestGetServiceMap_NodeCache(t *testing.T) {
+	cluster := newFakeCluster()
+	opts := scanOptions{Nodes: &nodeCache{refreshInterval: time.Minute}}
+
+	for i := 0; i < 3; i++ {
+		if _, err := getServiceMap(cluster, context.Background(), opts); err != nil {
+			t.Fatalf("getServiceMap() error = %v", err)
+		}
+	}
+	if cluster.nodeCalls != 1 {
+		t.Errorf("Expected nodes to be fetched once, got %d", cluster.nodeCalls)
+	}
+
+	opts.Nodes.expires = time.Now().Add(-time.Second)
+	if _, err := getServiceMap(cluster, context.Background(), opts); err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	if cluster.nodeCalls != 2 {
+		t.Errorf("Expected nodes to be fetched again after expiry, got %d", cluster.nodeCalls)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cluster.nodeErrs = map[string]error{"node1": errors.New("node offline")}
+	for i := 0; i < 2; i++ {
+		if _, err := getServiceMap(cluster, context.Background(), opts); err != nil {
+			t.Fatalf("getServiceMap() error = %v", err)
+		}
+	}
+	if cluster.nodeCalls != 3 {
+		t.Errorf("Expected nodes to be fetched again on the poll after a node failed, got %d", cluster.nodeCalls)
+	}
+
+	uncached := newFakeCluster()
+	for i := 0; i < 2; i++ {
+		if _, err := getServiceMap(uncached, context.Background(), scanOptions{Nodes: &nodeCache{}}); err != nil {
+			t.Fatalf("getServiceMap() error = %v", err)
+		}
+	}
+	if uncached.nodeCalls != 2 {
+		t.Errorf("Expected a zero refresh interval to disable caching, got %d calls", uncached.nodeCalls)
+	}
+}
+
+func TestGetLabels_LabelPrefix(t *testing.T) {
+	config := &internal.ParsedConfig{Description: "traefik.enable=true\npxtraefik.enable=true\npxtraefik.http.routers.web.rule=Host(`web.example.com`)"}
+
+	labels := getLabels(config, scanOptions{LabelPrefix: normalizeLabelPrefix("PXTraefik")})
+	want := map[string]string{"traefik.enable": "true", "traefik.http.routers.web.rule": "Host(`web.example.com`)"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("getLabels() = %v, want %v", labels, want)
+	}
+
+	if labels := getLabels(config, scanOptions{}); len(labels) != 1 || labels["traefik.enable"] != "true" {
+		t.Errorf("Expected the default prefix to read only traefik.* labels, got %v", labels)
+	}
+}
+
+func TestGetLabels_FieldSource(t *testing.T) {
+	config := &internal.ParsedConfig{
+		Description: "traefik.enable=false",
+		Fields:      map[string]string{"smbios1": "uuid=1234,serial=dHJhZWZpay5lbmFibGU9dHJ1ZQ==,base64=1"},
+	}
+
+	if labels := getLabels(config, scanOptions{LabelSource: labelSourceField}); labels["traefik.enable"] != "true" {
+		t.Errorf("Expected the field source to read smbios1 by default, got %v", labels)
+	}
+	if labels := getLabels(config, scanOptions{}); labels["traefik.enable"] != "false" {
+		t.Errorf("Expected the default source to read the notes, got %v", labels)
+	}
+}
+
+func TestGetServiceMap_OfflineNodes(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cluster := newFakeCluster()
+	cluster.nodes = []internal.NodeStatus{{Node: "node1", Status: "offline"}, {Node: "node2", Status: "online"}}
+	cluster.nodeErrs = map[string]error{"node1": errors.New("no route to host")}
+	opts := scanOptions{Nodes: &nodeCache{refreshInterval: time.Minute}}
+
+	for i := 0; i < 2; i++ {
+		servicesMap, err := getServiceMap(cluster, context.Background(), opts)
+		if err != nil {
+			t.Fatalf("getServiceMap() error = %v", err)
+		}
+		if _, exists := servicesMap["node1"]; exists {
+			t.Error("Expected offline node1 to be skipped")
+		}
+		if len(servicesMap["node2"]) != 1 {
+			t.Errorf("Expected node2 to be scanned, got %v", servicesMap["node2"])
+		}
+	}
+	if strings.Contains(buf.String(), "Error scanning") {
+		t.Errorf("Expected no scan errors for the offline node, got:\n%s", buf.String())
+	}
+	if n := strings.Count(buf.String(), "Node node1 is offline"); n != 1 {
+		t.Errorf("Expected the offline node to be logged once, got %d times:\n%s", n, buf.String())
+	}
+	if cluster.nodeCalls != 2 {
+		t.Errorf("Expected the node list not to be cached while a node is offline, got %d calls", cluster.nodeCalls)
+	}
+
+	cluster.nodes[0].Status = "online"
+	cluster.nodeErrs = nil
+	servicesMap, err := getServiceMap(cluster, context.Background(), opts)
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	if _, exists := servicesMap["node1"]; !exists {
+		t.Error("Expected node1 to be scanned once it is back online")
+	}
+	if !strings.Contains(buf.String(), "Node node1 is back online") {
+		t.Errorf("Expected the node's return to be logged, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildMiddlewares_CompressAndRetry(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	service := internal.Service{
+		ID:   100,
+		Name: "app",
+		Config: map[string]string{
+			"traefik.http.middlewares.zip.compress":                    "true",
+			"traefik.http.middlewares.retry.retry.attempts":            "4",
+			"traefik.http.middlewares.retry.retry.initialinterval":     "100ms",
+			"traefik.http.middlewares.strip.stripprefix.prefixes":      "/api",
+			"traefik.http.middlewares.noretry.retry.attempts":          "0",
+			"traefik.http.middlewares.slowretry.retry.attempts":        "2",
+			"traefik.http.middlewares.slowretry.retry.initialinterval": "soon",
+			"traefik.http.middlewares.combined.compress":               "true",
+			"traefik.http.middlewares.combined.retry.attempts":         "3",
+			"traefik.http.middlewares.disabled.compress":               "false",
+		},
+	}
+
+	middlewares := buildMiddlewares(service)
+
+	if zip := middlewares["zip"]; zip == nil || zip.Compress == nil {
+		t.Error("Expected compress middleware")
+	}
+	retry := middlewares["retry"]
+	if retry == nil || retry.Retry == nil || retry.Retry.Attempts != 4 || retry.Retry.InitialInterval != "100ms" {
+		t.Errorf("Expected retry middleware with 4 attempts and 100ms initial interval, got %+v", retry)
+	}
+	if strip := middlewares["strip"]; strip == nil || strip.StripPrefix == nil {
+		t.Error("Expected stripprefix middleware next to compress and retry")
+	}
+
+	for _, name := range []string{"noretry", "slowretry", "combined", "disabled"} {
+		if _, exists := middlewares[name]; exists {
+			t.Errorf("Expected invalid middleware %s to be skipped", name)
+		}
+	}
+	if !strings.Contains(buf.String(), "declares several types") {
+		t.Errorf("Expected a warning about several types, got %q", buf.String())
+	}
+}
+
+func TestLabelSuggestions(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"traefik.http.router.rule", "traefik.http.routers.<name>.rule"},
+		{"traefik.http.router.web.rule", "traefik.http.routers.web.rule"},
+		{"traefik.http.servics.web.loadbalancer.server.port", "traefik.http.services.web.loadbalancer.server.port"},
+		{"traefik.http.middleware.auth.basicauth.users", "traefik.http.middlewares.auth.basicauth.users"},
+		{"traefik.tcp.router.db.rule", "traefik.tcp.routers.db.rule"},
+		{"traefik.enabled", "traefik.enable"},
+		{"traefik.grup", "traefik.group"},
+		{"traefik.docker.network", ""},
+		{"traefik.foo", ""},
+	}
+	for _, tt := range tests {
+		if got := suggestLabel(tt.key); got != tt.want {
+			t.Errorf("suggestLabel(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	service := internal.Service{
+		ID:   100,
+		Name: "web",
+		Config: map[string]string{
+			"traefik.enable":                      "true",
+			"traefik.http.router.web.rule":        "Host(`web.example.com`)",
+			"traefik.http.routers.web.middlewars": "auth",
+			"traefik.http.routers.web.entrypoint": "websecure",
+		},
+	}
+	logUnhandledLabels(service)
+	buildMiddlewares(service)
+	config := &dynamic.Router{}
+	applyLabelPassthrough(config, service.Config, "traefik.http.routers.web.", isHandledRouterLabel)
+
+	for _, warning := range []string{
+		"Label traefik.http.router.web.rule on web (ID: 100) is not supported and was ignored, did you mean traefik.http.routers.web.rule?",
+		`unknown option "middlewars", did you mean "middlewares"?`,
+	} {
+		if !strings.Contains(buf.String(), warning) {
+			t.Errorf("Expected a warning containing %q, got %q", warning, buf.String())
+		}
+	}
+}
+
+func TestBuildMiddlewares_RateLimitAndInFlightReq(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	service := internal.Service{
+		ID:   100,
+		Name: "api",
+		Config: map[string]string{
+			"traefik.http.middlewares.limit.ratelimit.average":         "100",
+			"traefik.http.middlewares.limit.ratelimit.burst":           "50",
+			"traefik.http.middlewares.limit.ratelimit.period":          "1m",
+			"traefik.http.middlewares.inflight.inflightreq.amount":     "10",
+			"traefik.http.middlewares.noaverage.ratelimit.burst":       "50",
+			"traefik.http.middlewares.negativeburst.ratelimit.average": "10",
+			"traefik.http.middlewares.negativeburst.ratelimit.burst":   "-1",
+			"traefik.http.middlewares.badperiod.ratelimit.average":     "10",
+			"traefik.http.middlewares.badperiod.ratelimit.period":      "often",
+			"traefik.http.middlewares.zeroinflight.inflightreq.amount": "0",
+			"traefik.http.middlewares.notanumber.ratelimit.average":    "lots",
+			"traefik.http.routers.api.middlewares":                     "limit,inflight",
+			"traefik.http.routers.api.rule":                            "Host(`api.example.com`)",
+		},
+	}
+
+	middlewares := buildMiddlewares(service)
+
+	if limit := middlewares["limit"]; limit == nil || !reflect.DeepEqual(limit.RateLimit, &dynamic.RateLimit{Average: 100, Burst: 50, Period: "1m"}) {
+		t.Errorf("Expected ratelimit middleware, got %+v", limit)
+	}
+	if inflight := middlewares["inflight"]; inflight == nil || !reflect.DeepEqual(inflight.InFlightReq, &dynamic.InFlightReq{Amount: 10}) {
+		t.Errorf("Expected inflightreq middleware, got %+v", inflight)
+	}
+	for _, name := range []string{"noaverage", "negativeburst", "badperiod", "zeroinflight", "notanumber"} {
+		if _, exists := middlewares[name]; exists {
+			t.Errorf("Expected invalid middleware %s to be skipped", name)
+		}
+	}
+	for _, warning := range []string{
+		"ratelimit.average must be a positive number",
+		"ratelimit.burst must not be negative",
+		`invalid ratelimit.period "often"`,
+		"inflightreq.amount must be a positive number",
+		`invalid integer "lots"`,
+	} {
+		if !strings.Contains(buf.String(), warning) {
+			t.Errorf("Expected a warning containing %q, got %q", warning, buf.String())
+		}
+	}
+
+	service.Config["traefik.enable"] = "true"
+	service.IPs = []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	config := BuildConfiguration(map[string][]internal.Service{"node1": {service}}, Options{})
+	if router := config.HTTP.Routers["api"]; router == nil || !reflect.DeepEqual(router.Middlewares, []string{"limit", "inflight"}) {
+		t.Errorf("Expected the middlewares to be attached to the router, got %+v", router)
+	}
+}
+
+func TestBuildMiddlewares_Buffering(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	service := internal.Service{
+		ID:   100,
+		Name: "uploads",
+		Config: map[string]string{
+			"traefik.http.middlewares.upload.buffering.maxrequestbodybytes":     "104857600",
+			"traefik.http.middlewares.upload.buffering.memrequestbodybytes":     "2097152",
+			"traefik.http.middlewares.upload.buffering.maxresponsebodybytes":    "0",
+			"traefik.http.middlewares.upload.buffering.retryexpression":         "IsNetworkError() && Attempts() < 2",
+			"traefik.http.middlewares.upload.buffering.maxrequestsize":          "10",
+			"traefik.http.middlewares.negative.buffering.maxrequestbodybytes":   "-1",
+			"traefik.http.middlewares.inverted.buffering.maxrequestbodybytes":   "1024",
+			"traefik.http.middlewares.inverted.buffering.memrequestbodybytes":   "4096",
+			"traefik.http.middlewares.notanumber.buffering.memrequestbodybytes": "1MB",
+		},
+	}
+
+	middlewares := buildMiddlewares(service)
+
+	want := &dynamic.Buffering{
+		MaxRequestBodyBytes: 104857600,
+		MemRequestBodyBytes: 2097152,
+		RetryExpression:     "IsNetworkError() && Attempts() < 2",
+	}
+	if upload := middlewares["upload"]; upload == nil || !reflect.DeepEqual(upload.Buffering, want) {
+		t.Errorf("Expected buffering middleware %+v, got %+v", want, upload)
+	}
+	for _, name := range []string{"negative", "inverted", "notanumber"} {
+		if _, exists := middlewares[name]; exists {
+			t.Errorf("Expected invalid middleware %s to be skipped", name)
+		}
+	}
+	for _, warning := range []string{
+		"traefik.http.middlewares.upload.buffering.maxrequestsize is not supported",
+		"must not be negative",
+		"exceeds buffering.maxrequestbodybytes",
+		`invalid integer "1MB"`,
+	} {
+		if !strings.Contains(buf.String(), warning) {
+			t.Errorf("Expected a warning containing %q, got %q", warning, buf.String())
+		}
+	}
+}
+
+func TestBuildMiddlewares_Headers(t *testing.T) {
+	service := internal.Service{
+		ID:   100,
+		Name: "app",
+		Config: map[string]string{
+			"traefik.http.middlewares.hdr.headers.customrequestheaders.x-forwarded-proto": "https",
+			"traefik.http.middlewares.hdr.headers.customrequestheaders.x-debug":           "",
+			"traefik.http.middlewares.hdr.headers.customresponseheaders.x-frame-options":  "DENY",
+			"traefik.http.middlewares.hdr.headers.customresponseheaders.server":           "",
+			"traefik.http.middlewares.hdr.headers.accesscontrolalloworiginlist":           "https://a.example.com, https://b.example.com",
+		},
+	}
+
+	hdr := buildMiddlewares(service)["hdr"]
+	if hdr == nil || hdr.Headers == nil {
+		t.Fatal("Expected headers middleware")
+	}
+
+	wantRequest := map[string]string{"X-Forwarded-Proto": "https", "X-Debug": ""}
+	if !reflect.DeepEqual(hdr.Headers.CustomRequestHeaders, wantRequest) {
+		t.Errorf("Expected request headers %v, got %v", wantRequest, hdr.Headers.CustomRequestHeaders)
+	}
+	wantResponse := map[string]string{"X-Frame-Options": "DENY", "Server": ""}
+	if !reflect.DeepEqual(hdr.Headers.CustomResponseHeaders, wantResponse) {
+		t.Errorf("Expected response headers %v, got %v", wantResponse, hdr.Headers.CustomResponseHeaders)
+	}
+	wantOrigins := []string{"https://a.example.com", "https://b.example.com"}
+	if !reflect.DeepEqual(hdr.Headers.AccessControlAllowOriginList, wantOrigins) {
+		t.Errorf("Expected origins %v, got %v", wantOrigins, hdr.Headers.AccessControlAllowOriginList)
+	}
+}
+
+func TestSortIPs(t *testing.T) {
+	ips := []internal.IP{
+		{Address: "10.0.0.10", AddressType: "ipv4", Interface: "eth0"},
+		{Address: "192.168.1.5", AddressType: "ipv4", Interface: "eth1"},
+		{Address: "10.0.0.9", AddressType: "ipv4", Interface: "eth0"},
+		{Address: "172.16.0.1", AddressType: "ipv4", Interface: "docker0"},
+	}
+	want := []string{"172.16.0.1", "10.0.0.9", "10.0.0.10", "192.168.1.5"}
+
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {1, 3, 0, 2}} {
+		shuffled := make([]internal.IP, 0, len(ips))
+		for _, i := range order {
+			shuffled = append(shuffled, ips[i])
+		}
+		sortIPs(shuffled)
+
+		got := make([]string, 0, len(shuffled))
+		for _, ip := range shuffled {
+			got = append(got, ip.Address)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("sortIPs(order %v) = %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestBuildMiddlewares_BasicAuth(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	service := internal.Service{
+		ID:   100,
+		Name: "app",
+		Config: map[string]string{
+			"traefik.http.middlewares.inline.basicauth.users":   "test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/,admin:$apr1$d9hr9HBB$4HxwgUir3HP4EsggP/QNo0",
+			"traefik.http.middlewares.file.basicauth.usersfile": "/etc/traefik/htpasswd",
+			"traefik.http.middlewares.file.basicauth.realm":     "internal",
+			"traefik.http.middlewares.both.basicauth.users":     "test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/",
+			"traefik.http.middlewares.both.basicauth.usersfile": "/etc/traefik/htpasswd",
+		},
+	}
+
+	middlewares := buildMiddlewares(service)
+
+	if inline := middlewares["inline"]; inline == nil || inline.BasicAuth == nil || len(inline.BasicAuth.Users) != 2 {
+		t.Errorf("Expected two inline users, got %+v", inline)
+	}
+	file := middlewares["file"]
+	if file == nil || file.BasicAuth == nil || file.BasicAuth.UsersFile != "/etc/traefik/htpasswd" || file.BasicAuth.Realm != "internal" {
+		t.Errorf("Expected users file middleware, got %+v", file)
+	}
+	both := middlewares["both"]
+	if both == nil || both.BasicAuth == nil || both.BasicAuth.UsersFile != "/etc/traefik/htpasswd" || len(both.BasicAuth.Users) != 0 {
+		t.Errorf("Expected usersfile to be preferred over users, got %+v", both)
+	}
+	if !strings.Contains(buf.String(), "using usersfile") {
+		t.Errorf("Expected a warning about users and usersfile, got %q", buf.String())
+	}
+}
+
+func TestBuildConfiguration_DuplicateRules(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "blue",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                        "true",
+					"traefik.http.routers.blue.rule":        "Host(`app.example.com`)",
+					"traefik.http.routers.blue.entrypoints": "websecure,web",
+					"traefik.http.routers.other.rule":       "Host(`other.example.com`)",
+				},
+			},
+			{
+				ID:   101,
+				Name: "green",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                         "true",
+					"traefik.http.routers.green.rule":        "Host(`app.example.com`)",
+					"traefik.http.routers.green.entrypoints": "web,websecure",
+					"traefik.http.routers.api.rule":          "Host(`app.example.com`)",
+					"traefik.http.routers.api.entrypoints":   "internal",
+				},
+			},
+		},
+	}
+
+	BuildConfiguration(servicesMap, Options{})
+
+	output := buf.String()
+	if !strings.Contains(output, "Routers blue of blue (ID: 100) on node node1, green of green (ID: 101) on node node1 share the rule Host(`app.example.com`)") {
+		t.Errorf("Expected a warning naming both guests, got %q", output)
+	}
+	if strings.Contains(output, "api of green") {
+		t.Errorf("Expected routers on other entrypoints not to conflict, got %q", output)
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	p := &Provider{refresh: make(chan struct{}, 1)}
+	handler := p.refreshHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, refreshPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected, got %d", rec.Code)
+	}
+	if len(p.refresh) != 0 {
+		t.Error("Expected GET not to queue a refresh")
+	}
+
+	for i := 0; i < 3; i++ {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, refreshPath, nil))
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("Expected POST to be accepted, got %d", rec.Code)
+		}
+	}
+	if len(p.refresh) != 1 {
+		t.Errorf("Expected repeated requests to queue a single refresh, got %d", len(p.refresh))
+	}
+}
+
+func TestLoadConfiguration_Refresh(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p := &Provider{
+		pollInterval: time.Hour,
+		clusters:     []cluster{{client: newFakeCluster()}},
+		refresh:      make(chan struct{}, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfgChan := make(chan json.Marshaler)
+	go p.loadConfiguration(ctx, cfgChan)
+
+	<-cfgChan
+	p.refresh <- struct{}{}
+	select {
+	case <-cfgChan:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a refresh request to publish a configuration")
+	}
+}
+
+func TestParseClusterEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []clusterEndpoint
+		wantErr bool
+	}{
+		{
+			name:   "single endpoint keeps an empty name",
+			config: Config{ApiEndpoint: "https://pve.example.com", ApiTokenId: "root@pam!traefik", ApiToken: "secret"},
+			want:   []clusterEndpoint{{ApiEndpoint: "https://pve.example.com", TokenId: "root@pam!traefik", Token: "secret"}},
+		},
+		{
+			name:   "shared token and names from hosts",
+			config: Config{ApiEndpoint: "https://pve-a.example.com:8006, pve-b.example.com", ApiTokenId: "root@pam!traefik", ApiToken: "secret"},
+			want: []clusterEndpoint{
+				{Name: "pve-a-example-com", ApiEndpoint: "https://pve-a.example.com:8006", TokenId: "root@pam!traefik", Token: "secret"},
+				{Name: "pve-b-example-com", ApiEndpoint: "pve-b.example.com", TokenId: "root@pam!traefik", Token: "secret"},
+			},
+		},
+		{
+			name: "token and name per endpoint",
+			config: Config{
+				ApiEndpoint:  "https://10.0.0.1:8006,https://10.0.1.1:8006",
+				ApiTokenId:   "root@pam!a,root@pam!b",
+				ApiToken:     "secret-a,secret-b",
+				ClusterNames: "home,lab",
+			},
+			want: []clusterEndpoint{
+				{Name: "home", ApiEndpoint: "https://10.0.0.1:8006", TokenId: "root@pam!a", Token: "secret-a"},
+				{Name: "lab", ApiEndpoint: "https://10.0.1.1:8006", TokenId: "root@pam!b", Token: "secret-b"},
+			},
+		},
+		{
+			name: "SSL validation per endpoint",
+			config: Config{
+				ApiEndpoint:    "https://prod.example.com,https://lab.example.com",
+				ApiTokenId:     "root@pam!traefik",
+				ApiToken:       "secret",
+				ApiValidateSSL: "true, false",
+				ClusterNames:   "prod,lab",
+			},
+			want: []clusterEndpoint{
+				{Name: "prod", ApiEndpoint: "https://prod.example.com", TokenId: "root@pam!traefik", Token: "secret", ValidateSSL: true},
+				{Name: "lab", ApiEndpoint: "https://lab.example.com", TokenId: "root@pam!traefik", Token: "secret", ValidateSSL: false},
+			},
+		},
+		{
+			name: "shared SSL validation",
+			config: Config{
+				ApiEndpoint:    "https://prod.example.com,https://lab.example.com",
+				ApiTokenId:     "root@pam!traefik",
+				ApiToken:       "secret",
+				ApiValidateSSL: "true",
+				ClusterNames:   "prod,lab",
+			},
+			want: []clusterEndpoint{
+				{Name: "prod", ApiEndpoint: "https://prod.example.com", TokenId: "root@pam!traefik", Token: "secret", ValidateSSL: true},
+				{Name: "lab", ApiEndpoint: "https://lab.example.com", TokenId: "root@pam!traefik", Token: "secret", ValidateSSL: true},
+			},
+		},
+		{
+			name:    "SSL validation count mismatch",
+			config:  Config{ApiEndpoint: "https://a,https://b,https://c", ApiTokenId: "x", ApiToken: "secret", ApiValidateSSL: "true,false"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid SSL validation entry",
+			config:  Config{ApiEndpoint: "https://a,https://b", ApiTokenId: "x", ApiToken: "secret", ApiValidateSSL: "true,maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "token count mismatch",
+			config:  Config{ApiEndpoint: "https://a,https://b,https://c", ApiTokenId: "x,y", ApiToken: "secret"},
+			wantErr: true,
+		},
+		{
+			name:    "name count mismatch",
+			config:  Config{ApiEndpoint: "https://a,https://b", ApiTokenId: "x", ApiToken: "secret", ClusterNames: "home"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate names",
+			config:  Config{ApiEndpoint: "https://pve:8006,https://pve:8007", ApiTokenId: "x", ApiToken: "secret"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClusterEndpoints(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClusterEndpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseClusterEndpoints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanClusters(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	opts := Options{ProviderPrefix: "proxmox-"}
+
+	oneCluster := []cluster{{client: newFakeCluster()}}
+	scanned, err := scanClusterServices(context.Background(), oneCluster)
+	if err != nil {
+		t.Fatalf("scanClusterServices() error = %v", err)
+	}
+	servicesMap, single := buildClusters(oneCluster, scanned, opts)
+	if _, exists := single.HTTP.Routers["proxmox-web"]; !exists {
+		t.Errorf("Expected a single cluster to keep its router names, got %v", mapKeys(single.HTTP.Routers))
+	}
+	if _, exists := servicesMap["node1"]; !exists {
+		t.Errorf("Expected unqualified node names, got %v", servicesMap)
+	}
+
+	clusters := []cluster{
+		{Name: "home", client: newFakeCluster()},
+		{Name: "lab", client: newFakeCluster()},
+	}
+	scanned, err = scanClusterServices(context.Background(), clusters)
+	if err != nil {
+		t.Fatalf("scanClusterServices() error = %v", err)
+	}
+	servicesMap, merged := buildClusters(clusters, scanned, opts)
+	if len(merged.HTTP.Routers) != 2*len(single.HTTP.Routers) || len(merged.HTTP.Services) != 2*len(single.HTTP.Services) {
+		t.Errorf("Expected the routers and services of both clusters, got %v", mapKeys(merged.HTTP.Routers))
+	}
+	for _, name := range []string{"proxmox-home-web", "proxmox-lab-web"} {
+		router, exists := merged.HTTP.Routers[name]
+		if !exists {
+			t.Errorf("Expected router %s, got %v", name, mapKeys(merged.HTTP.Routers))
+			continue
+		}
+		if _, exists := merged.HTTP.Services[router.Service]; !exists {
+			t.Errorf("Expected router %s to reference an existing service, got %s", name, router.Service)
+		}
+	}
+	if _, exists := servicesMap["lab/node2"]; !exists {
+		t.Errorf("Expected node names qualified with the cluster, got %v", servicesMap)
+	}
+}
+
+func mapKeys(m map[string]*dynamic.Router) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestBuildConfiguration_DefaultMiddlewares(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                       "true",
+					"traefik.http.routers.app.rule":        "Host(`app.example.com`)",
+					"traefik.http.routers.app.middlewares": "auth@file,secure@file",
+					"traefik.http.routers.plain.rule":      "Host(`plain.example.com`)",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		opts  Options
+		app   []string
+		plain []string
+	}{
+		{
+			name:  "no defaults",
+			opts:  Options{},
+			app:   []string{"auth@file", "secure@file"},
+			plain: nil,
+		},
+		{
+			name:  "defaults first",
+			opts:  Options{DefaultMiddlewares: []string{"ratelimit@file", "secure@file"}},
+			app:   []string{"ratelimit@file", "auth@file", "secure@file"},
+			plain: []string{"ratelimit@file", "secure@file"},
+		},
+		{
+			name:  "defaults last",
+			opts:  Options{DefaultMiddlewares: []string{"ratelimit@file", "secure@file"}, DefaultMiddlewaresLast: true},
+			app:   []string{"auth@file", "secure@file", "ratelimit@file"},
+			plain: []string{"ratelimit@file", "secure@file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := BuildConfiguration(servicesMap, tt.opts)
+			if got := config.HTTP.Routers["app"].Middlewares; !reflect.DeepEqual(got, tt.app) {
+				t.Errorf("app middlewares = %v, want %v", got, tt.app)
+			}
+			if got := config.HTTP.Routers["plain"].Middlewares; !reflect.DeepEqual(got, tt.plain) {
+				t.Errorf("plain middlewares = %v, want %v", got, tt.plain)
+			}
+		})
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "mail",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                               "true",
+					"traefik.tcp.routers.smtp.rule":                                "HostSNI(`*`)",
+					"traefik.tcp.routers.smtp.service":                             "smtp",
+					"traefik.tcp.services.smtp.loadbalancer.server.port":           "25",
+					"traefik.tcp.services.smtp.loadbalancer.proxyprotocol.version": "2",
+					"traefik.tcp.routers.imap.rule":                                "HostSNI(`*`)",
+					"traefik.tcp.routers.imap.service":                             "imap",
+					"traefik.tcp.services.imap.loadbalancer.server.port":           "143",
+					"traefik.tcp.services.imap.loadbalancer.proxyprotocol.version": "3",
+				},
+			},
+			{
+				ID:   101,
+				Name: "web",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                "true",
+					"traefik.http.routers.web.rule": "Host(`web.example.com`)",
+					"traefik.http.services.web.loadbalancer.server.proxyprotocol.version": "1",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	smtp := config.TCP.Services["smtp"]
+	if smtp == nil || smtp.LoadBalancer.ProxyProtocol == nil || smtp.LoadBalancer.ProxyProtocol.Version != 2 {
+		t.Errorf("Expected PROXY protocol version 2 on smtp, got %+v", smtp)
+	}
+	imap := config.TCP.Services["imap"]
+	if imap == nil || imap.LoadBalancer.ProxyProtocol != nil {
+		t.Errorf("Expected invalid PROXY protocol version to be skipped on imap, got %+v", imap)
+	}
+	if _, exists := config.HTTP.Services["web"]; !exists {
+		t.Error("Expected HTTP service web to be created")
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, `version must be 1 or 2, got "3"`) {
+		t.Errorf("Expected a warning about version 3, got %q", output)
+	}
+	if !strings.Contains(output, "PROXY protocol is not supported for HTTP service web") {
+		t.Errorf("Expected a warning about the HTTP service, got %q", output)
+	}
+}
+
+func TestParseStaticIPs(t *testing.T) {
+	staticIPs, err := parseStaticIPs("105=10.0.0.20, 106=10.0.0.21,106=fd00::21")
+	if err != nil {
+		t.Fatalf("parseStaticIPs() error = %v", err)
+	}
+	want := map[uint64][]internal.IP{
+		105: {{Address: "10.0.0.20", AddressType: "ipv4"}},
+		106: {{Address: "10.0.0.21", AddressType: "ipv4"}, {Address: "fd00::21", AddressType: "ipv6"}},
+	}
+	if !reflect.DeepEqual(staticIPs, want) {
+		t.Errorf("parseStaticIPs() = %v, want %v", staticIPs, want)
+	}
+
+	for _, invalid := range []string{"105", "web=10.0.0.20", "105=10.0.0.300"} {
+		if _, err := parseStaticIPs(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestScanServices_StaticIPs(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	opts := scanOptions{
+		SkipAgentNotReady: true,
+		StaticIPs: map[uint64][]internal.IP{
+			100: {{Address: "10.0.0.99", AddressType: "ipv4"}},
+			102: {{Address: "10.0.0.102", AddressType: "ipv4"}},
+		},
+	}
+	services, err := scanServices(newFakeCluster(), context.Background(), "node1", opts)
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+
+	ips := make(map[uint64]string)
+	for _, service := range services {
+		if len(service.IPs) > 0 {
+			ips[service.ID] = service.IPs[0].Address
+		}
+	}
+	if ips[100] == "10.0.0.99" {
+		t.Error("Expected the agent address to win over the static address")
+	}
+	if ips[102] != "10.0.0.102" {
+		t.Errorf("Expected the static address for the agent-less VM, got %q", ips[102])
+	}
+}
+
+func TestBuildConfiguration_PathRulesOnSharedIP(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	apiNotes := (&internal.ParsedConfig{Description: "traefik.enable=true\n" +
+		"traefik.http.routers.api.rule=Host(`x.example.com`) && PathPrefix(`/api`)\n" +
+		"traefik.http.services.api.loadbalancer.server.port=8081"}).GetTraefikMap()
+	uiNotes := (&internal.ParsedConfig{Description: "traefik.enable=true " +
+		"traefik.http.routers.ui.rule=Host(`x.example.com`) && (PathPrefix(`/`) || Path(`/health`)) " +
+		"traefik.http.services.ui.loadbalancer.server.port=8082"}).GetTraefikMap()
+
+	sharedIP := []internal.IP{{Address: "10.0.0.50", AddressType: "ipv4"}}
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{ID: 200, Name: "api", Type: internal.GuestTypeContainer, IPs: sharedIP, Config: apiNotes},
+			{ID: 201, Name: "ui", Type: internal.GuestTypeContainer, IPs: sharedIP, Config: uiNotes},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	tests := []struct {
+		router string
+		rule   string
+		url    string
+	}{
+		{"api", "Host(`x.example.com`) && PathPrefix(`/api`)", "http://10.0.0.50:8081"},
+		{"ui", "Host(`x.example.com`) && (PathPrefix(`/`) || Path(`/health`))", "http://10.0.0.50:8082"},
+	}
+	for _, tt := range tests {
+		router := config.HTTP.Routers[tt.router]
+		if router == nil {
+			t.Fatalf("Expected router %s", tt.router)
+		}
+		if router.Rule != tt.rule {
+			t.Errorf("Router %s rule = %q, want %q", tt.router, router.Rule, tt.rule)
+		}
+		service := config.HTTP.Services[router.Service]
+		if service == nil || len(service.LoadBalancer.Servers) != 1 || service.LoadBalancer.Servers[0].URL != tt.url {
+			t.Errorf("Router %s service = %+v, want a single server %s", tt.router, service, tt.url)
+		}
+	}
+	if strings.Contains(buf.String(), "share the rule") {
+		t.Errorf("Expected different paths on the same host not to be reported as duplicates, got %q", buf.String())
+	}
+}
+
+func TestStopWaitsForPoll(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p := &Provider{
+		pollInterval: time.Hour,
+		clusters:     []cluster{{client: newFakeCluster()}},
+	}
+	cfgChan := make(chan json.Marshaler)
+	if err := p.Provide(cfgChan); err != nil {
+		t.Fatalf("Provide() error = %v", err)
+	}
+	<-cfgChan
+
+	if err := p.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	select {
+	case <-p.done:
+	default:
+		t.Error("Expected Stop to return only after the poll loop finished")
+	}
+}
+
+func TestScanServices_AutoDetectPort(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name        string
+		ports       []int
+		description string
+		wantPort    string
+		wantCalls   int
+	}{
+		{
+			name:        "single web port",
+			ports:       []int{22, 3000},
+			description: "traefik.enable=true",
+			wantPort:    "3000",
+			wantCalls:   1,
+		},
+		{
+			name:        "several candidate ports",
+			ports:       []int{22, 3000, 9090},
+			description: "traefik.enable=true",
+			wantCalls:   1,
+		},
+		{
+			name:        "port label set",
+			ports:       []int{3000},
+			description: "traefik.enable=true\ntraefik.http.services.web.loadbalancer.server.port=8080",
+			wantCalls:   0,
+		},
+		{
+			name:        "not enabled",
+			ports:       []int{3000},
+			description: "a plain VM",
+			wantCalls:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := newFakeCluster()
+			cluster.vms["node1"] = cluster.vms["node1"][:1]
+			cluster.descriptions[100] = tt.description
+			cluster.ports = map[uint64][]int{100: tt.ports}
+
+			services, err := scanServices(cluster, context.Background(), "node1", scanOptions{AutoDetectPort: true})
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != 1 || services[0].DetectedPort != tt.wantPort {
+				t.Errorf("Expected detected port %q, got %+v", tt.wantPort, services)
+			}
+			if cluster.portCalls != tt.wantCalls {
+				t.Errorf("Expected %d port lookups, got %d", tt.wantCalls, cluster.portCalls)
+			}
+		})
+	}
+}
+
+func TestScanServices_AutoDetectPortTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cluster := newFakeCluster()
+	cluster.vms["node1"] = cluster.vms["node1"][:1]
+	cluster.descriptions[100] = "traefik.enable=true"
+	cluster.portsHang = true
+
+	done := make(chan struct{})
+	var services []internal.Service
+	var err error
+	go func() {
+		defer close(done)
+		services, err = scanServices(cluster, context.Background(), "node1", scanOptions{AutoDetectPort: true, PortDetectTimeout: 50 * time.Millisecond})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the scan to finish once port detection timed out")
+	}
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 1 || services[0].DetectedPort != "" {
+		t.Errorf("Expected the guest without a detected port, got %+v", services)
+	}
+	if !strings.Contains(buf.String(), "Detecting the listening ports of VM node1/100 timed out") {
+		t.Errorf("Expected a timeout warning, got:\n%s", buf.String())
+	}
+}
+
+func TestGetServiceURL_DetectedPort(t *testing.T) {
+	service := internal.Service{
+		ID:           100,
+		Name:         "web",
+		IPs:          []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+		Config:       map[string]string{"traefik.enable": "true"},
+		DetectedPort: "8443",
+	}
+	if got := getServiceURL(service, "web", "node1", Options{InferScheme: true}); got != "https://10.0.0.5:8443" {
+		t.Errorf("getServiceURL() = %s, want https://10.0.0.5:8443", got)
+	}
+
+	service.Config["traefik.http.services.web.loadbalancer.server.port"] = "80"
+	if got := getServiceURL(service, "web", "node1", Options{}); got != "http://10.0.0.5:80" {
+		t.Errorf("Expected the port label to win over the detected port, got %s", got)
+	}
+}
+
+func TestDefaultServiceKey_NameTemplate(t *testing.T) {
+	vm := internal.Service{ID: 100, Name: "web", Type: internal.GuestTypeVM}
+	ct := internal.Service{ID: 200, Name: "web", Type: internal.GuestTypeContainer}
+
+	tests := []struct {
+		name    string
+		service internal.Service
+		opts    Options
+		want    string
+	}{
+		{"default VM", vm, Options{}, "vm-node1-web-100"},
+		{"default container", ct, Options{}, "lxc-node1-web-200"},
+		{"template VM", vm, Options{NameTemplate: "{type}-{name}-{id}"}, "vm-web-100"},
+		{"template container", ct, Options{NameTemplate: "{type}-{name}-{id}"}, "lxc-web-200"},
+		{"template without type", internal.Service{ID: 300, Name: "db"}, Options{NameTemplate: "{type}-{name}-{id}"}, "guest-db-300"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultServiceKey(tt.service, "node1", tt.opts); got != tt.want {
+				t.Errorf("defaultServiceKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	config := BuildConfiguration(map[string][]internal.Service{
+		"node1": {{
+			ID:     100,
+			Name:   "web",
+			Type:   internal.GuestTypeVM,
+			IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+			Config: map[string]string{"traefik.enable": "true"},
+		}},
+	}, Options{NameTemplate: "{type}-{name}-{id}"})
+	if router := config.HTTP.Routers["vm-web-100"]; router == nil || router.Service != "vm-web-100" {
+		t.Errorf("Expected router and service named by the template, got %v", mapKeys(config.HTTP.Routers))
+	}
+}
+
+func TestBuildConfiguration_ServerURLOverride(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:          100,
+				Name:        "legacy",
+				AgentStatus: "interface lookup failed: guest agent is not running",
+				Config: map[string]string{
+					"traefik.enable":                                       "true",
+					"traefik.http.routers.legacy.rule":                     "Host(`legacy.example.com`)",
+					"traefik.http.services.legacy.loadbalancer.server.url": "https://backend.internal:9443/app",
+				},
+			},
+			{
+				ID:   101,
+				Name: "typo",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                     "true",
+					"traefik.http.routers.typo.rule":                     "Host(`typo.example.com`)",
+					"traefik.http.services.typo.loadbalancer.server.url": "backend.internal:8080",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{DisableHostnameFallback: true})
+
+	servers := config.HTTP.Services["legacy"].LoadBalancer.Servers
+	if len(servers) != 1 || servers[0].URL != "https://backend.internal:9443/app" {
+		t.Errorf("Expected the URL label to be the only server, got %+v", servers)
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "legacy (VMID: 100) on node node1 has traefik.enable=true but no reachable backend") {
+		t.Errorf("Expected no missing backend warning for a URL override, got %q", output)
+	}
+	if !strings.Contains(output, `Server URL "backend.internal:8080" of service typo is not an absolute URL`) {
+		t.Errorf("Expected a warning about the relative URL, got %q", output)
+	}
+}
+
+// concurrencyTrackingAPI records how many guest configs are fetched at once.
+type concurrencyTrackingAPI struct {
+	*fakeProxmoxAPI
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *concurrencyTrackingAPI) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return c.fakeProxmoxAPI.GetVMConfig(ctx, nodeName, vmID)
+}
+
+func TestScanServices_GuestConcurrency(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cluster := newFakeCluster()
+	cluster.vms["node1"] = nil
+	for i := 0; i < 20; i++ {
+		vmID := uint64(300 + i)
+		cluster.vms["node1"] = append(cluster.vms["node1"], internal.VirtualMachine{VMID: vmID, Name: fmt.Sprintf("vm%d", i), Status: "running"})
+		cluster.descriptions[vmID] = "traefik.enable=true"
+		cluster.ips[vmID] = []internal.IP{{Address: fmt.Sprintf("10.0.1.%d", i), AddressType: "ipv4"}}
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		api := &concurrencyTrackingAPI{fakeProxmoxAPI: cluster}
+		services, err := scanServices(api, context.Background(), "node1", scanOptions{GuestConcurrency: concurrency})
+		if err != nil {
+			t.Fatalf("scanServices() error = %v", err)
+		}
+		if len(services) != 20 {
+			t.Fatalf("Expected 20 services, got %d", len(services))
+		}
+		for i, service := range services {
+			if service.ID != uint64(300+i) || len(service.IPs) != 1 {
+				t.Errorf("Expected service %d to be VM %d with its address, got %+v", i, 300+i, service)
+			}
+		}
+		if api.maxInFlight > concurrency {
+			t.Errorf("Expected at most %d config fetches at once, got %d", concurrency, api.maxInFlight)
+		}
+		if concurrency > 1 && api.maxInFlight < 2 {
+			t.Errorf("Expected config fetches to overlap with concurrency %d", concurrency)
+		}
+	}
+}
+
+func TestURLHostPort(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"10.0.0.5", "10.0.0.5:8080"},
+		{"web.node1", "web.node1:8080"},
+		{"fd00::5", "[fd00::5]:8080"},
+		{"[fd00::5]", "[fd00::5]:8080"},
+		{"2001:db8::1%eth0", "[2001:db8::1]:8080"},
+		{"fe80::be24:11ff:fe00:1%eth0", "[fe80::be24:11ff:fe00:1%25eth0]:8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := urlHostPort(tt.host, "8080")
+			if got != tt.want {
+				t.Errorf("urlHostPort(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+			if _, err := url.Parse("http://" + got); err != nil {
+				t.Errorf("Expected a valid URL, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGetServerURLs_IPv6(t *testing.T) {
+	service := internal.Service{
+		ID:   100,
+		Name: "web",
+		IPs: []internal.IP{
+			{Address: "fd00::5", AddressType: "ipv6"},
+			{Address: "fe80::1%eth0", AddressType: "ipv6"},
+		},
+		Config: map[string]string{
+			"traefik.http.services.web.loadbalancer.server.port":              "8080",
+			"traefik.http.services.web.loadbalancer.server.port.fe80::1%eth0": "9090",
+		},
+	}
+
+	got := getServerURLs(service, "web", "node1", Options{})
+	want := []string{"http://[fe80::1%25eth0]:9090"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getServerURLs() = %v, want %v", got, want)
+	}
+
+	delete(service.Config, "traefik.http.services.web.loadbalancer.server.port.fe80::1%eth0")
+	if got := getServiceURL(service, "web", "node1", Options{}); got != "http://[fd00::5]:8080" {
+		t.Errorf("getServiceURL() = %s, want http://[fd00::5]:8080", got)
+	}
+
+	service.Config["traefik.http.services.web.loadbalancer.server.ip"] = "2001:db8::10"
+	if got := getServiceURL(service, "web", "node1", Options{}); got != "http://[2001:db8::10]:8080" {
+		t.Errorf("getServiceURL() = %s, want http://[2001:db8::10]:8080", got)
+	}
+}
+
+func TestScanServices_IPv6AgentAddresses(t *testing.T) {
+	tests := []struct {
+		name             string
+		ips              []internal.IP
+		backendInterface string
+		wantIPs          []string
+		wantURL          string
+	}{
+		{
+			name: "IPv4 preferred",
+			ips: []internal.IP{
+				{Address: "fd00::5", AddressType: "ipv6"},
+				{Address: "10.0.0.5", AddressType: "ipv4"},
+			},
+			wantIPs: []string{"10.0.0.5", "fd00::5"},
+			wantURL: "http://10.0.0.5:80",
+		},
+		{
+			name: "loopback and link-local dropped",
+			ips: []internal.IP{
+				{Address: "::1", AddressType: "ipv6"},
+				{Address: "fe80::1%eth0", AddressType: "ipv6"},
+				{Address: "fd00::5", AddressType: "ipv6"},
+			},
+			wantIPs: []string{"fd00::5"},
+			wantURL: "http://[fd00::5]:80",
+		},
+		{
+			name: "selected link-local keeps its zone",
+			ips: []internal.IP{
+				{Address: "fe80::1%eth0", AddressType: "ipv6"},
+			},
+			backendInterface: "eth0",
+			wantIPs:          []string{"fe80::1%eth0"},
+			wantURL:          "http://[fe80::1%25eth0]:80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := newFakeCluster()
+			cluster.vms["node1"] = cluster.vms["node1"][:1]
+			cluster.descriptions[100] = "traefik.enable=true"
+			cluster.ips[100] = tt.ips
+
+			services, err := scanServices(cluster, context.Background(), "node1", scanOptions{BackendInterface: tt.backendInterface})
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != 1 {
+				t.Fatalf("Expected one service, got %+v", services)
+			}
+			got := make([]string, 0, len(services[0].IPs))
+			for _, ip := range services[0].IPs {
+				got = append(got, ip.Address)
+			}
+			if !reflect.DeepEqual(got, tt.wantIPs) {
+				t.Errorf("Expected addresses %v, got %v", tt.wantIPs, got)
+			}
+			if url := getServiceURL(services[0], "web", "node1", Options{}); url != tt.wantURL {
+				t.Errorf("getServiceURL() = %s, want %s", url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestBuildConfiguration_NodeEntrypoints(t *testing.T) {
+	nodeEntrypoints, err := parseNodeEntrypoints("pve-a:web-a, pve-a:websecure-a,pve-b:web-b")
+	if err != nil {
+		t.Fatalf("parseNodeEntrypoints() error = %v", err)
+	}
+	if _, err := parseNodeEntrypoints("pve-a"); err == nil {
+		t.Error("Expected error for an entry without entrypoint")
+	}
+
+	guest := func(id uint64, name string, labels map[string]string) internal.Service {
+		labels["traefik.enable"] = "true"
+		return internal.Service{ID: id, Name: name, IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: labels}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve-a": {
+			guest(100, "a", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}),
+			guest(101, "pinned", map[string]string{
+				"traefik.http.routers.pinned.rule":        "Host(`pinned.example.com`)",
+				"traefik.http.routers.pinned.entrypoints": "internal",
+			}),
+			guest(102, "db", map[string]string{
+				"traefik.tcp.routers.db.rule":                      "HostSNI(`*`)",
+				"traefik.tcp.services.db.loadbalancer.server.port": "5432",
+			}),
+		},
+		"pve-b": {guest(200, "b", map[string]string{"traefik.http.routers.b.rule": "Host(`b.example.com`)"})},
+		"pve-c": {guest(300, "c", map[string]string{"traefik.http.routers.c.rule": "Host(`c.example.com`)"})},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{NodeEntrypoints: nodeEntrypoints, DefaultEntrypoints: []string{"web"}})
+
+	tests := []struct {
+		router string
+		want   []string
+	}{
+		{"a", []string{"web-a", "websecure-a"}},
+		{"pinned", []string{"internal"}},
+		{"b", []string{"web-b"}},
+		{"c", []string{"web"}},
+	}
+	for _, tt := range tests {
+		if got := config.HTTP.Routers[tt.router].EntryPoints; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Router %s entrypoints = %v, want %v", tt.router, got, tt.want)
+		}
+	}
+	if got := config.TCP.Routers["db"].EntryPoints; !reflect.DeepEqual(got, []string{"web-a", "websecure-a"}) {
+		t.Errorf("TCP router db entrypoints = %v, want the entrypoints of node pve-a", got)
+	}
+}
+
+func TestBuildConfiguration_CompositeServices(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ips := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "app-v1", IPs: ips, Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services.app-v1.loadbalancer.server.port": "8080",
+			}},
+			{ID: 101, Name: "app-v2", IPs: []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services.app-v2.loadbalancer.server.port": "8080",
+				"traefik.http.services.canary.weighted.services":        "app-v1:90, app-v2:10",
+				"traefik.http.services.shadow.mirroring.service":        "app-v1",
+				"traefik.http.services.shadow.mirroring.mirrors":        "app-v2:20,missing:5",
+				"traefik.http.services.shadow.mirroring.maxbodysize":    "1024",
+				"traefik.http.services.broken.weighted.services":        "app-v1:heavy",
+				"traefik.http.routers.app.rule":                         "Host(`app.example.com`)",
+				"traefik.http.routers.app.service":                      "canary",
+			}},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	canary := config.HTTP.Services["canary"]
+	if canary == nil || canary.Weighted == nil || canary.LoadBalancer != nil {
+		t.Fatalf("Expected weighted service canary, got %+v", canary)
+	}
+	if len(canary.Weighted.Services) != 2 ||
+		canary.Weighted.Services[0].Name != "app-v1" || *canary.Weighted.Services[0].Weight != 90 ||
+		canary.Weighted.Services[1].Name != "app-v2" || *canary.Weighted.Services[1].Weight != 10 {
+		t.Errorf("Unexpected weighted services %+v", canary.Weighted.Services)
+	}
+
+	shadow := config.HTTP.Services["shadow"]
+	if shadow == nil || shadow.Mirroring == nil {
+		t.Fatalf("Expected mirroring service shadow, got %+v", shadow)
+	}
+	if shadow.Mirroring.Service != "app-v1" || *shadow.Mirroring.MaxBodySize != 1024 ||
+		!reflect.DeepEqual(shadow.Mirroring.Mirrors, []dynamic.MirrorService{{Name: "app-v2", Percent: 20}, {Name: "missing", Percent: 5}}) {
+		t.Errorf("Unexpected mirroring %+v", shadow.Mirroring)
+	}
+
+	if config.HTTP.Routers["app"].Service != "canary" {
+		t.Errorf("Router app service = %s, want canary", config.HTTP.Routers["app"].Service)
+	}
+	if _, exists := config.HTTP.Services["broken"]; exists {
+		t.Error("Expected invalid weighted service to be skipped")
+	}
+	if !strings.Contains(buf.String(), "Service broken of app-v2") {
+		t.Errorf("Expected a warning about the invalid service, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Service shadow of app-v2 (ID: 101) on node pve1 references unknown service missing") {
+		t.Errorf("Expected a warning about the unknown mirror, got:\n%s", buf.String())
+	}
+
+	prefixed := BuildConfiguration(servicesMap, Options{ProviderPrefix: "pve-"})
+	if got := prefixed.HTTP.Services["pve-canary"].Weighted.Services[0].Name; got != "pve-app-v1" {
+		t.Errorf("Prefixed weighted service = %s, want pve-app-v1", got)
+	}
+}
+
+func TestBuildCompositeService_Invalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"weighted and mirroring": {
+			"traefik.http.services.s.weighted.services": "a,b",
+			"traefik.http.services.s.mirroring.service": "a",
+		},
+		"mirrors without service": {"traefik.http.services.s.mirroring.mirrors": "b:10"},
+		"percent above 100": {
+			"traefik.http.services.s.mirroring.service": "a",
+			"traefik.http.services.s.mirroring.mirrors": "b:150",
+		},
+		"empty weighted list": {"traefik.http.services.s.weighted.services": " , "},
+	}
+	for name, labels := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := buildCompositeService(internal.Service{Config: labels}, "s"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestBuildConfiguration_EnableValues(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ips := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	values := map[string]bool{"true": true, "yes": true, "1": true, "TRUE": true, "false": false, "no": false, "0": false, "ture": false}
+
+	var services []internal.Service
+	id := uint64(100)
+	for value := range values {
+		services = append(services, internal.Service{ID: id, Name: "guest-" + value, IPs: ips, Config: map[string]string{"traefik.enable": value}})
+		id++
+	}
+	config := BuildConfiguration(map[string][]internal.Service{"pve1": services}, Options{})
+
+	for _, service := range services {
+		value := service.Config["traefik.enable"]
+		key := defaultServiceKey(service, "pve1", Options{})
+		if _, exists := config.HTTP.Routers[key]; exists != values[value] {
+			t.Errorf("traefik.enable=%s: router created = %v, want %v", value, exists, values[value])
+		}
+	}
+
+	if !strings.Contains(buf.String(), `traefik.enable="ture", which is not a recognized boolean`) {
+		t.Errorf("Expected a warning about the unrecognized value, got:\n%s", buf.String())
+	}
+	if n := strings.Count(buf.String(), "not a recognized boolean"); n != 1 {
+		t.Errorf("Expected only the typo to be warned about, got %d warnings", n)
+	}
+}
+
+func TestBuildConfiguration_ServerURLTemplate(t *testing.T) {
+	tmpl, err := parseServerURLTemplate("{{.Scheme}}://{{.IP}}:{{.Port}}/{{.Node}}/{{.Name}}")
+	if err != nil {
+		t.Fatalf("parseServerURLTemplate() error = %v", err)
+	}
+
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "web", IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable":                                     "true",
+				"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+				"traefik.http.services.web.loadbalancer.server.port": "8080",
+			}},
+			{ID: 101, Name: "v6", IPs: []internal.IP{{Address: "2001:db8::5", AddressType: "ipv6"}}, Config: map[string]string{
+				"traefik.enable":                                      "true",
+				"traefik.http.routers.v6.rule":                        "Host(`v6.example.com`)",
+				"traefik.http.services.v6.loadbalancer.server.scheme": "https",
+			}},
+			{ID: 102, Name: "legacy", IPs: []internal.IP{{Address: "10.0.0.7", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable":                                       "true",
+				"traefik.http.routers.legacy.rule":                     "Host(`legacy.example.com`)",
+				"traefik.http.services.legacy.loadbalancer.server.url": "http://backend.internal:9000",
+			}},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{ServerURLTemplate: tmpl})
+
+	tests := map[string]string{
+		"web":    "http://10.0.0.5:8080/pve1/web",
+		"v6":     "https://[2001:db8::5]:443/pve1/v6",
+		"legacy": "http://backend.internal:9000",
+	}
+	for serviceName, want := range tests {
+		if got := config.HTTP.Services[serviceName].LoadBalancer.Servers[0].URL; got != want {
+			t.Errorf("Service %s URL = %s, want %s", serviceName, got, want)
+		}
+	}
+}
+
+func TestApplyRouterOptions_RuleSyntax(t *testing.T) {
+	tests := []struct {
+		syntax string
+		want   string
+	}{
+		{"v3", ""},
+		{"default", ""},
+		{"v2", "ruleSyntax v2 of router web is not supported"},
+		{"v4", `Invalid ruleSyntax "v4" for router web`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.syntax, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			router := &dynamic.Router{Rule: "Host(`web.example.com`)"}
+			service := internal.Service{Config: map[string]string{"traefik.http.routers.web.rulesyntax": tt.syntax}}
+			applyRouterOptions(router, service, "web")
+
+			if tt.want == "" && buf.Len() > 0 {
+				t.Errorf("Expected no warning, got:\n%s", buf.String())
+			}
+			if tt.want != "" && !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("Expected warning %q, got:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestRedactedConfig(t *testing.T) {
+	config := CreateConfig()
+	config.ApiEndpoint = "https://pve.example.com"
+	config.ApiTokenId = "root@pam!traefik"
+	config.ApiToken = "secret-token-a,secret-token-b"
+	config.ApiPassword = "hunter2"
+
+	effective, err := json.Marshal(redactedConfig(config))
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, secret := range []string{"secret-token", "hunter2"} {
+		if strings.Contains(string(effective), secret) {
+			t.Errorf("Expected %q to be redacted, got %s", secret, effective)
+		}
+	}
+	for _, setting := range []string{`"pollInterval":"30s"`, `"apiValidateSSL":"true"`, `"apiTokenId":"root@pam!traefik"`} {
+		if !strings.Contains(string(effective), setting) {
+			t.Errorf("Expected %s in the logged configuration, got %s", setting, effective)
+		}
+	}
+	if config.ApiToken != "secret-token-a,secret-token-b" {
+		t.Error("Expected the original configuration to be left unchanged")
+	}
+
+	if empty := redactedConfig(&Config{}); empty.ApiToken != "" || empty.ApiPassword != "" {
+		t.Errorf("Expected unset secrets to stay empty, got %+v", empty)
+	}
+}
+
+func TestApplyServiceOptions_Strategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{"wrr", ""},
+		{"p2c", "Load balancing strategy p2c of service web is not supported"},
+		{"random", `Invalid load balancing strategy "random" for service web`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			lb := &dynamic.ServersLoadBalancer{}
+			service := internal.Service{Config: map[string]string{"traefik.http.services.web.loadbalancer.strategy": tt.strategy}}
+			applyServiceOptions(lb, service, "web")
+
+			if tt.want == "" && buf.Len() > 0 {
+				t.Errorf("Expected no warning, got:\n%s", buf.String())
+			}
+			if tt.want != "" && !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("Expected warning %q, got:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestApplyServiceOptions_StickyMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge string
+		want   string
+	}{
+		{"3600", "Sticky cookie maxage of service web is not supported"},
+		{"1h", `Invalid sticky cookie maxage "1h" for service web`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.maxAge, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			lb := &dynamic.ServersLoadBalancer{}
+			service := internal.Service{Config: map[string]string{
+				"traefik.http.services.web.loadbalancer.sticky.cookie.name":   "session",
+				"traefik.http.services.web.loadbalancer.sticky.cookie.maxage": tt.maxAge,
+			}}
+			applyServiceOptions(lb, service, "web")
+
+			if lb.Sticky == nil || lb.Sticky.Cookie.Name != "session" {
+				t.Errorf("Expected the sticky cookie to be kept, got %+v", lb.Sticky)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("Expected warning %q, got:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestSendConfiguration(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	configuration := &dynamic.Configuration{}
+	ctx := context.Background()
+
+	ready := make(chan json.Marshaler, 1)
+	if err := sendConfiguration(ctx, ready, configuration, time.Second, time.Second); err != nil {
+		t.Fatalf("sendConfiguration() to a ready consumer error = %v", err)
+	}
+
+	stalled := make(chan json.Marshaler)
+	if err := sendConfiguration(ctx, stalled, configuration, 10*time.Millisecond, 50*time.Millisecond); err == nil {
+		t.Error("Expected a stalled consumer to time out")
+	}
+	if !strings.Contains(buf.String(), "has not accepted the configuration") {
+		t.Errorf("Expected a warning about the slow consumer, got %q", buf.String())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := sendConfiguration(cancelled, stalled, configuration, time.Second, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled context to stop the send, got %v", err)
+	}
+}
+
+func TestProvider_PollStatus(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	p := &Provider{clusters: []cluster{{client: api}}}
+	if !p.LastPollTime().IsZero() || p.LastError() != nil || p.RouteCount() != 0 {
+		t.Error("Expected an empty status before the first poll")
+	}
+
+	cfgChan := make(chan json.Marshaler, 1)
+	before := time.Now()
+	if err := p.updateConfiguration(context.Background(), cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	<-cfgChan
+	lastPoll := p.LastPollTime()
+	if lastPoll.Before(before) {
+		t.Errorf("LastPollTime() = %v, want after %v", lastPoll, before)
+	}
+	if p.LastError() != nil {
+		t.Errorf("LastError() = %v, want nil", p.LastError())
+	}
+	if p.RouteCount() == 0 {
+		t.Error("Expected RouteCount() to count the published routers")
+	}
+
+	api.nodesErr = errors.New("connection refused")
+	if err := p.updateConfiguration(context.Background(), cfgChan); err == nil {
+		t.Fatal("Expected the poll to fail")
+	}
+	if err := p.LastError(); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("LastError() = %v, want the poll error", err)
+	}
+	if !p.LastPollTime().Equal(lastPoll) {
+		t.Error("Expected a failed poll to keep the time of the last successful one")
+	}
+}
+
+func TestProvider_GuestPollInterval(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.descriptions[100] += "\ntraefik.pollinterval=10s"
+	api.descriptions[200] += "\ntraefik.pollinterval=1m"
+	p := &Provider{clusters: []cluster{{client: api}}, pollInterval: 30 * time.Second}
+	ctx := context.Background()
+	cfgChan := make(chan json.Marshaler, 1)
+
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	<-cfgChan
+	if len(p.guestPolls) != 1 || p.guestPolls[0].vmID != 100 || p.guestPolls[0].interval != 10*time.Second {
+		t.Fatalf("Expected only the guest with a shorter interval to be scheduled, got %+v", p.guestPolls)
+	}
+	wait, ok := nextGuestPoll(p.guestPolls, time.Now())
+	if !ok || wait <= 0 || wait > 10*time.Second {
+		t.Errorf("nextGuestPoll() = %v, %v, want at most 10s", wait, ok)
+	}
+
+	// Nothing is due yet
+	if err := p.refreshGuests(ctx, cfgChan, time.Now()); err != nil {
+		t.Fatalf("refreshGuests() error = %v", err)
+	}
+	select {
+	case <-cfgChan:
+		t.Fatal("Expected no configuration before a guest refresh is due")
+	default:
+	}
+
+	api.ips[100] = []internal.IP{{Address: "10.0.0.7", AddressType: "ipv4"}}
+	api.ips[200] = []internal.IP{{Address: "10.0.0.8", AddressType: "ipv4"}}
+	if err := p.refreshGuests(ctx, cfgChan, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatalf("refreshGuests() error = %v", err)
+	}
+	payload := (<-cfgChan).(*dynamic.JSONPayload)
+	if servers := payload.HTTP.Services[payload.HTTP.Routers["web"].Service].LoadBalancer.Servers; len(servers) != 1 || servers[0].URL != "http://10.0.0.7:80" {
+		t.Errorf("Expected the refreshed guest to use its new address, got %v", servers)
+	}
+	for name, service := range payload.HTTP.Services {
+		for _, server := range service.LoadBalancer.Servers {
+			if strings.HasPrefix(server.URL, "http://10.0.0.8") {
+				t.Errorf("Expected guests without a shorter interval to wait for the full poll, got %s in %s", server.URL, name)
+			}
+		}
+	}
+	if !strings.Contains(buf.String(), "Guest refresh complete: 1 guests") {
+		t.Errorf("Expected the guest refresh to be logged, got %q", buf.String())
+	}
+}
+
+func TestProvider_GuestRefreshKeepsState(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.descriptions[100] += "\ntraefik.pollinterval=10s"
+	p := &Provider{clusters: []cluster{{client: api, scanOptions: scanOptions{PublishDiagnostics: true}}}, pollInterval: 30 * time.Second}
+	ctx := context.Background()
+	cfgChan := make(chan json.Marshaler, 1)
+
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	<-cfgChan
+
+	webServers := func(payload *dynamic.JSONPayload) []dynamic.Server {
+		router, exists := payload.HTTP.Routers["web"]
+		if !exists {
+			return nil
+		}
+		return payload.HTTP.Services[router.Service].LoadBalancer.Servers
+	}
+	now := time.Now()
+	refresh := func() {
+		now = now.Add(10 * time.Second)
+		if err := p.refreshGuests(ctx, cfgChan, now); err != nil {
+			t.Fatalf("refreshGuests() error = %v", err)
+		}
+	}
+
+	// A failing guest agent keeps the previous address instead of the hostname fallback
+	api.interfaceErrs[100] = fmt.Errorf("QEMU guest agent is not running")
+	refresh()
+	select {
+	case <-cfgChan:
+		t.Fatal("Expected no configuration when the only due guest keeps its state")
+	default:
+	}
+	if servers := p.scanned[0]["node1"][0]; len(servers.IPs) != 1 || servers.IPs[0].Address != "10.0.0.1" {
+		t.Errorf("Expected the previous addresses to be kept, got %+v", servers.IPs)
+	}
+
+	// So does a failing config fetch, which would otherwise drop the labels
+	delete(api.interfaceErrs, 100)
+	api.configErrs = map[uint64]error{100: fmt.Errorf("permission denied")}
+	refresh()
+	if service := p.scanned[0]["node1"][0]; service.ScanError != "" || service.Config["traefik.enable"] != "true" {
+		t.Errorf("Expected the previous labels to be kept, got %+v", service)
+	}
+
+	// A stopped guest is dropped until the next full poll
+	delete(api.configErrs, 100)
+	api.vms["node1"][0].Status = "stopped"
+	refresh()
+	payload := (<-cfgChan).(*dynamic.JSONPayload)
+	if servers := webServers(payload); servers != nil {
+		t.Errorf("Expected the stopped guest to be dropped, got %v", servers)
+	}
+	if !strings.Contains(buf.String(), "Guest web (ID: 100) is no longer active") {
+		t.Errorf("Expected the dropped guest to be logged, got:\n%s", buf.String())
+	}
+}
+
+// reusedVMIDAPI serves guest configs and interfaces only for the guest type
+// currently listed under a VMID, like the Proxmox API after a VMID is reused.
+type reusedVMIDAPI struct {
+	*fakeProxmoxAPI
+	calls []string
+}
+
+func (r *reusedVMIDAPI) isContainer(nodeName string, vmID uint64) bool {
+	for _, ct := range r.containers[nodeName] {
+		if ct.VMID == vmID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reusedVMIDAPI) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedConfig, error) {
+	if r.isContainer(nodeName, vmID) {
+		return nil, fmt.Errorf("configuration file 'nodes/%s/qemu-server/%d.conf' does not exist", nodeName, vmID)
+	}
+	return r.fakeProxmoxAPI.GetVMConfig(ctx, nodeName, vmID)
+}
+
+func (r *reusedVMIDAPI) GetVMNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error) {
+	r.calls = append(r.calls, fmt.Sprintf("qemu/%d", vmID))
+	if r.isContainer(nodeName, vmID) {
+		return nil, fmt.Errorf("VM %d not running", vmID)
+	}
+	return r.fakeProxmoxAPI.GetVMNetworkInterfaces(ctx, nodeName, vmID)
+}
+
+func (r *reusedVMIDAPI) GetContainerNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*internal.ParsedAgentInterfaces, error) {
+	r.calls = append(r.calls, fmt.Sprintf("lxc/%d", vmID))
+	return r.fakeProxmoxAPI.GetContainerNetworkInterfaces(ctx, nodeName, vmID)
+}
+
+func TestProvider_ReusedVMID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := &reusedVMIDAPI{fakeProxmoxAPI: newFakeCluster()}
+	api.descriptions[100] += "\ntraefik.pollinterval=10s"
+	p := &Provider{clusters: []cluster{{client: api}}, pollInterval: 30 * time.Second}
+	ctx := context.Background()
+	cfgChan := make(chan json.Marshaler, 1)
+
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	<-cfgChan
+
+	// VM 100 is deleted and a migrated container takes over its VMID
+	api.vms["node1"] = api.vms["node1"][1:]
+	api.containers["node1"] = []internal.Container{{VMID: 100, Name: "web", Status: "running"}}
+	api.ips[100] = []internal.IP{{Address: "10.0.0.9", AddressType: "inet"}}
+	api.calls = nil
+
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	payload := (<-cfgChan).(*dynamic.JSONPayload)
+	for _, call := range api.calls {
+		if call == "qemu/100" {
+			t.Errorf("Expected the container to be queried on the lxc endpoint, got %v", api.calls)
+		}
+	}
+	servers := payload.HTTP.Services[payload.HTTP.Routers["web"].Service].LoadBalancer.Servers
+	if len(servers) != 1 || servers[0].URL != "http://10.0.0.9:80" {
+		t.Errorf("Expected the address of the container, got %v", servers)
+	}
+	if len(p.guestPolls) != 1 || p.guestPolls[0].guestType != internal.GuestTypeContainer {
+		t.Fatalf("Expected the refresh to be scheduled for the container, got %+v", p.guestPolls)
+	}
+
+	// Default keys name the guest type, so the VM and container never share one
+	vm := internal.NewService(100, "web", map[string]string{"traefik.enable": "true"})
+	vm.Type = internal.GuestTypeVM
+	ct := vm
+	ct.Type = internal.GuestTypeContainer
+	if vmKey, ctKey := defaultServiceKey(vm, "node1", Options{}), defaultServiceKey(ct, "node1", Options{}); vmKey == ctKey {
+		t.Errorf("Expected different keys for a VM and a container with the same VMID, got %s", vmKey)
+	}
+
+	api.calls = nil
+	if err := p.refreshGuests(ctx, cfgChan, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatalf("refreshGuests() error = %v", err)
+	}
+	<-cfgChan
+	if !reflect.DeepEqual(api.calls, []string{"lxc/100"}) {
+		t.Errorf("Expected the refresh to query the container, got %v", api.calls)
+	}
+}
+
+// versionFailingAPI fails the version check until it is told to recover.
+type versionFailingAPI struct {
+	*fakeProxmoxAPI
+	down bool
+}
+
+func (v *versionFailingAPI) GetVersion(ctx context.Context) (*internal.Version, error) {
+	if v.down {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return v.fakeProxmoxAPI.GetVersion(ctx)
+}
+
+func TestProvider_CheckVersions(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := &versionFailingAPI{fakeProxmoxAPI: newFakeCluster(), down: true}
+	p := &Provider{clusters: []cluster{{client: api, versionPending: true}}}
+	ctx := context.Background()
+
+	p.checkVersions(ctx)
+	if !p.clusters[0].versionPending {
+		t.Errorf("Expected the version check to stay pending while the API is down")
+	}
+	if !strings.Contains(buf.String(), "WARN: Proxmox version is still unavailable: connection refused") {
+		t.Errorf("Expected the failed retry to be logged, got %q", buf.String())
+	}
+
+	api.down = false
+	p.checkVersions(ctx)
+	if p.clusters[0].versionPending {
+		t.Errorf("Expected the version check to succeed once the API answers")
+	}
+	if !strings.Contains(buf.String(), "Connected to Proxmox VE version 8.2") || !strings.Contains(buf.String(), "Self-test:") {
+		t.Errorf("Expected the version and self-test to be logged after recovery, got %q", buf.String())
+	}
+
+	buf.Reset()
+	p.checkVersions(ctx)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no further checks once the version is known, got %q", buf.String())
+	}
+}
+
+func TestProvider_Debounce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	p := &Provider{clusters: []cluster{{client: api}}, debounce: time.Minute}
+	ctx := context.Background()
+	cfgChan := make(chan json.Marshaler, 1)
+
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	select {
+	case <-cfgChan:
+	default:
+		t.Fatal("Expected the first configuration to be published right away")
+	}
+
+	// An unchanged configuration is still published on every poll
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	select {
+	case <-cfgChan:
+	default:
+		t.Fatal("Expected an unchanged configuration to be published")
+	}
+
+	// Changes are held back and coalesced into the latest configuration
+	api.descriptions[200] = "traefik.enable=true\ntraefik.http.routers.db.rule=Host(`db.example.com`)"
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	api.descriptions[200] = "traefik.enable=true\ntraefik.http.routers.db.rule=Host(`db2.example.com`)"
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	select {
+	case <-cfgChan:
+		t.Fatal("Expected changed configurations to be held back")
+	default:
+	}
+
+	p.flushPending(ctx, cfgChan)
+	payload := (<-cfgChan).(*dynamic.JSONPayload)
+	if router := payload.Configuration.HTTP.Routers["db"]; router == nil || router.Rule != "Host(`db2.example.com`)" {
+		t.Errorf("Expected the latest held configuration to be published, got %+v", router)
+	}
+	if p.pending != nil {
+		t.Error("Expected nothing to be pending after the flush")
+	}
+
+	// A change that is reverted within the window is dropped
+	api.descriptions[200] = "traefik.enable=true"
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	api.descriptions[200] = "traefik.enable=true\ntraefik.http.routers.db.rule=Host(`db2.example.com`)"
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	<-cfgChan
+	if p.pending != nil {
+		t.Error("Expected the reverted change to be dropped")
+	}
+
+	// A held configuration clears the error of a failed poll
+	api.nodesErr = errors.New("connection refused")
+	if err := p.updateConfiguration(ctx, cfgChan); err == nil {
+		t.Fatal("Expected the poll to fail")
+	}
+	api.nodesErr = nil
+	api.descriptions[200] = "traefik.enable=true"
+	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	if p.pending == nil {
+		t.Fatal("Expected the changed configuration to be held back")
+	}
+	if err := p.LastError(); err != nil {
+		t.Errorf("LastError() = %v, want nil after a successful scan", err)
+	}
+}
+
+func TestScanServices_NameFilter(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.vms["node1"] = append(api.vms["node1"], internal.VirtualMachine{VMID: 103, Name: "ingress-web", Status: "running"})
+	api.containers["node1"] = []internal.Container{{VMID: 300, Name: "ingress-db", Status: "running"}}
+	api.descriptions[103] = "traefik.enable=true"
+	api.descriptions[300] = "traefik.enable=true"
+
+	nameFilter, err := parseNameFilter("^ingress-")
+	if err != nil {
+		t.Fatalf("parseNameFilter() error = %v", err)
+	}
+	services, err := scanServices(api, context.Background(), "node1", scanOptions{NameFilter: nameFilter})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+
+	var names []string
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"ingress-web", "ingress-db"}) {
+		t.Errorf("Expected only guests matching the filter, got %v", names)
+	}
+}
+
+func TestScanServices_ActiveStatuses(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.vms["node1"] = append(api.vms["node1"], internal.VirtualMachine{VMID: 103, Name: "paused", Status: "paused"})
+	api.descriptions[103] = "traefik.enable=true"
+
+	scanned := func(opts scanOptions) []uint64 {
+		services, err := scanServices(api, context.Background(), "node1", opts)
+		if err != nil {
+			t.Fatalf("scanServices() error = %v", err)
+		}
+		var ids []uint64
+		for _, service := range services {
+			ids = append(ids, service.ID)
+		}
+		return ids
+	}
+
+	if got := scanned(scanOptions{}); !reflect.DeepEqual(got, []uint64{100, 102}) {
+		t.Errorf("Expected only running guests by default, got %v", got)
+	}
+	if got := scanned(scanOptions{ActiveStatuses: parseActiveStatuses("running, Paused")}); !reflect.DeepEqual(got, []uint64{100, 102, 103}) {
+		t.Errorf("Expected paused guests to be scanned, got %v", got)
+	}
+	if got := scanned(scanOptions{ActiveStatuses: parseActiveStatuses("paused,stopped")}); !reflect.DeepEqual(got, []uint64{101, 103}) {
+		t.Errorf("Expected only the listed statuses to be scanned, got %v", got)
+	}
+	if got := parseActiveStatuses(" "); got != nil {
+		t.Errorf("Expected an empty list to keep the default, got %v", got)
+	}
+}
+
+func TestScanServices_ResolveFallbackHostnames(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.vms["node1"] = append(api.vms["node1"], internal.VirtualMachine{VMID: 103, Name: "unknown", Status: "running"})
+	api.descriptions[103] = "traefik.enable=true"
+	var lookups []string
+	lookup := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups = append(lookups, host)
+		if host == "booting.node1" {
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.9")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	services, err := scanServices(api, context.Background(), "node1", scanOptions{ResolveFallbackHostnames: true, LookupIP: lookup})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if !reflect.DeepEqual(lookups, []string{"booting.node1", "unknown.node1"}) {
+		t.Errorf("Expected only guests without addresses to be resolved, got %v", lookups)
+	}
+	for _, service := range services {
+		if service.Name == "booting" && len(service.IPs) != 0 {
+			t.Errorf("Expected the addresses to be only logged, got %v", service.IPs)
+		}
+	}
+	if !strings.Contains(buf.String(), "booting.node1 of booting (ID: 102) resolves to 10.0.0.9") ||
+		!strings.Contains(buf.String(), "WARN: Fallback hostname unknown.node1 of unknown (ID: 103) does not resolve") {
+		t.Errorf("Expected the lookup results to be logged, got %q", buf.String())
+	}
+
+	services, err = scanServices(api, context.Background(), "node1", scanOptions{ResolveFallbackHostnames: true, UseResolvedAddresses: true, LookupIP: lookup})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	for _, service := range services {
+		if service.Name == "booting" && !reflect.DeepEqual(service.IPs, []internal.IP{{Address: "10.0.0.9", AddressType: "ipv4"}}) {
+			t.Errorf("Expected the resolved address to be used, got %v", service.IPs)
+		}
+	}
+}
+
+func TestProbeServers(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer listener.Close()
+	up := listener.Addr().String()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	down := closed.Addr().String()
+	closed.Close()
+
+	config := &dynamic.Configuration{
+		HTTP: &dynamic.HTTPConfiguration{Services: map[string]*dynamic.Service{
+			"web": {LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: "http://" + up}, {URL: "http://" + down}}}},
+		}},
+		TCP: &dynamic.TCPConfiguration{Services: map[string]*dynamic.TCPService{
+			"db": {LoadBalancer: &dynamic.TCPServersLoadBalancer{Servers: []dynamic.TCPServer{{Address: down}, {Address: up}}}},
+		}},
+	}
+
+	probeServers(context.Background(), config, time.Second)
+
+	if got := config.HTTP.Services["web"].LoadBalancer.Servers; !reflect.DeepEqual(got, []dynamic.Server{{URL: "http://" + up}}) {
+		t.Errorf("HTTP servers = %v, want only the listening one", got)
+	}
+	if got := config.TCP.Services["db"].LoadBalancer.Servers; !reflect.DeepEqual(got, []dynamic.TCPServer{{Address: up}}) {
+		t.Errorf("TCP servers = %v, want only the listening one", got)
+	}
+	if !strings.Contains(buf.String(), "Server http://"+down+" of service web failed the probe") {
+		t.Errorf("Expected the failed probe to be logged, got:\n%s", buf.String())
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	tests := map[string]string{
+		"http://10.0.0.5:8080/app":  "10.0.0.5:8080",
+		"https://10.0.0.5":          "10.0.0.5:443",
+		"h2c://backend.internal":    "backend.internal:80",
+		"http://[2001:db8::5]:8080": "[2001:db8::5]:8080",
+		"not a url":                 "",
+	}
+	for serverURL, want := range tests {
+		if got := serverAddress(serverURL); got != want {
+			t.Errorf("serverAddress(%q) = %q, want %q", serverURL, got, want)
+		}
+	}
+}
+
+func TestGetServiceURL_DefaultPort(t *testing.T) {
+	ips := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	opts := Options{DefaultPort: "8080"}
+
+	tests := []struct {
+		name    string
+		service internal.Service
+		want    string
+	}{
+		{
+			name:    "no port label",
+			service: internal.Service{Name: "web", IPs: ips, Config: map[string]string{}},
+			want:    "http://10.0.0.5:8080",
+		},
+		{
+			name: "https without port label",
+			service: internal.Service{Name: "web", IPs: ips, Config: map[string]string{
+				"traefik.http.services.web.loadbalancer.server.scheme": "https",
+			}},
+			want: "https://10.0.0.5:8080",
+		},
+		{
+			name: "port label wins",
+			service: internal.Service{Name: "web", IPs: ips, Config: map[string]string{
+				"traefik.http.services.web.loadbalancer.server.port": "3000",
+			}},
+			want: "http://10.0.0.5:3000",
+		},
+		{
+			name:    "hostname fallback",
+			service: internal.Service{Name: "web", Config: map[string]string{}},
+			want:    "http://web.pve1:8080",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getServiceURL(tt.service, "web", "pve1", opts); got != tt.want {
+				t.Errorf("getServiceURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServiceURL_GuestPortLabel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ips := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	service := internal.Service{ID: 100, Name: "web", IPs: ips, Config: map[string]string{
+		"traefik.enable": "true",
+		"traefik.port":   "8443",
+	}, DetectedPort: "3000", TagPort: "9000"}
+
+	if got := getServiceURL(service, "web", "pve1", Options{DefaultPort: "8080", InferScheme: true}); got != "https://10.0.0.5:8443" {
+		t.Errorf("getServiceURL() = %s, want traefik.port to win over detected, tag and default ports", got)
+	}
+	if needsDetectedPort(service.Config) {
+		t.Errorf("Expected no port detection for guests with traefik.port")
+	}
+
+	service.Config["traefik.http.services.web.loadbalancer.server.port"] = "5000"
+	if got := getServiceURL(service, "web", "pve1", Options{}); got != "http://10.0.0.5:5000" {
+		t.Errorf("getServiceURL() = %s, want the service port label to win over traefik.port", got)
+	}
+
+	delete(service.Config, "traefik.http.services.web.loadbalancer.server.port")
+	service.Config["traefik.port"] = "http"
+	if got := getServiceURL(service, "web", "pve1", Options{}); got != "http://10.0.0.5:3000" {
+		t.Errorf("getServiceURL() = %s, want an invalid traefik.port to be ignored", got)
+	}
+	if !strings.Contains(buf.String(), `WARN: Invalid traefik.port "http" on web (ID: 100)`) {
+		t.Errorf("Expected a warning for the invalid port, got %q", buf.String())
+	}
+}
+
+func TestGetServiceURL_TagPort(t *testing.T) {
+	tests := []struct {
+		tags string
+		want string
+	}{
+		{"web;port-8080", "8080"},
+		{"PORT-3000,web", "3000"},
+		{"port-http;port-9000", "9000"},
+		{"port-70000", ""},
+		{"web;10.0.0.5", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := tagPort(tt.tags); got != tt.want {
+			t.Errorf("tagPort(%q) = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+
+	ips := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	service := internal.Service{Name: "web", IPs: ips, Config: map[string]string{}, TagPort: "8443"}
+	if got := getServiceURL(service, "web", "pve1", Options{DefaultPort: "8080", InferScheme: true}); got != "https://10.0.0.5:8443" {
+		t.Errorf("getServiceURL() = %s, want the tag port to win over defaultPort and infer the scheme", got)
+	}
+
+	service.DetectedPort = "3000"
+	if got := getServiceURL(service, "web", "pve1", Options{}); got != "http://10.0.0.5:3000" {
+		t.Errorf("getServiceURL() = %s, want the detected port to win over the tag port", got)
+	}
+
+	service.Config["traefik.http.services.web.loadbalancer.server.port"] = "5000"
+	if got := getServiceURL(service, "web", "pve1", Options{}); got != "http://10.0.0.5:5000" {
+		t.Errorf("getServiceURL() = %s, want the port label to win", got)
+	}
+}
+
+func TestBuildConfiguration_Diagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeCluster()
+	api.configErrs = map[uint64]error{100: fmt.Errorf("permission denied")}
+
+	services, err := scanServices(api, context.Background(), "node1", scanOptions{})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	for _, service := range services {
+		if service.ID == 100 {
+			t.Errorf("Expected guests failing to scan to be skipped without publishDiagnostics, got %+v", service)
+		}
+	}
+
+	services, err = scanServices(api, context.Background(), "node1", scanOptions{PublishDiagnostics: true})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	config := BuildConfiguration(map[string][]internal.Service{"node1": services}, Options{ProviderPrefix: "proxmox-"})
+
+	router, exists := config.HTTP.Routers["proxmox-diag-100"]
+	if !exists {
+		t.Fatalf("Expected a diagnostics router for the guest failing to scan, got %v", config.HTTP.Routers)
+	}
+	if router.Rule != "Host(`diag-100.invalid`)" || router.Service != "proxmox-diag-100" {
+		t.Errorf("Expected the diagnostics router to match nothing, got %+v", router)
+	}
+	middleware := config.HTTP.Middlewares["proxmox-diag-100"]
+	if middleware == nil || middleware.Headers == nil ||
+		middleware.Headers.CustomResponseHeaders[diagnosticsHeader] != "web on node node1: error getting VM config: permission denied" {
+		t.Errorf("Expected the scan error on the diagnostics middleware, got %+v", middleware)
+	}
+	if service := config.HTTP.Services["proxmox-diag-100"]; service == nil || len(service.LoadBalancer.Servers) != 0 {
+		t.Errorf("Expected a diagnostics service without servers, got %+v", service)
+	}
+
+	// The agent error of an enabled guest is published next to its router
+	if _, exists := config.HTTP.Routers["proxmox-diag-102"]; !exists {
+		t.Errorf("Expected a diagnostics router for the guest agent error, got %v", config.HTTP.Routers)
+	}
+	if _, exists := config.HTTP.Routers["proxmox-diag-101"]; exists {
+		t.Errorf("Expected no diagnostics for guests scanned without errors")
+	}
+
+	// Objects a guest declared under the same name are not overwritten
+	declared := internal.NewService(50, "app", map[string]string{
+		"traefik.enable":                     "true",
+		"traefik.http.routers.diag-300.rule": "Host(`app.example.com`)",
+	})
+	declared.IPs = []internal.IP{{Address: "10.0.0.9", AddressType: "ipv4"}}
+	failing := internal.NewService(300, "broken", map[string]string{})
+	failing.ScanError = "permission denied"
+	buf.Reset()
+	config = BuildConfiguration(map[string][]internal.Service{"node1": {declared, failing}}, Options{})
+	if router := config.HTTP.Routers["diag-300"]; router == nil || router.Rule != "Host(`app.example.com`)" {
+		t.Errorf("Expected the declared router to be kept, got %+v", router)
+	}
+	if _, exists := config.HTTP.Middlewares["diag-300"]; exists {
+		t.Errorf("Expected the diagnostics to be skipped, got %v", config.HTTP.Middlewares)
+	}
+	if !strings.Contains(buf.String(), "WARN: Diagnostics of broken (ID: 300) on node node1 were skipped because diag-300 is already defined by app (ID: 50) on node node1") {
+		t.Errorf("Expected a collision warning, got:\n%s", buf.String())
+	}
+
+	// Long messages are cut on a character boundary
+	long := internal.NewService(300, "größe", map[string]string{})
+	long.ScanError = strings.Repeat("ü", 2*maxDiagnosticsLength)
+	config = BuildConfiguration(map[string][]internal.Service{"node1": {long}}, Options{})
+	message := config.HTTP.Middlewares["diag-300"].Headers.CustomResponseHeaders[diagnosticsHeader]
+	if !utf8.ValidString(message) || utf8.RuneCountInString(message) != maxDiagnosticsLength || !strings.HasSuffix(message, "...") {
+		t.Errorf("Expected a valid message of %d characters, got %q", maxDiagnosticsLength, message)
+	}
+}
+
+func TestBuildConfiguration_Groups(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	member := func(id uint64, name, address string, labels map[string]string) internal.Service {
+		labels["traefik.enable"] = "true"
+		labels["traefik.group"] = "shop"
+		return internal.Service{ID: id, Name: name, IPs: []internal.IP{{Address: address, AddressType: "ipv4"}}, Config: labels}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			member(100, "frontend", "10.0.0.1", map[string]string{
+				"traefik.group.rule": "Host(`shop.example.com`)",
+				"traefik.group.path": "/",
+			}),
+			member(101, "api-1", "10.0.0.2", map[string]string{
+				"traefik.group.path":        "/api/",
+				"traefik.group.port":        "8080",
+				"traefik.group.stripprefix": "true",
+			}),
+		},
+		"pve2": {
+			member(200, "api-2", "10.0.0.3", map[string]string{
+				"traefik.group.path": "/api",
+				"traefik.group.port": "8080",
+			}),
+			member(201, "cart", "10.0.0.4", map[string]string{}),
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{DefaultEntrypoints: []string{"websecure"}})
+
+	wantRouters := map[string]*dynamic.Router{
+		"shop": {Service: "shop", Rule: "Host(`shop.example.com`)", EntryPoints: []string{"websecure"}},
+		"shop-api": {
+			Service:     "shop-api",
+			Rule:        "(Host(`shop.example.com`)) && PathPrefix(`/api`)",
+			EntryPoints: []string{"websecure"},
+			Middlewares: []string{"shop-api-stripprefix"},
+		},
+		"shop-cart": {Service: "shop-cart", Rule: "(Host(`shop.example.com`)) && PathPrefix(`/cart`)", EntryPoints: []string{"websecure"}},
+	}
+	if !reflect.DeepEqual(config.HTTP.Routers, wantRouters) {
+		t.Errorf("Routers = %v, want %v", mapKeys(config.HTTP.Routers), mapKeys(wantRouters))
+		for name, router := range config.HTTP.Routers {
+			t.Logf("%s: %+v", name, router)
+		}
+	}
+
+	wantServers := map[string][]dynamic.Server{
+		"shop":      {{URL: "http://10.0.0.1:80"}},
+		"shop-api":  {{URL: "http://10.0.0.2:8080"}, {URL: "http://10.0.0.3:8080"}},
+		"shop-cart": {{URL: "http://10.0.0.4:80"}},
+	}
+	for name, want := range wantServers {
+		service := config.HTTP.Services[name]
+		if service == nil || service.LoadBalancer == nil || !reflect.DeepEqual(service.LoadBalancer.Servers, want) {
+			t.Errorf("Service %s = %+v, want servers %v", name, service, want)
+		}
+	}
+	if len(config.HTTP.Services) != len(wantServers) {
+		t.Errorf("Expected only the group services, got %d services", len(config.HTTP.Services))
+	}
+	if strip := config.HTTP.Middlewares["shop-api-stripprefix"]; strip == nil || strip.StripPrefix == nil || !reflect.DeepEqual(strip.StripPrefix.Prefixes, []string{"/api"}) {
+		t.Errorf("Expected a stripprefix middleware for /api, got %+v", strip)
+	}
+	if strings.Contains(buf.String(), "is not supported") {
+		t.Errorf("Expected group labels to be handled, got %q", buf.String())
+	}
+
+	// Without a rule the group generates nothing
+	delete(servicesMap["pve1"][0].Config, "traefik.group.rule")
+	config = BuildConfiguration(servicesMap, Options{})
+	if len(config.HTTP.Routers) != 0 || !strings.Contains(buf.String(), "Group shop has no traefik.group.rule") {
+		t.Errorf("Expected a group without rule to be skipped, got routers %v", mapKeys(config.HTTP.Routers))
+	}
+}
+
+func TestBuildConfiguration_MaxServersPerService(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	guest := func(id uint64, address string) internal.Service {
+		return internal.Service{ID: id, Name: fmt.Sprintf("web-%d", id), IPs: []internal.IP{{Address: address, AddressType: "ipv4"}}, Config: map[string]string{
+			"traefik.enable":                                     "true",
+			"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+			"traefik.http.services.web.loadbalancer.server.port": "80",
+			"traefik.tcp.routers.web.rule":                       "HostSNI(`*`)",
+			"traefik.tcp.services.web.loadbalancer.server.port":  "5432",
+		}}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {guest(100, "10.0.0.1"), guest(101, "10.0.0.2"), guest(102, "10.0.0.3")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{MaxServersPerService: 2})
+
+	want := []dynamic.Server{{URL: "http://10.0.0.1:80"}, {URL: "http://10.0.0.2:80"}}
+	if got := config.HTTP.Services["web"].LoadBalancer.Servers; !reflect.DeepEqual(got, want) {
+		t.Errorf("HTTP servers = %v, want %v", got, want)
+	}
+	if got := config.TCP.Services["web"].LoadBalancer.Servers; len(got) != 2 {
+		t.Errorf("Expected 2 TCP servers, got %v", got)
+	}
+	if !strings.Contains(buf.String(), "left out http://10.0.0.3:80") || !strings.Contains(buf.String(), "left out 10.0.0.3:5432") {
+		t.Errorf("Expected the dropped backends to be logged, got %q", buf.String())
+	}
+
+	if config := BuildConfiguration(servicesMap, Options{}); len(config.HTTP.Services["web"].LoadBalancer.Servers) != 3 {
+		t.Error("Expected a zero cap to keep every server")
+	}
+}
+
+func TestBuildConfiguration_ProtocolDefaultEntrypoints(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "gameserver", IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable":                                      "true",
+				"traefik.http.routers.game.rule":                      "Host(`game.example.com`)",
+				"traefik.http.services.game.loadbalancer.server.port": "8080",
+				"traefik.tcp.routers.game.rule":                       "HostSNI(`*`)",
+				"traefik.tcp.services.game.loadbalancer.server.port":  "25575",
+				"traefik.udp.routers.game.service":                    "game",
+				"traefik.udp.services.game.loadbalancer.server.port":  "27015",
+				"traefik.udp.routers.voice.entrypoints":               "voice",
+				"traefik.udp.routers.voice.service":                   "game",
+			}},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{
+		DefaultEntrypoints:    []string{"websecure"},
+		DefaultTCPEntrypoints: []string{"rcon"},
+		DefaultUDPEntrypoints: []string{"game-udp"},
+	})
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"HTTP router", config.HTTP.Routers["game"].EntryPoints, []string{"websecure"}},
+		{"TCP router", config.TCP.Routers["game"].EntryPoints, []string{"rcon"}},
+		{"UDP router", config.UDP.Routers["game"].EntryPoints, []string{"game-udp"}},
+		{"UDP router with entrypoints label", config.UDP.Routers["voice"].EntryPoints, []string{"voice"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s entrypoints = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Without protocol defaults, TCP routers keep sharing the HTTP defaults
+	// and UDP routers get none.
+	config = BuildConfiguration(servicesMap, Options{DefaultEntrypoints: []string{"websecure"}})
+	if got := config.TCP.Routers["game"].EntryPoints; !reflect.DeepEqual(got, []string{"websecure"}) {
+		t.Errorf("TCP router entrypoints = %v, want the HTTP defaults", got)
+	}
+	if got := config.UDP.Routers["game"].EntryPoints; len(got) != 0 {
+		t.Errorf("UDP router entrypoints = %v, want none", got)
+	}
+}
+
+func TestGenerateConfiguration_TCPTLSTermination(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	servicesMap := map[string][]internal.Service{
+		"node1": {
+			{
+				ID:   100,
+				Name: "mqtt",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                     "true",
+					"traefik.tcp.routers.mqtt.rule":                      "HostSNI(`mqtt.example.com`)",
+					"traefik.tcp.routers.mqtt.entrypoints":               "mqtts",
+					"traefik.tcp.routers.mqtt.tls":                       "true",
+					"traefik.tcp.routers.mqtt.tls.certresolver":          "letsencrypt",
+					"traefik.tcp.routers.mqtt.tls.options":               "modern",
+					"traefik.tcp.routers.mqtt.tls.domains[0].main":       "example.com",
+					"traefik.tcp.routers.mqtt.tls.domains[0].sans":       "*.example.com",
+					"traefik.tcp.routers.plain.tls":                      "true",
+					"traefik.tcp.routers.plain.rule":                     "ClientIP(`10.0.0.0/8`)",
+					"traefik.tcp.routers.wildcard.tls.certresolver":      "letsencrypt",
+					"traefik.tcp.routers.off.tls":                        "false",
+					"traefik.tcp.services.mqtt.loadbalancer.server.port": "1883",
+				},
+			},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	router := config.TCP.Routers["mqtt"]
+	if router == nil {
+		t.Fatal("Expected TCP router mqtt")
+	}
+	want := &dynamic.RouterTCPTLSConfig{
+		CertResolver: "letsencrypt",
+		Options:      "modern",
+		Domains:      []types.Domain{{Main: "example.com", SANs: []string{"*.example.com"}}},
+	}
+	if !reflect.DeepEqual(router.TLS, want) {
+		t.Errorf("Expected TLS termination %+v, got %+v", want, router.TLS)
+	}
+	if len(router.EntryPoints) != 1 || router.EntryPoints[0] != "mqtts" {
+		t.Errorf("Expected entrypoint mqtts, got %v", router.EntryPoints)
+	}
+
+	if _, exists := config.TCP.Routers["plain"]; exists {
+		t.Error("Expected TLS router without HostSNI rule to be skipped")
+	}
+	if router := config.TCP.Routers["wildcard"]; router == nil || router.TLS == nil || router.TLS.CertResolver != "letsencrypt" {
+		t.Errorf("Expected the catch-all router to keep its certresolver, got %+v", router)
+	}
+	if router := config.TCP.Routers["off"]; router == nil || router.TLS != nil {
+		t.Errorf("Expected tls=false to leave TLS off, got %+v", router)
+	}
+	for _, warning := range []string{
+		"TLS termination requires a HostSNI rule",
+		"TCP router wildcard of mqtt (ID: 100) on node node1 uses certresolver letsencrypt but its rule \"HostSNI(`*`)\" names no host",
+	} {
+		if !strings.Contains(buf.String(), warning) {
+			t.Errorf("Expected a warning containing %q, got:\n%s", warning, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "is not supported") {
+		t.Errorf("Expected the TLS labels to be handled, got:\n%s", buf.String())
+	}
+}
+
+func TestBuildConfiguration_SharedTCPService(t *testing.T) {
+	guest := func(id uint64, address string) internal.Service {
+		return internal.Service{ID: id, Name: fmt.Sprintf("db%d", id), IPs: []internal.IP{{Address: address, AddressType: "ipv4"}}, Config: map[string]string{
+			"traefik.enable":                                   "true",
+			"traefik.tcp.routers.db.rule":                      "HostSNI(`*`)",
+			"traefik.tcp.services.db.loadbalancer.server.port": "5432",
+		}}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {guest(100, "10.0.0.5"), guest(101, "10.0.0.5"), guest(102, "10.0.0.6")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	want := []dynamic.TCPServer{{Address: "10.0.0.5:5432"}, {Address: "10.0.0.6:5432"}}
+	if got := config.TCP.Services["db"].LoadBalancer.Servers; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected duplicate addresses to be merged, got %v, want %v", got, want)
+	}
+}
+
+func TestBuildConfiguration_SharedUDPService(t *testing.T) {
+	guest := func(id uint64, address string) internal.Service {
+		return internal.Service{ID: id, Name: fmt.Sprintf("dns%d", id), IPs: []internal.IP{{Address: address, AddressType: "ipv4"}}, Config: map[string]string{
+			"traefik.enable": "true",
+			"traefik.udp.services.dns.loadbalancer.server.port": "53",
+		}}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {guest(100, "10.0.0.53"), guest(101, "10.0.0.53"), guest(102, "10.0.0.54")},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{})
+
+	want := []dynamic.UDPServer{{Address: "10.0.0.53:53"}, {Address: "10.0.0.54:53"}}
+	if got := config.UDP.Services["dns"].LoadBalancer.Servers; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected duplicate addresses to be merged, got %v, want %v", got, want)
+	}
+}
+
+func TestBuildConfiguration_HTTPTCPAndUDP(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "gameserver", IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable":                                      "true",
+				"traefik.http.routers.game.rule":                      "Host(`game.example.com`)",
+				"traefik.http.routers.game.service":                   "game",
+				"traefik.http.services.game.loadbalancer.server.port": "8080",
+				"traefik.tcp.routers.game.rule":                       "HostSNI(`*`)",
+				"traefik.tcp.routers.game.entrypoints":                "rcon",
+				"traefik.tcp.routers.game.service":                    "game",
+				"traefik.tcp.services.game.loadbalancer.server.port":  "25575",
+				"traefik.udp.routers.game.entrypoints":                "game-udp",
+				"traefik.udp.routers.game.service":                    "game",
+				"traefik.udp.services.game.loadbalancer.server.port":  "27015",
+			}},
+			{ID: 101, Name: "dns", IPs: []internal.IP{{Address: "10.0.0.53", AddressType: "ipv4"}}, Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.udp.services.dns.loadbalancer.server.port": "53",
+			}},
+		},
+	}
+
+	config := BuildConfiguration(servicesMap, Options{DefaultEntrypoints: []string{"websecure"}})
+
+	if got := config.HTTP.Services["game"].LoadBalancer.Servers[0].URL; got != "http://10.0.0.5:8080" {
+		t.Errorf("HTTP server = %s, want http://10.0.0.5:8080", got)
+	}
+	if got := config.TCP.Services["game"].LoadBalancer.Servers[0].Address; got != "10.0.0.5:25575" {
+		t.Errorf("TCP server = %s, want 10.0.0.5:25575", got)
+	}
+	if got := config.UDP.Services["game"].LoadBalancer.Servers[0].Address; got != "10.0.0.5:27015" {
+		t.Errorf("UDP server = %s, want 10.0.0.5:27015", got)
+	}
+
+	if router := config.HTTP.Routers["game"]; router == nil || router.Service != "game" || !reflect.DeepEqual(router.EntryPoints, []string{"websecure"}) {
+		t.Errorf("Unexpected HTTP router %+v", router)
+	}
+	if router := config.TCP.Routers["game"]; router == nil || router.Service != "game" || !reflect.DeepEqual(router.EntryPoints, []string{"rcon"}) {
+		t.Errorf("Unexpected TCP router %+v", router)
+	}
+	if router := config.UDP.Routers["game"]; router == nil || router.Service != "game" || !reflect.DeepEqual(router.EntryPoints, []string{"game-udp"}) {
+		t.Errorf("Unexpected UDP router %+v", router)
+	}
+
+	// A guest with only UDP labels gets no default HTTP router or service
+	if _, exists := config.UDP.Services["dns"]; !exists {
+		t.Error("Expected UDP service dns")
+	}
+	if len(config.HTTP.Routers) != 1 || len(config.HTTP.Services) != 1 {
+		t.Errorf("Expected only the HTTP router and service of gameserver, got routers %v", mapKeys(config.HTTP.Routers))
+	}
+
+	prefixed := BuildConfiguration(servicesMap, Options{ProviderPrefix: "pve-"})
+	if router := prefixed.UDP.Routers["pve-game"]; router == nil || router.Service != "pve-game" {
+		t.Errorf("Expected the UDP router to be prefixed, got %+v", prefixed.UDP.Routers)
+	}
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// refreshPath is where the refresh listener accepts on-demand refreshes.
+const refreshPath = "/refresh"
+
+// refreshHandler queues an out-of-band poll for every POST request. Requests
+// arriving while a refresh is already queued are folded into it, so a burst
+// of hook calls costs a single poll.
+func (p *Provider) refreshHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(refreshPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case p.refresh <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+// startRefreshListener serves refreshHandler on address until ctx is done.
+func (p *Provider) startRefreshListener(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for refresh requests on %s: %w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           p.refreshHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("ERROR: Failed to stop refresh listener: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ERROR: Refresh listener stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Listening for refresh requests on http://%s%s", listener.Addr(), refreshPath)
+	return nil
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// fallbackLookupTimeout bounds the DNS lookup of a fallback hostname.
+const fallbackLookupTimeout = 2 * time.Second
+
+// lookupFunc resolves a hostname, like net.Resolver.LookupIPAddr.
+type lookupFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// resolveFallbackHostname looks up the <name>.<node> hostname used for a guest
+// without addresses and logs whether it resolves, so a broken fallback shows
+// up before traffic fails. With substitute, the resolved addresses are
+// returned to be used as the guest addresses instead of the hostname.
+func resolveFallbackHostname(ctx context.Context, lookup lookupFunc, service internal.Service, nodeName string, substitute bool) []internal.IP {
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	host := service.Name + "." + nodeName
+
+	ctx, cancel := context.WithTimeout(ctx, fallbackLookupTimeout)
+	defer cancel()
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		log.Printf("WARN: Fallback hostname %s of %s (ID: %d) does not resolve: %v", host, service.Name, service.ID, err)
+		return nil
+	}
+	if len(addrs) == 0 {
+		log.Printf("WARN: Fallback hostname %s of %s (ID: %d) resolves to no address", host, service.Name, service.ID)
+		return nil
+	}
+
+	ips := make([]internal.IP, 0, len(addrs))
+	addresses := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addressType := "ipv4"
+		if addr.IP.To4() == nil {
+			addressType = "ipv6"
+		}
+		ips = append(ips, internal.IP{Address: addr.IP.String(), AddressType: addressType})
+		addresses = append(addresses, addr.IP.String())
+	}
+	if !substitute {
+		log.Printf("Fallback hostname %s of %s (ID: %d) resolves to %s", host, service.Name, service.ID, strings.Join(addresses, ", "))
+		return nil
+	}
+	log.Printf("Fallback hostname %s of %s (ID: %d) resolves to %s, using the addresses instead", host, service.Name, service.ID, strings.Join(addresses, ", "))
+	return ips
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"reflect"
+	"strings"
+)
+
+// maxSuggestionDistance is the largest number of edits between a mistyped
+// label and a suggested one.
+const maxSuggestionDistance = 2
+
+// labelSections are the label prefixes mapped onto the dynamic configuration.
+var labelSections = []string{
+	"traefik.http.routers.",
+	"traefik.http.services.",
+	"traefik.http.middlewares.",
+	"traefik.http.serverstransports.",
+	"traefik.tls.options.",
+	"traefik.tls.stores.",
+	"traefik.tcp.routers.",
+	"traefik.tcp.services.",
+	"traefik.udp.routers.",
+	"traefik.udp.services.",
+}
+
+// isLabelSection reports whether key is below one of the labelSections.
+func isLabelSection(key string) bool {
+	for _, section := range labelSections {
+		if strings.HasPrefix(key, section) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestLabel returns a supported label close to an unsupported key, e.g.
+// traefik.http.routers.web.rule for traefik.http.router.web.rule, or "" when
+// none is close. Keys are compared by their section, so the object name and
+// option are kept; a missing object name is shown as <name>.
+func suggestLabel(key string) string {
+	segments := strings.Split(key, ".")
+	for _, section := range labelSections {
+		n := strings.Count(section, ".")
+		if len(segments) <= n {
+			continue
+		}
+		if isCloseMatch(strings.Join(segments[:n], "."), strings.TrimSuffix(section, ".")) {
+			if len(segments) == n+1 {
+				return section + "<name>." + segments[n]
+			}
+			return section + strings.Join(segments[n:], ".")
+		}
+	}
+
+	var labels []string
+	for label := range handledGlobalLabels {
+		labels = append(labels, label)
+	}
+	for label := range handledGroupLabels {
+		labels = append(labels, label)
+	}
+	return closestMatch(key, labels)
+}
+
+// suggestOption returns the JSON field name of struct type t closest to an
+// unknown option, or "" when none is close.
+func suggestOption(t reflect.Type, option string) string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		if name != "-" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return closestMatch(option, names)
+}
+
+// closestMatch returns the candidate with the fewest edits from s, or "" when
+// none is close. Ties are broken by the order of candidates sorted by name so
+// the suggestion does not depend on map order.
+func closestMatch(s string, candidates []string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, candidate := range candidates {
+		if !isCloseMatch(s, candidate) {
+			continue
+		}
+		if d := editDistance(s, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// isCloseMatch reports whether s differs from candidate by a few edits that
+// leave most of s intact, so short words are not matched to anything.
+func isCloseMatch(s, candidate string) bool {
+	if s == candidate {
+		return false
+	}
+	d := editDistance(s, candidate)
+	return d <= maxSuggestionDistance && d*3 <= len(s)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
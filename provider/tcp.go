@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+	"github.com/traefik/genconf/dynamic/types"
+)
+
+// TCP service label suffixes that buildTCPService maps explicitly.
+var handledTCPServiceLabels = map[string]bool{
+	"loadbalancer.server.port":           true,
+	"loadbalancer.proxyprotocol.version": true,
+}
+
+func isHandledTCPServiceLabel(rest string) bool {
+	return handledTCPServiceLabels[rest]
+}
+
+// TCP router label suffixes that buildTCPRouterTLS maps explicitly.
+var handledTCPRouterLabels = map[string]bool{
+	"tls":              true,
+	"tls.certresolver": true,
+	"tls.options":      true,
+	"tls.domains":      true,
+}
+
+func isHandledTCPRouterLabel(rest string) bool {
+	return handledTCPRouterLabels[rest] || routerTLSDomainPattern.MatchString(rest)
+}
+
+// hasTCPLabels reports whether a guest declares any traefik.tcp.* labels.
+func hasTCPLabels(service internal.Service) bool {
+	for key := range service.Config {
+		if strings.HasPrefix(key, "traefik.tcp.") {
+			return true
+		}
+	}
+	return false
+}
+
+// addTCPConfiguration adds the TCP routers and services declared with
+// traefik.tcp.routers.<name>.* and traefik.tcp.services.<name>.* labels.
+func addTCPConfiguration(config *dynamic.Configuration, service internal.Service, nodeName string, opts Options, routerOwners map[string]string, owner string) {
+	routerNames := labelSectionNames(service, "traefik.tcp.routers.")
+	serviceNames := labelSectionNames(service, "traefik.tcp.services.")
+	if len(routerNames) == 0 && len(serviceNames) == 0 {
+		return
+	}
+
+	defaultID := defaultServiceKey(service, nodeName, opts)
+	if len(serviceNames) == 0 {
+		serviceNames = []string{defaultID}
+	}
+
+	for _, serviceName := range serviceNames {
+		tcpService := buildTCPService(service, serviceName, nodeName, opts)
+		if tcpService == nil {
+			continue
+		}
+		if existing, exists := config.TCP.Services[serviceName]; exists && existing.LoadBalancer != nil && tcpService.LoadBalancer != nil {
+			log.Printf("TCP service %s is shared with %s, merging servers", serviceName, owner)
+			existing.LoadBalancer.Servers = mergeTCPServers(existing.LoadBalancer.Servers, tcpService.LoadBalancer.Servers)
+			continue
+		}
+		config.TCP.Services[serviceName] = tcpService
+	}
+
+	for _, routerName := range routerNames {
+		prefix := labelKey("tcp.routers", routerName, "") + "."
+		router := &dynamic.TCPRouter{
+			Service: serviceNames[0],
+			Rule:    "HostSNI(`*`)",
+			TLS:     buildTCPRouterTLS(service, prefix, routerName, owner),
+		}
+		applyLabelPassthrough(router, service.Config, prefix, isHandledTCPRouterLabel)
+		if ref, exists := service.Config[prefix+"service"]; exists {
+			router.Service = labelReference(ref)
+		}
+
+		if len(router.EntryPoints) == 0 {
+			router.EntryPoints = defaultTCPEntrypoints(nodeName, opts)
+		}
+
+		if err := validateTCPRouter(router); err != nil {
+			log.Printf("WARN: TCP router %s of %s is invalid and was skipped: %v", routerName, owner, err)
+			continue
+		}
+		if tls := router.TLS; tls != nil && tls.CertResolver != "" && len(tls.Domains) == 0 && isCatchAllSNI(router.Rule) {
+			log.Printf("WARN: TCP router %s of %s uses certresolver %s but its rule %q names no host, so no certificate can be requested", routerName, owner, tls.CertResolver, router.Rule)
+		}
+
+		if previous, exists := routerOwners[routerName]; exists {
+			log.Printf("WARN: TCP router %s is defined by both %s and %s, keeping the first definition", routerName, previous, owner)
+			continue
+		}
+
+		config.TCP.Routers[routerName] = router
+		routerOwners[routerName] = owner
+	}
+}
+
+// mergeTCPServers appends the servers not already present in existing, like
+// mergeServers does for HTTP.
+func mergeTCPServers(existing, servers []dynamic.TCPServer) []dynamic.TCPServer {
+	for _, server := range servers {
+		duplicate := false
+		for _, current := range existing {
+			if current.Address == server.Address {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			existing = append(existing, server)
+		}
+	}
+	return existing
+}
+
+// buildTCPService creates a TCP service forwarding to the guest address on the
+// port given by the loadbalancer.server.port label.
+func buildTCPService(service internal.Service, serviceName string, nodeName string, opts Options) *dynamic.TCPService {
+	prefix := labelKey("tcp.services", serviceName, "") + "."
+	port, exists := service.Config[prefix+"loadbalancer.server.port"]
+	if !exists {
+		log.Printf("WARN: TCP service %s of %s (ID: %d) has no loadbalancer.server.port label and was skipped", serviceName, service.Name, service.ID)
+		return nil
+	}
+
+	loadBalancer := &dynamic.TCPServersLoadBalancer{
+		Servers: []dynamic.TCPServer{},
+	}
+
+	host := ""
+	for _, ip := range service.IPs {
+		if ip.Address != "" {
+			host = ip.Address
+			break
+		}
+	}
+	if host == "" && !opts.DisableHostnameFallback {
+		host = fmt.Sprintf("%s.%s", service.Name, nodeName)
+	}
+	if host != "" {
+		loadBalancer.Servers = append(loadBalancer.Servers, dynamic.TCPServer{
+			Address: net.JoinHostPort(host, port),
+		})
+	}
+
+	if value, exists := service.Config[prefix+"loadbalancer.proxyprotocol.version"]; exists {
+		version, err := parseProxyProtocolVersion(value)
+		if err != nil {
+			log.Printf("WARN: Invalid proxyprotocol version for TCP service %s of %s (ID: %d), sending no PROXY header: %v", serviceName, service.Name, service.ID, err)
+		} else {
+			loadBalancer.ProxyProtocol = &dynamic.ProxyProtocol{Version: version}
+		}
+	}
+
+	tcpService := &dynamic.TCPService{LoadBalancer: loadBalancer}
+	applyLabelPassthrough(tcpService, service.Config, prefix, isHandledTCPServiceLabel)
+	return tcpService
+}
+
+// buildTCPRouterTLS creates the TLS configuration of a TCP router from its
+// tls, tls.certresolver, tls.options and tls.domains labels, like
+// handleRouterTLS does for HTTP routers. It returns nil for routers without
+// TLS labels; tls.passthrough is set by the label passthrough.
+func buildTCPRouterTLS(service internal.Service, prefix string, routerName string, owner string) *dynamic.RouterTCPTLSConfig {
+	tlsEnabled := false
+	if value, exists := service.Config[prefix+"tls"]; exists {
+		enabled, err := stringToBool(value)
+		if err != nil {
+			log.Printf("WARN: Invalid tls value %q for TCP router %s of %s, expected true or false", value, routerName, owner)
+		}
+		tlsEnabled = enabled
+	}
+
+	tlsConfig := &dynamic.RouterTCPTLSConfig{}
+	hasSettings := false
+	if certResolver, exists := service.Config[prefix+"tls.certresolver"]; exists {
+		tlsConfig.CertResolver, hasSettings = certResolver, true
+	}
+	if options, exists := service.Config[prefix+"tls.options"]; exists {
+		tlsConfig.Options, hasSettings = options, true
+	}
+
+	// Array-indexed domains take precedence, as for HTTP routers
+	domains := make(map[int]*types.Domain)
+	for key, value := range service.Config {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		matches := routerTLSDomainPattern.FindStringSubmatch(strings.TrimPrefix(key, prefix))
+		if matches == nil {
+			continue
+		}
+		idx, _ := strconv.Atoi(matches[1])
+		if domains[idx] == nil {
+			domains[idx] = &types.Domain{}
+		}
+		if matches[2] == "main" {
+			domains[idx].Main = value
+		} else {
+			domains[idx].SANs = splitList(value)
+		}
+	}
+	if len(domains) > 0 {
+		indices := make([]int, 0, len(domains))
+		for idx := range domains {
+			indices = append(indices, idx)
+		}
+		sort.Ints(indices)
+		for _, idx := range indices {
+			tlsConfig.Domains = append(tlsConfig.Domains, *domains[idx])
+		}
+		hasSettings = true
+	} else if value, exists := service.Config[prefix+"tls.domains"]; exists {
+		for _, domain := range splitList(value) {
+			tlsConfig.Domains = append(tlsConfig.Domains, types.Domain{Main: domain})
+		}
+		hasSettings = true
+	}
+
+	if !tlsEnabled && !hasSettings {
+		return nil
+	}
+	return tlsConfig
+}
+
+// validateTCPRouter checks that TLS routers select connections by SNI. With
+// passthrough the TLS handshake is the only routing information available,
+// and terminating routers pick their certificate by it.
+func validateTCPRouter(router *dynamic.TCPRouter) error {
+	if router.TLS == nil {
+		return nil
+	}
+	if !strings.Contains(strings.ToLower(router.Rule), "hostsni") {
+		if router.TLS.Passthrough {
+			return fmt.Errorf("TLS passthrough requires a HostSNI rule, got %q", router.Rule)
+		}
+		return fmt.Errorf("TLS termination requires a HostSNI rule, got %q", router.Rule)
+	}
+	return nil
+}
+
+// isCatchAllSNI reports whether a TCP rule is the catch-all HostSNI(`*`),
+// which names no host to request a certificate for.
+func isCatchAllSNI(rule string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(rule), ""), "HostSNI(`*`)")
+}
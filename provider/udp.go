@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// UDP service label suffixes that buildUDPService maps explicitly.
+var handledUDPServiceLabels = map[string]bool{
+	"loadbalancer.server.port": true,
+}
+
+func isHandledUDPServiceLabel(rest string) bool {
+	return handledUDPServiceLabels[rest]
+}
+
+// hasUDPLabels reports whether a guest declares any traefik.udp.* labels.
+func hasUDPLabels(service internal.Service) bool {
+	for key := range service.Config {
+		if strings.HasPrefix(key, "traefik.udp.") {
+			return true
+		}
+	}
+	return false
+}
+
+// addUDPConfiguration adds the UDP routers and services declared with
+// traefik.udp.routers.<name>.* and traefik.udp.services.<name>.* labels.
+// UDP routers have no rule; without an entrypoints label they get
+// DefaultUDPEntrypoints, or Traefik attaches them to every UDP entrypoint. The
+// HTTP default entrypoints are never used.
+func addUDPConfiguration(config *dynamic.Configuration, service internal.Service, nodeName string, opts Options, routerOwners map[string]string, owner string) {
+	routerNames := labelSectionNames(service, "traefik.udp.routers.")
+	serviceNames := labelSectionNames(service, "traefik.udp.services.")
+	if len(routerNames) == 0 && len(serviceNames) == 0 {
+		return
+	}
+
+	defaultID := defaultServiceKey(service, nodeName, opts)
+	if len(serviceNames) == 0 {
+		serviceNames = []string{defaultID}
+	}
+
+	for _, serviceName := range serviceNames {
+		udpService := buildUDPService(service, serviceName, nodeName, opts)
+		if udpService == nil {
+			continue
+		}
+		if existing, exists := config.UDP.Services[serviceName]; exists && existing.LoadBalancer != nil && udpService.LoadBalancer != nil {
+			log.Printf("UDP service %s is shared with %s, merging servers", serviceName, owner)
+			existing.LoadBalancer.Servers = mergeUDPServers(existing.LoadBalancer.Servers, udpService.LoadBalancer.Servers)
+			continue
+		}
+		config.UDP.Services[serviceName] = udpService
+	}
+
+	for _, routerName := range routerNames {
+		prefix := labelKey("udp.routers", routerName, "") + "."
+		router := &dynamic.UDPRouter{
+			Service: serviceNames[0],
+		}
+		applyLabelPassthrough(router, service.Config, prefix, nil)
+		if ref, exists := service.Config[prefix+"service"]; exists {
+			router.Service = labelReference(ref)
+		}
+
+		if len(router.EntryPoints) == 0 && len(opts.DefaultUDPEntrypoints) > 0 {
+			router.EntryPoints = append([]string{}, opts.DefaultUDPEntrypoints...)
+		}
+
+		if previous, exists := routerOwners[routerName]; exists {
+			log.Printf("WARN: UDP router %s is defined by both %s and %s, keeping the first definition", routerName, previous, owner)
+			continue
+		}
+
+		config.UDP.Routers[routerName] = router
+		routerOwners[routerName] = owner
+	}
+}
+
+// mergeUDPServers appends the servers not already present in existing, like
+// mergeServers does for HTTP.
+func mergeUDPServers(existing, servers []dynamic.UDPServer) []dynamic.UDPServer {
+	for _, server := range servers {
+		duplicate := false
+		for _, current := range existing {
+			if current.Address == server.Address {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			existing = append(existing, server)
+		}
+	}
+	return existing
+}
+
+// buildUDPService creates a UDP service forwarding to the guest address on the
+// port given by the loadbalancer.server.port label.
+func buildUDPService(service internal.Service, serviceName string, nodeName string, opts Options) *dynamic.UDPService {
+	prefix := labelKey("udp.services", serviceName, "") + "."
+	port, exists := service.Config[prefix+"loadbalancer.server.port"]
+	if !exists {
+		log.Printf("WARN: UDP service %s of %s (ID: %d) has no loadbalancer.server.port label and was skipped", serviceName, service.Name, service.ID)
+		return nil
+	}
+
+	loadBalancer := &dynamic.UDPServersLoadBalancer{
+		Servers: []dynamic.UDPServer{},
+	}
+
+	host := ""
+	for _, ip := range service.IPs {
+		if ip.Address != "" {
+			host = ip.Address
+			break
+		}
+	}
+	if host == "" && !opts.DisableHostnameFallback {
+		host = fmt.Sprintf("%s.%s", service.Name, nodeName)
+	}
+	if host != "" {
+		loadBalancer.Servers = append(loadBalancer.Servers, dynamic.UDPServer{
+			Address: net.JoinHostPort(host, port),
+		})
+	}
+
+	udpService := &dynamic.UDPService{LoadBalancer: loadBalancer}
+	applyLabelPassthrough(udpService, service.Config, prefix, isHandledUDPServiceLabel)
+	return udpService
+}
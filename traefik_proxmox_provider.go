@@ -10,24 +10,126 @@ import (
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval             string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	ApiEndpoint              string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	ApiTokenId               string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken                 string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiLogging               string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	ApiValidateSSL           string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PoolFilter               string `json:"poolFilter" yaml:"poolFilter" toml:"poolFilter"`
+	DefaultEntrypoints       string `json:"defaultEntrypoints" yaml:"defaultEntrypoints" toml:"defaultEntrypoints"`
+	SkipAgentNotReady        string `json:"skipAgentNotReady" yaml:"skipAgentNotReady" toml:"skipAgentNotReady"`
+	LabelSource              string `json:"labelSource" yaml:"labelSource" toml:"labelSource"`
+	DisableHostnameFallback  string `json:"disableHostnameFallback" yaml:"disableHostnameFallback" toml:"disableHostnameFallback"`
+	IncludeVMIDs             string `json:"includeVMIDs" yaml:"includeVMIDs" toml:"includeVMIDs"`
+	ExcludeVMIDs             string `json:"excludeVMIDs" yaml:"excludeVMIDs" toml:"excludeVMIDs"`
+	BackendInterface         string `json:"backendInterface" yaml:"backendInterface" toml:"backendInterface"`
+	ApiClientCert            string `json:"apiClientCert" yaml:"apiClientCert" toml:"apiClientCert"`
+	ApiClientKey             string `json:"apiClientKey" yaml:"apiClientKey" toml:"apiClientKey"`
+	ApiUser                  string `json:"apiUser" yaml:"apiUser" toml:"apiUser"`
+	ApiPassword              string `json:"apiPassword" yaml:"apiPassword" toml:"apiPassword"`
+	ApiRealm                 string `json:"apiRealm" yaml:"apiRealm" toml:"apiRealm"`
+	InferScheme              string `json:"inferScheme" yaml:"inferScheme" toml:"inferScheme"`
+	DefaultScheme            string `json:"defaultScheme" yaml:"defaultScheme" toml:"defaultScheme"`
+	ApiMaxResponseSize       string `json:"apiMaxResponseSize" yaml:"apiMaxResponseSize" toml:"apiMaxResponseSize"`
+	ProviderPrefix           string `json:"providerPrefix" yaml:"providerPrefix" toml:"providerPrefix"`
+	AllowFastPolling         string `json:"allowFastPolling" yaml:"allowFastPolling" toml:"allowFastPolling"`
+	PollJitter               string `json:"pollJitter" yaml:"pollJitter" toml:"pollJitter"`
+	NodeRefreshInterval      string `json:"nodeRefreshInterval" yaml:"nodeRefreshInterval" toml:"nodeRefreshInterval"`
+	RefreshListenAddress     string `json:"refreshListenAddress" yaml:"refreshListenAddress" toml:"refreshListenAddress"`
+	ClusterNames             string `json:"clusterNames" yaml:"clusterNames" toml:"clusterNames"`
+	DefaultMiddlewares       string `json:"defaultMiddlewares" yaml:"defaultMiddlewares" toml:"defaultMiddlewares"`
+	DefaultMiddlewaresOrder  string `json:"defaultMiddlewaresOrder" yaml:"defaultMiddlewaresOrder" toml:"defaultMiddlewaresOrder"`
+	VMIDToIP                 string `json:"vmidToIP" yaml:"vmidToIP" toml:"vmidToIP"`
+	AutoDetectPort           string `json:"autoDetectPort" yaml:"autoDetectPort" toml:"autoDetectPort"`
+	NameTemplate             string `json:"nameTemplate" yaml:"nameTemplate" toml:"nameTemplate"`
+	GuestConcurrency         string `json:"guestConcurrency" yaml:"guestConcurrency" toml:"guestConcurrency"`
+	NodeEntrypoints          string `json:"nodeEntrypoints" yaml:"nodeEntrypoints" toml:"nodeEntrypoints"`
+	LabelPrefix              string `json:"labelPrefix" yaml:"labelPrefix" toml:"labelPrefix"`
+	ServerURLTemplate        string `json:"serverURLTemplate" yaml:"serverURLTemplate" toml:"serverURLTemplate"`
+	NameFilter               string `json:"nameFilter" yaml:"nameFilter" toml:"nameFilter"`
+	ProbeBackends            string `json:"probeBackends" yaml:"probeBackends" toml:"probeBackends"`
+	ProbeTimeout             string `json:"probeTimeout" yaml:"probeTimeout" toml:"probeTimeout"`
+	DefaultPort              string `json:"defaultPort" yaml:"defaultPort" toml:"defaultPort"`
+	ApiHeader                string `json:"apiHeader" yaml:"apiHeader" toml:"apiHeader"`
+	LabelField               string `json:"labelField" yaml:"labelField" toml:"labelField"`
+	DefaultHTTPEntrypoints   string `json:"defaultHTTPEntrypoints" yaml:"defaultHTTPEntrypoints" toml:"defaultHTTPEntrypoints"`
+	DefaultTCPEntrypoints    string `json:"defaultTCPEntrypoints" yaml:"defaultTCPEntrypoints" toml:"defaultTCPEntrypoints"`
+	DefaultUDPEntrypoints    string `json:"defaultUDPEntrypoints" yaml:"defaultUDPEntrypoints" toml:"defaultUDPEntrypoints"`
+	MaxServersPerService     string `json:"maxServersPerService" yaml:"maxServersPerService" toml:"maxServersPerService"`
+	PortFromTags             string `json:"portFromTags" yaml:"portFromTags" toml:"portFromTags"`
+	DebounceWindow           string `json:"debounceWindow" yaml:"debounceWindow" toml:"debounceWindow"`
+	ApiCAFile                string `json:"apiCAFile" yaml:"apiCAFile" toml:"apiCAFile"`
+	ResolveFallbackHostnames string `json:"resolveFallbackHostnames" yaml:"resolveFallbackHostnames" toml:"resolveFallbackHostnames"`
+	UseResolvedAddresses     string `json:"useResolvedAddresses" yaml:"useResolvedAddresses" toml:"useResolvedAddresses"`
+	PublishDiagnostics       string `json:"publishDiagnostics" yaml:"publishDiagnostics" toml:"publishDiagnostics"`
+	NormalizeNames           string `json:"normalizeNames" yaml:"normalizeNames" toml:"normalizeNames"`
+	ActiveStatuses           string `json:"activeStatuses" yaml:"activeStatuses" toml:"activeStatuses"`
+	RequireVersionAtStartup  string `json:"requireVersionAtStartup" yaml:"requireVersionAtStartup" toml:"requireVersionAtStartup"`
+	CloneGroupPattern        string `json:"cloneGroupPattern" yaml:"cloneGroupPattern" toml:"cloneGroupPattern"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	cfg := provider.CreateConfig()
 	return &Config{
-		PollInterval:   cfg.PollInterval,
-		ApiEndpoint:    cfg.ApiEndpoint,
-		ApiTokenId:     cfg.ApiTokenId,
-		ApiToken:       cfg.ApiToken,
-		ApiLogging:     cfg.ApiLogging,
-		ApiValidateSSL: cfg.ApiValidateSSL,
+		PollInterval:             cfg.PollInterval,
+		ApiEndpoint:              cfg.ApiEndpoint,
+		ApiTokenId:               cfg.ApiTokenId,
+		ApiToken:                 cfg.ApiToken,
+		ApiLogging:               cfg.ApiLogging,
+		ApiValidateSSL:           cfg.ApiValidateSSL,
+		PoolFilter:               cfg.PoolFilter,
+		DefaultEntrypoints:       cfg.DefaultEntrypoints,
+		SkipAgentNotReady:        cfg.SkipAgentNotReady,
+		LabelSource:              cfg.LabelSource,
+		DisableHostnameFallback:  cfg.DisableHostnameFallback,
+		IncludeVMIDs:             cfg.IncludeVMIDs,
+		ExcludeVMIDs:             cfg.ExcludeVMIDs,
+		BackendInterface:         cfg.BackendInterface,
+		ApiClientCert:            cfg.ApiClientCert,
+		ApiClientKey:             cfg.ApiClientKey,
+		ApiUser:                  cfg.ApiUser,
+		ApiPassword:              cfg.ApiPassword,
+		ApiRealm:                 cfg.ApiRealm,
+		InferScheme:              cfg.InferScheme,
+		DefaultScheme:            cfg.DefaultScheme,
+		ApiMaxResponseSize:       cfg.ApiMaxResponseSize,
+		ProviderPrefix:           cfg.ProviderPrefix,
+		AllowFastPolling:         cfg.AllowFastPolling,
+		PollJitter:               cfg.PollJitter,
+		NodeRefreshInterval:      cfg.NodeRefreshInterval,
+		RefreshListenAddress:     cfg.RefreshListenAddress,
+		ClusterNames:             cfg.ClusterNames,
+		DefaultMiddlewares:       cfg.DefaultMiddlewares,
+		DefaultMiddlewaresOrder:  cfg.DefaultMiddlewaresOrder,
+		VMIDToIP:                 cfg.VMIDToIP,
+		AutoDetectPort:           cfg.AutoDetectPort,
+		NameTemplate:             cfg.NameTemplate,
+		GuestConcurrency:         cfg.GuestConcurrency,
+		NodeEntrypoints:          cfg.NodeEntrypoints,
+		LabelPrefix:              cfg.LabelPrefix,
+		ServerURLTemplate:        cfg.ServerURLTemplate,
+		NameFilter:               cfg.NameFilter,
+		ProbeBackends:            cfg.ProbeBackends,
+		ProbeTimeout:             cfg.ProbeTimeout,
+		DefaultPort:              cfg.DefaultPort,
+		ApiHeader:                cfg.ApiHeader,
+		LabelField:               cfg.LabelField,
+		DefaultHTTPEntrypoints:   cfg.DefaultHTTPEntrypoints,
+		DefaultTCPEntrypoints:    cfg.DefaultTCPEntrypoints,
+		DefaultUDPEntrypoints:    cfg.DefaultUDPEntrypoints,
+		MaxServersPerService:     cfg.MaxServersPerService,
+		PortFromTags:             cfg.PortFromTags,
+		DebounceWindow:           cfg.DebounceWindow,
+		ApiCAFile:                cfg.ApiCAFile,
+		ResolveFallbackHostnames: cfg.ResolveFallbackHostnames,
+		UseResolvedAddresses:     cfg.UseResolvedAddresses,
+		PublishDiagnostics:       cfg.PublishDiagnostics,
+		NormalizeNames:           cfg.NormalizeNames,
+		ActiveStatuses:           cfg.ActiveStatuses,
+		RequireVersionAtStartup:  cfg.RequireVersionAtStartup,
+		CloneGroupPattern:        cfg.CloneGroupPattern,
 	}
 }
 
@@ -39,12 +141,63 @@ type Provider struct {
 // New creates a new Provider plugin.
 func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 	providerConfig := &provider.Config{
-		PollInterval:   config.PollInterval,
-		ApiEndpoint:    config.ApiEndpoint,
-		ApiTokenId:     config.ApiTokenId,
-		ApiToken:       config.ApiToken,
-		ApiLogging:     config.ApiLogging,
-		ApiValidateSSL: config.ApiValidateSSL,
+		PollInterval:             config.PollInterval,
+		ApiEndpoint:              config.ApiEndpoint,
+		ApiTokenId:               config.ApiTokenId,
+		ApiToken:                 config.ApiToken,
+		ApiLogging:               config.ApiLogging,
+		ApiValidateSSL:           config.ApiValidateSSL,
+		PoolFilter:               config.PoolFilter,
+		DefaultEntrypoints:       config.DefaultEntrypoints,
+		SkipAgentNotReady:        config.SkipAgentNotReady,
+		LabelSource:              config.LabelSource,
+		DisableHostnameFallback:  config.DisableHostnameFallback,
+		IncludeVMIDs:             config.IncludeVMIDs,
+		ExcludeVMIDs:             config.ExcludeVMIDs,
+		BackendInterface:         config.BackendInterface,
+		ApiClientCert:            config.ApiClientCert,
+		ApiClientKey:             config.ApiClientKey,
+		ApiUser:                  config.ApiUser,
+		ApiPassword:              config.ApiPassword,
+		ApiRealm:                 config.ApiRealm,
+		InferScheme:              config.InferScheme,
+		DefaultScheme:            config.DefaultScheme,
+		ApiMaxResponseSize:       config.ApiMaxResponseSize,
+		ProviderPrefix:           config.ProviderPrefix,
+		AllowFastPolling:         config.AllowFastPolling,
+		PollJitter:               config.PollJitter,
+		NodeRefreshInterval:      config.NodeRefreshInterval,
+		RefreshListenAddress:     config.RefreshListenAddress,
+		ClusterNames:             config.ClusterNames,
+		DefaultMiddlewares:       config.DefaultMiddlewares,
+		DefaultMiddlewaresOrder:  config.DefaultMiddlewaresOrder,
+		VMIDToIP:                 config.VMIDToIP,
+		AutoDetectPort:           config.AutoDetectPort,
+		NameTemplate:             config.NameTemplate,
+		GuestConcurrency:         config.GuestConcurrency,
+		NodeEntrypoints:          config.NodeEntrypoints,
+		LabelPrefix:              config.LabelPrefix,
+		ServerURLTemplate:        config.ServerURLTemplate,
+		NameFilter:               config.NameFilter,
+		ProbeBackends:            config.ProbeBackends,
+		ProbeTimeout:             config.ProbeTimeout,
+		DefaultPort:              config.DefaultPort,
+		ApiHeader:                config.ApiHeader,
+		LabelField:               config.LabelField,
+		DefaultHTTPEntrypoints:   config.DefaultHTTPEntrypoints,
+		DefaultTCPEntrypoints:    config.DefaultTCPEntrypoints,
+		DefaultUDPEntrypoints:    config.DefaultUDPEntrypoints,
+		MaxServersPerService:     config.MaxServersPerService,
+		PortFromTags:             config.PortFromTags,
+		DebounceWindow:           config.DebounceWindow,
+		ApiCAFile:                config.ApiCAFile,
+		ResolveFallbackHostnames: config.ResolveFallbackHostnames,
+		UseResolvedAddresses:     config.UseResolvedAddresses,
+		PublishDiagnostics:       config.PublishDiagnostics,
+		NormalizeNames:           config.NormalizeNames,
+		ActiveStatuses:           config.ActiveStatuses,
+		RequireVersionAtStartup:  config.RequireVersionAtStartup,
+		CloneGroupPattern:        config.CloneGroupPattern,
 	}
 
 	innerProvider, err := provider.New(ctx, providerConfig, name)
@@ -70,4 +223,4 @@ func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
 // Stop the provider.
 func (p *Provider) Stop() error {
 	return p.provider.Stop()
-} 
\ No newline at end of file
+}